@@ -1,68 +1,41 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"log"
 	"os"
 	"os/signal"
-	"strconv"
+	"strings"
 	"syscall"
-	"time"
 
 	"github.com/shatrunoff/yap_metrics/internal/config"
 	"github.com/shatrunoff/yap_metrics/internal/service"
 )
 
-func parseAgentConfig() *config.AgentConfig {
-	var pollSec int
-	var repSec int
-
-	// получаем конфиг по умолчанию
-	cfg := config.DefaultAgentConfig()
-
-	// парсим аргументы командной строки
-	flag.StringVar(&cfg.ServerURL, "a", cfg.ServerURL, "Server address host:port")
-	flag.IntVar(&pollSec, "p", int(cfg.PollInterval.Seconds()), "PollInterval (s)")
-	flag.IntVar(&repSec, "r", int(cfg.ReportInterval.Seconds()), "ReportInterval (s)")
-	flag.Parse()
-
-	cfg.PollInterval = time.Duration(pollSec) * time.Second
-	cfg.ReportInterval = time.Duration(repSec) * time.Second
-
-	if flag.NArg() > 0 {
-		log.Fatalf("ERROR: unknown arguments: %v", flag.Args())
-	}
-
-	// парсим переменные окружения
-	// ADDRESS
-	if envAddr := os.Getenv("ADDRESS"); envAddr != "" {
-		cfg.ServerURL = envAddr
-	}
-	// REPORT_INTERVAL
-	if envReportInterval := os.Getenv("REPORT_INTERVAL"); envReportInterval != "" {
-		if sec, err := strconv.Atoi(envReportInterval); err == nil {
-			cfg.ReportInterval = time.Duration(sec) * time.Second
-		}
-	}
-	// POLL_INTERVAL
-	if envPoolInterval := os.Getenv("POLL_INTERVAL"); envPoolInterval != "" {
-		if sec, err := strconv.Atoi(envPoolInterval); err == nil {
-			cfg.PollInterval = time.Duration(sec) * time.Second
-		}
-	}
-
-	return cfg
-}
-
 func main() {
 	// инициализация конфига и агента
-	cfg := parseAgentConfig()
+	cfg, err := config.ParseAgentConfig(os.Args[1:], os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatalf("ERROR: invalid configuration:\n%v", err)
+	}
 
-	agent := service.NewAgent(cfg)
+	agent, err := service.NewAgent(cfg)
+	if err != nil {
+		log.Fatalf("ERROR: failed to create agent: %v", err)
+	}
 	defer agent.Stop()
 
 	go agent.Run()
-	log.Printf("Metric collector app started with config: %+v", cfg)
+	destinations := make([]string, 0, len(cfg.DestinationList()))
+	for _, dest := range cfg.DestinationList() {
+		destinations = append(destinations, dest.Name+"="+dest.Address)
+	}
+	log.Printf("Metric collector app started: destinations %s, poll %v, report %v",
+		strings.Join(destinations, ", "), cfg.PollInterval, cfg.ReportInterval)
 
 	stopChan := make(chan os.Signal, 1)
 	signal.Notify(stopChan, os.Interrupt, syscall.SIGTERM)
@@ -1,35 +1,128 @@
 package main
 
 import (
+	"context"
+	"crypto/rsa"
+	"errors"
+	"flag"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 
+	"github.com/shatrunoff/yap_metrics/internal/aggregate"
+	"github.com/shatrunoff/yap_metrics/internal/auth"
 	"github.com/shatrunoff/yap_metrics/internal/config"
+	"github.com/shatrunoff/yap_metrics/internal/envelope"
 	"github.com/shatrunoff/yap_metrics/internal/handler"
+	"github.com/shatrunoff/yap_metrics/internal/middleware"
+	"github.com/shatrunoff/yap_metrics/internal/ratelimit"
+	"github.com/shatrunoff/yap_metrics/internal/selfmetrics"
 	"github.com/shatrunoff/yap_metrics/internal/service"
 	"github.com/shatrunoff/yap_metrics/internal/storage"
+	"github.com/shatrunoff/yap_metrics/internal/tenant"
+	"github.com/shatrunoff/yap_metrics/internal/tlsutil"
 )
 
 func main() {
-	cfg := config.ParseServerConfig()
+	cfg, err := config.ParseServerConfig(os.Args[1:], os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatalf("Invalid configuration:\n%v", err)
+	}
+	log.Printf("Effective config: %s", cfg)
+
+	if err := middleware.SetLogLevel(cfg.LogLevel); err != nil {
+		log.Fatalf("Invalid log level: %v", err)
+	}
+
 	memStorage := storage.NewMemStorage()
 
+	// Арендаторы: без файла все метрики принадлежат арендатору по умолчанию
+	tenantsCfg := &tenant.Config{}
+	if cfg.TenantsFile != "" {
+		var err error
+		if tenantsCfg, err = tenant.LoadConfig(cfg.TenantsFile); err != nil {
+			log.Fatalf("Failed to load tenants: %v", err)
+		}
+	}
+	tenantStorage := storage.NewTenantStorage(memStorage, tenantsCfg.Quota)
+
+	// Токены доступа: без файла все эндпоинты анонимные
+	authCfg, err := loadAuth(cfg.AuthFile)
+	if err != nil {
+		log.Fatalf("Failed to load auth tokens: %v", err)
+	}
+	authenticator := auth.NewAuthenticator(authCfg)
+
 	// Загрузка метрик при старте
 	if cfg.Restore {
-		if err := memStorage.LoadFromFile(cfg.FileStoragePath); err != nil {
+		if err := tenantStorage.LoadFromFile(cfg.FileStoragePath); err != nil {
 			log.Printf("WARNING: failed to load metrics from file: %v", err)
 		} else {
 			log.Printf("Metrics loaded from %s", cfg.FileStoragePath)
 		}
 	}
 
+	// Закрытый ключ для расшифровки тел запросов агентов
+	var cryptoKey *rsa.PrivateKey
+	if cfg.CryptoKey != "" {
+		var err error
+		if cryptoKey, err = envelope.LoadPrivateKey(cfg.CryptoKey); err != nil {
+			log.Fatalf("Failed to load crypto key: %v", err)
+		}
+	}
+
+	// Доверенные подсети для эндпоинтов обновления
+	trustedSubnets, err := middleware.ParseSubnets(cfg.TrustedSubnet)
+	if err != nil {
+		log.Fatalf("Failed to parse trusted subnets: %v", err)
+	}
+
+	// Ограничение частоты обновлений по клиентам
+	rateKey, err := middleware.RateKeyFunc(cfg.RateLimitKey)
+	if err != nil {
+		log.Fatalf("Failed to configure rate limit: %v", err)
+	}
+	limiter := ratelimit.NewLimiter(cfg.RateLimit, cfg.RateBurst)
+
+	// Собственные метрики сервера
+	selfMetrics := selfmetrics.NewRegistry()
+	selfMetrics.Collect(func(reg *selfmetrics.Registry) {
+		for _, id := range tenantStorage.Tenants() {
+			reg.Set("stored_metrics", map[string]string{"tenant": id}, float64(tenantStorage.Tenant(id).Len()))
+		}
+	})
+	if cfg.SelfMetricsInterval > 0 {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go selfmetrics.NewExporter(selfMetrics, memStorage).Run(ctx, cfg.SelfMetricsInterval)
+	}
+
+	// Правила агрегации вычисляются по хранилищу каждого арендатора
+	aggregationCfg, err := loadAggregation(cfg.AggregationFile)
+	if err != nil {
+		log.Fatalf("Failed to load aggregation rules: %v", err)
+	}
+	aggregation := aggregate.NewEngine(aggregationCfg, func() map[string]aggregate.Target {
+		targets := make(map[string]aggregate.Target)
+		for _, id := range tenantStorage.Tenants() {
+			targets[id] = tenantStorage.Tenant(id)
+		}
+		return targets
+	}, aggregate.WithMetrics(selfMetrics))
+	aggregationCtx, stopAggregation := context.WithCancel(context.Background())
+	defer stopAggregation()
+	go aggregation.Run(aggregationCtx, cfg.AggregationInterval)
+
 	// Создаем сервис для сохранения метрик
-	fileService := service.NewFileStorageService(memStorage, cfg.FileStoragePath, cfg.StoreInterval)
+	fileService := service.NewFileStorageService(tenantStorage, cfg.FileStoragePath, cfg.StoreInterval,
+		service.WithSaveMetrics(selfMetrics))
 
-	// Запускаем периодическое сохранение (если интервал не 0)
+	// Запускаем периодическое сохранение (при интервале 0 — синхронное)
 	fileService.Start()
 	defer fileService.Stop()
 
@@ -39,26 +132,80 @@ func main() {
 		}
 	}()
 
+	// Перенастройка по SIGHUP
+	reload := &reloader{
+		parse: func() (*config.ServerConfig, error) {
+			return config.ParseServerConfig(os.Args[1:], os.LookupEnv)
+		},
+		cfg:         cfg,
+		authCfg:     authCfg,
+		fileService: fileService,
+		auth:        authenticator,
+		limiter:     limiter,
+		aggregation: aggregation,
+		rules:       aggregationCfg,
+	}
+
 	// Создаем хэндлер с поддержкой синхронного сохранения
-	serverHandler := handler.NewHandler(memStorage, fileService, cfg.StoreInterval == 0)
+	serverHandler := handler.NewHandler(memStorage, fileService,
+		handler.WithTenants(tenantStorage, tenant.NewResolver(tenantsCfg)),
+		handler.WithAuth(authenticator),
+		handler.WithCryptoKey(cryptoKey),
+		handler.WithTrustedSubnets(trustedSubnets, cfg.TrustedRemoteAddr),
+		handler.WithRateLimit(limiter, rateKey),
+		handler.WithBodyLimits(cfg.MaxBodySize, cfg.MaxDecompressedSize),
+		handler.WithSelfMetrics(selfMetrics),
+		handler.WithAggregation(aggregation),
+		handler.WithConfig(reload.config))
 
 	server := &http.Server{
 		Addr:    cfg.ServerURL,
 		Handler: serverHandler,
 	}
 
+	// TLS включается заданием сертификата и ключа
+	useTLS := cfg.TLSCertFile != "" || cfg.TLSKeyFile != ""
+	if useTLS {
+		tlsConfig, err := tlsutil.ServerConfig(cfg.TLSCertFile, cfg.TLSKeyFile, cfg.TLSClientCAFile)
+		if err != nil {
+			log.Fatalf("Failed to configure TLS: %v", err)
+		}
+		server.TLSConfig = tlsConfig
+	}
+
 	go func() {
-		log.Printf("Server started on %s", server.Addr)
+		log.Printf("Server started on %s (TLS: %v, mTLS: %v)", server.Addr, useTLS, cfg.TLSClientCAFile != "")
 		log.Printf("Store interval: %v, File path: %s, Restore: %v",
 			cfg.StoreInterval, cfg.FileStoragePath, cfg.Restore)
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+
+		var err error
+		if useTLS {
+			// сертификаты уже в TLSConfig и перечитываются при изменении файлов
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			log.Fatalf("Server error: %v", err)
 		}
 	}()
 
+	// SIGHUP перечитывает конфигурацию
+	hupChan := make(chan os.Signal, 1)
+	signal.Notify(hupChan, syscall.SIGHUP)
+
 	stopChan := make(chan os.Signal, 1)
 	signal.Notify(stopChan, os.Interrupt, syscall.SIGTERM)
-	<-stopChan
+
+	for running := true; running; {
+		select {
+		case <-hupChan:
+			log.Printf("SIGHUP received, reloading configuration")
+			reload.reload()
+		case <-stopChan:
+			running = false
+		}
+	}
 
 	log.Printf("Server stopped on %s", server.Addr)
 }
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/shatrunoff/yap_metrics/internal/aggregate"
+	"github.com/shatrunoff/yap_metrics/internal/auth"
+	"github.com/shatrunoff/yap_metrics/internal/config"
+	"github.com/shatrunoff/yap_metrics/internal/middleware"
+	"github.com/shatrunoff/yap_metrics/internal/ratelimit"
+	"github.com/shatrunoff/yap_metrics/internal/service"
+)
+
+// части сервера, перенастраиваемые по SIGHUP без перезапуска
+type reloader struct {
+	// разбор конфигурации из тех же источников, что и при запуске
+	parse func() (*config.ServerConfig, error)
+	// действующая конфигурация; меняется только в reload, читается и из хэндлеров
+	cfgMu       sync.RWMutex
+	cfg         *config.ServerConfig
+	authCfg     *auth.Config
+	fileService *service.FileStorageService
+	auth        *auth.Authenticator
+	limiter     *ratelimit.Limiter
+	aggregation *aggregate.Engine
+	rules       *aggregate.Config
+}
+
+// файл токенов; без него аутентификация выключена
+func loadAuth(path string) (*auth.Config, error) {
+	if path == "" {
+		return nil, nil
+	}
+	return auth.LoadConfig(path)
+}
+
+// файл правил агрегации; без него правил нет
+func loadAggregation(path string) (*aggregate.Config, error) {
+	if path == "" {
+		return nil, nil
+	}
+	return aggregate.LoadConfig(path)
+}
+
+// правила агрегации одной строкой для сравнения и лога
+func ruleSet(cfg *aggregate.Config) string {
+	if cfg == nil || len(cfg.Rules) == 0 {
+		return "[]"
+	}
+	data, _ := json.Marshal(cfg.Rules)
+	return string(data)
+}
+
+// имена токенов, появившихся в next, пропавших из prev и сменивших имя или роли
+func tokenChanges(prev, next *auth.Config) (added, removed, changed []string) {
+	index := func(cfg *auth.Config) map[string]auth.Token {
+		res := make(map[string]auth.Token)
+		if cfg != nil {
+			for _, t := range cfg.Tokens {
+				res[strings.ToLower(t.SHA256)] = t
+			}
+		}
+		return res
+	}
+	prevTokens, nextTokens := index(prev), index(next)
+
+	for hash, token := range nextTokens {
+		old, ok := prevTokens[hash]
+		switch {
+		case !ok:
+			added = append(added, token.Name)
+		case old.Name != token.Name || !slices.Equal(old.Roles, token.Roles):
+			changed = append(changed, token.Name)
+		}
+	}
+	for hash, token := range prevTokens {
+		if _, ok := nextTokens[hash]; !ok {
+			removed = append(removed, token.Name)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	sort.Strings(changed)
+	return added, removed, changed
+}
+
+// действующая конфигурация
+func (r *reloader) config() *config.ServerConfig {
+	r.cfgMu.RLock()
+	defer r.cfgMu.RUnlock()
+	return r.cfg
+}
+
+// перечитывает конфигурацию и применяет изменения, не требующие перезапуска;
+// при любой ошибке текущая конфигурация остаётся нетронутой
+func (r *reloader) reload() {
+	next, err := r.parse()
+	if err != nil {
+		log.Printf("Reload rejected, invalid configuration:\n%v", err)
+		return
+	}
+
+	// файл токенов перечитываем всегда: мог измениться он сам, а не путь
+	authCfg, err := loadAuth(next.AuthFile)
+	if err != nil {
+		log.Printf("Reload rejected, failed to load auth tokens: %v", err)
+		return
+	}
+
+	// правила тоже перечитываем всегда
+	aggregationCfg, err := loadAggregation(next.AggregationFile)
+	if err != nil {
+		log.Printf("Reload rejected, failed to load aggregation rules: %v", err)
+		return
+	}
+
+	// параметры, требующие перезапуска, остаются прежними
+	applied := *r.cfg
+	changed := false
+	for _, change := range r.cfg.Diff(next) {
+		if !change.Reload {
+			log.Printf("Reload: %s requires restart, ignored", change)
+			continue
+		}
+		log.Printf("Reload: %s", change)
+		changed = true
+	}
+	applied.StoreInterval = next.StoreInterval
+	applied.LogLevel = next.LogLevel
+	applied.AuthFile = next.AuthFile
+	applied.RateLimit = next.RateLimit
+	applied.RateBurst = next.RateBurst
+	applied.AggregationFile = next.AggregationFile
+
+	if applied.StoreInterval != r.cfg.StoreInterval {
+		r.fileService.SetStoreInterval(applied.StoreInterval)
+	}
+	if applied.LogLevel != r.cfg.LogLevel {
+		// уровень уже проверен при разборе конфигурации
+		middleware.SetLogLevel(applied.LogLevel)
+	}
+	if applied.RateLimit != r.cfg.RateLimit || applied.RateBurst != r.cfg.RateBurst {
+		r.limiter.SetRate(applied.RateLimit, applied.RateBurst)
+	}
+
+	added, removed, updated := tokenChanges(r.authCfg, authCfg)
+	if len(added) > 0 || len(removed) > 0 || len(updated) > 0 || (r.authCfg == nil) != (authCfg == nil) {
+		r.auth.Update(authCfg)
+		log.Printf("Reload: auth enabled: %v, tokens added: %v, removed: %v, changed: %v",
+			authCfg != nil, added, removed, updated)
+		changed = true
+	}
+
+	if prev, next := ruleSet(r.rules), ruleSet(aggregationCfg); prev != next {
+		r.aggregation.SetConfig(aggregationCfg)
+		log.Printf("Reload: aggregation rules updated: %s", next)
+		changed = true
+	}
+
+	r.cfgMu.Lock()
+	r.cfg = &applied
+	r.cfgMu.Unlock()
+	r.rules = aggregationCfg
+	r.authCfg = authCfg
+	if !changed {
+		log.Printf("Reload: no changes")
+	}
+}
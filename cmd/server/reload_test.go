@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/shatrunoff/yap_metrics/internal/aggregate"
+	"github.com/shatrunoff/yap_metrics/internal/auth"
+	"github.com/shatrunoff/yap_metrics/internal/config"
+	"github.com/shatrunoff/yap_metrics/internal/middleware"
+	"github.com/shatrunoff/yap_metrics/internal/ratelimit"
+	"github.com/shatrunoff/yap_metrics/internal/service"
+	"github.com/shatrunoff/yap_metrics/internal/storage"
+)
+
+func TestTokenChanges(t *testing.T) {
+	token := func(name, secret string, roles ...auth.Role) auth.Token {
+		return auth.Token{Name: name, SHA256: auth.HashToken(secret), Roles: roles}
+	}
+	prev := &auth.Config{Tokens: []auth.Token{
+		token("agent", "a", auth.RoleWriter),
+		token("ops", "o", auth.RoleAdmin),
+		token("old", "x", auth.RoleReader),
+	}}
+
+	tests := []struct {
+		name                    string
+		next                    *auth.Config
+		added, removed, changed []string
+	}{
+		{name: "Same tokens", next: prev},
+		{name: "Auth disabled", next: nil, removed: []string{"agent", "old", "ops"}},
+		{
+			name: "Added and removed",
+			next: &auth.Config{Tokens: []auth.Token{
+				token("agent", "a", auth.RoleWriter),
+				token("ops", "o", auth.RoleAdmin),
+				token("new", "n", auth.RoleReader),
+			}},
+			added:   []string{"new"},
+			removed: []string{"old"},
+		},
+		{
+			name: "Roles changed",
+			next: &auth.Config{Tokens: []auth.Token{
+				token("agent", "a", auth.RoleWriter),
+				token("ops", "o", auth.RoleReader),
+				token("old", "x", auth.RoleReader),
+			}},
+			changed: []string{"ops"},
+		},
+		{
+			name: "Renamed",
+			next: &auth.Config{Tokens: []auth.Token{
+				token("collector", "a", auth.RoleWriter),
+				token("ops", "o", auth.RoleAdmin),
+				token("old", "x", auth.RoleReader),
+			}},
+			changed: []string{"collector"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			added, removed, changed := tokenChanges(prev, tt.next)
+			if !reflect.DeepEqual(added, tt.added) || !reflect.DeepEqual(removed, tt.removed) || !reflect.DeepEqual(changed, tt.changed) {
+				t.Errorf("tokenChanges() = %v, %v, %v, want %v, %v, %v",
+					added, removed, changed, tt.added, tt.removed, tt.changed)
+			}
+		})
+	}
+}
+
+func writeAuthFile(t *testing.T, path string, roles ...auth.Role) {
+	t.Helper()
+	data, err := json.Marshal(auth.Config{Tokens: []auth.Token{
+		{Name: "ops", SHA256: auth.HashToken("secret"), Roles: roles},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+	authFile := filepath.Join(dir, "auth.json")
+	writeAuthFile(t, authFile, auth.RoleAdmin)
+
+	args := []string{"-f", filepath.Join(dir, "metrics.json"), "-auth", authFile}
+	env := map[string]string{}
+	parse := func() (*config.ServerConfig, error) {
+		return config.ParseServerConfig(args, func(name string) (string, bool) {
+			value, ok := env[name]
+			return value, ok
+		})
+	}
+
+	cfg, err := parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	authCfg, err := loadAuth(cfg.AuthFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authenticator := auth.NewAuthenticator(authCfg)
+	limiter := ratelimit.NewLimiter(cfg.RateLimit, cfg.RateBurst)
+	fileService := service.NewFileStorageService(storage.NewMemStorage(), cfg.FileStoragePath, cfg.StoreInterval)
+	r := &reloader{
+		parse:       parse,
+		cfg:         cfg,
+		authCfg:     authCfg,
+		fileService: fileService,
+		auth:        authenticator,
+		limiter:     limiter,
+		aggregation: aggregate.NewEngine(nil, nil),
+	}
+	t.Cleanup(func() { middleware.SetLogLevel("info") })
+
+	// статус запроса администратора с токеном ops
+	adminStatus := func() int {
+		h := authenticator.Require(auth.RoleAdmin)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.Header.Set("Authorization", "Bearer secret")
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+	if code := adminStatus(); code != http.StatusOK {
+		t.Fatalf("admin status before reload = %d, want %d", code, http.StatusOK)
+	}
+
+	t.Run("Invalid config keeps current settings", func(t *testing.T) {
+		env["STORE_INTERVAL"] = "often"
+		writeAuthFile(t, authFile, auth.RoleReader)
+		r.reload()
+		delete(env, "STORE_INTERVAL")
+		writeAuthFile(t, authFile, auth.RoleAdmin)
+
+		if code := adminStatus(); code != http.StatusOK {
+			t.Errorf("admin status = %d, want %d", code, http.StatusOK)
+		}
+	})
+
+	t.Run("Role downgrade is applied", func(t *testing.T) {
+		writeAuthFile(t, authFile, auth.RoleReader)
+		r.reload()
+
+		if code := adminStatus(); code != http.StatusForbidden {
+			t.Errorf("admin status after downgrade = %d, want %d", code, http.StatusForbidden)
+		}
+	})
+
+	t.Run("Reloadable settings are applied", func(t *testing.T) {
+		env["STORE_INTERVAL"] = "10s"
+		env["LOG_LEVEL"] = "debug"
+		env["RATE_LIMIT"] = "1"
+		env["RATE_BURST"] = "1"
+		r.reload()
+
+		if got := r.config().StoreInterval; got != 10*time.Second {
+			t.Errorf("effective store interval = %v, want 10s", got)
+		}
+		if got := fileService.StoreInterval(); got != 10*time.Second {
+			t.Errorf("store interval = %v, want 10s", got)
+		}
+		if got := middleware.LogLevel(); got != "debug" {
+			t.Errorf("log level = %s, want debug", got)
+		}
+		if ok, _ := limiter.Take("client"); !ok {
+			t.Error("first request rejected")
+		}
+		if ok, _ := limiter.Take("client"); ok {
+			t.Error("rate limit not applied")
+		}
+	})
+
+	t.Run("Restart-only settings are ignored", func(t *testing.T) {
+		env["ADDRESS"] = ":9999"
+		r.reload()
+		delete(env, "ADDRESS")
+
+		if r.config().ServerURL != cfg.ServerURL {
+			t.Errorf("address = %s, want unchanged %s", r.config().ServerURL, cfg.ServerURL)
+		}
+	})
+}
@@ -2,9 +2,9 @@ module github.com/shatrunoff/yap_metrics
 
 go 1.24.4
 
-require github.com/go-chi/chi/v5 v5.2.2
-
 require (
-	go.uber.org/multierr v1.11.0 // indirect
-	go.uber.org/zap v1.27.0 // indirect
+	github.com/go-chi/chi/v5 v5.2.2
+	go.uber.org/zap v1.27.0
 )
+
+require go.uber.org/multierr v1.11.0 // indirect
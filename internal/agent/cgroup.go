@@ -0,0 +1,235 @@
+package agent
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	model "github.com/shatrunoff/yap_metrics/internal/model"
+)
+
+// CgroupCollector читает ресурсы cgroup v2, в которой запущен агент:
+// память, процессор, ввод-вывод и число процессов. Накопительные значения
+// (время процессора, троттлинг, OOM, байты I/O) отправляются как counter.
+// На cgroup v1 и без нужных файлов сборщик ничего не отдаёт.
+type CgroupCollector struct {
+	procRoot   string
+	cgroupRoot string
+
+	mu       sync.Mutex
+	dir      string
+	resolved bool
+	counters *counterTracker
+}
+
+// procRoot — каталог procfs, пустой — /proc; cgroupRoot — точка монтирования cgroup2,
+// пустой — /sys/fs/cgroup
+func NewCgroupCollector(procRoot, cgroupRoot string) *CgroupCollector {
+	if procRoot == "" {
+		procRoot = "/proc"
+	}
+	if cgroupRoot == "" {
+		cgroupRoot = "/sys/fs/cgroup"
+	}
+	return &CgroupCollector{
+		procRoot:   procRoot,
+		cgroupRoot: cgroupRoot,
+		counters:   newCounterTracker(),
+	}
+}
+
+func (cc *CgroupCollector) Name() string { return "cgroup" }
+
+func (cc *CgroupCollector) Interval() time.Duration { return 0 }
+
+// каталог cgroup v2 агента из <procRoot>/self/cgroup ("0::/path")
+func (cc *CgroupCollector) discover() (string, error) {
+	if _, err := os.Stat(filepath.Join(cc.cgroupRoot, "cgroup.controllers")); err != nil {
+		return "", fmt.Errorf("cgroup v2 is not mounted at %s", cc.cgroupRoot)
+	}
+	data, err := os.ReadFile(filepath.Join(cc.procRoot, "self", "cgroup"))
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if path, ok := strings.CutPrefix(line, "0::"); ok {
+			return filepath.Join(cc.cgroupRoot, path), nil
+		}
+	}
+	return "", errors.New("no cgroup v2 entry, host uses cgroup v1")
+}
+
+func (cc *CgroupCollector) Collect(ctx context.Context) ([]model.Metrics, error) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	if !cc.resolved {
+		cc.resolved = true
+		dir, err := cc.discover()
+		if err != nil {
+			log.Printf("WARNING: cgroup collector disabled: %v", err)
+		} else {
+			log.Printf("Collecting cgroup metrics from %s", dir)
+		}
+		cc.dir = dir
+	}
+	if cc.dir == "" {
+		return nil, nil
+	}
+
+	var res []model.Metrics
+	var errs []error
+	seen := make(map[string]bool)
+	gauge := func(name string, value uint64) {
+		v := float64(value)
+		res = append(res, model.Metrics{ID: name, MType: model.Gauge, Value: &v})
+	}
+	// отсутствующий файл — контроллер не включён, это не ошибка
+	check := func(err error) bool {
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+		}
+		return err == nil
+	}
+
+	if value, set, err := cc.readValue("memory.current"); check(err) && set {
+		gauge("CgroupMemoryCurrent", value)
+	}
+	// "max" — без ограничения, метрики нет
+	if value, set, err := cc.readValue("memory.max"); check(err) && set {
+		gauge("CgroupMemoryMax", value)
+	}
+	if value, set, err := cc.readValue("pids.current"); check(err) && set {
+		gauge("CgroupPids", value)
+	}
+	if value, set, err := cc.readValue("pids.max"); check(err) && set {
+		gauge("CgroupPidsMax", value)
+	}
+
+	if stat, err := cc.readKeyed("cpu.stat"); check(err) {
+		// поля троттлинга есть, только если включён контроллер cpu
+		counters := presentCounters(stat, map[string]string{
+			"usage_usec":     "CgroupCPUUsageUsec",
+			"user_usec":      "CgroupCPUUserUsec",
+			"system_usec":    "CgroupCPUSystemUsec",
+			"nr_periods":     "CgroupCPUPeriods",
+			"nr_throttled":   "CgroupCPUThrottledPeriods",
+			"throttled_usec": "CgroupCPUThrottledUsec",
+		})
+		res = append(res, emitCounters(cc.counters, counters, nil, seen)...)
+	}
+
+	if events, err := cc.readKeyed("memory.events"); check(err) {
+		counters := presentCounters(events, map[string]string{
+			"high":     "CgroupMemoryHighEvents",
+			"max":      "CgroupMemoryMaxEvents",
+			"oom":      "CgroupOOMEvents",
+			"oom_kill": "CgroupOOMKills",
+		})
+		res = append(res, emitCounters(cc.counters, counters, nil, seen)...)
+	}
+
+	if devices, err := cc.readIOStat(); check(err) {
+		for device, stat := range devices {
+			counters := presentCounters(stat, map[string]string{
+				"rbytes": "CgroupIOReadBytes",
+				"wbytes": "CgroupIOWriteBytes",
+				"rios":   "CgroupIOReads",
+				"wios":   "CgroupIOWrites",
+			})
+			res = append(res, emitCounters(cc.counters, counters, map[string]string{"device": device}, seen)...)
+		}
+	}
+
+	cc.counters.retain(seen)
+	return res, errors.Join(errs...)
+}
+
+// counter по ключам файла, которые в нём есть; names — ключ -> имя метрики
+func presentCounters(stat map[string]uint64, names map[string]string) []kernelCounter {
+	res := make([]kernelCounter, 0, len(names))
+	for key, name := range names {
+		if value, ok := stat[key]; ok {
+			res = append(res, kernelCounter{name: name, value: value})
+		}
+	}
+	return res
+}
+
+// однострочный файл с числом или "max"; set == false для "max"
+func (cc *CgroupCollector) readValue(name string) (value uint64, set bool, err error) {
+	data, err := os.ReadFile(filepath.Join(cc.dir, name))
+	if err != nil {
+		return 0, false, err
+	}
+	s := strings.TrimSpace(string(data))
+	if s == "max" {
+		return 0, false, nil
+	}
+	if value, err = strconv.ParseUint(s, 10, 64); err != nil {
+		return 0, false, fmt.Errorf("%s: %w", name, err)
+	}
+	return value, true, nil
+}
+
+// файл из строк "ключ значение"
+func (cc *CgroupCollector) readKeyed(name string) (map[string]uint64, error) {
+	file, err := os.Open(filepath.Join(cc.dir, name))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	res := make(map[string]uint64)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		value, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s: %w", name, fields[0], err)
+		}
+		res[fields[0]] = value
+	}
+	return res, scanner.Err()
+}
+
+// io.stat: "MAJ:MIN rbytes=1 wbytes=2 rios=3 wios=4 ..." по устройствам
+func (cc *CgroupCollector) readIOStat() (map[string]map[string]uint64, error) {
+	data, err := os.ReadFile(filepath.Join(cc.dir, "io.stat"))
+	if err != nil {
+		return nil, err
+	}
+
+	res := make(map[string]map[string]uint64)
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		stat := make(map[string]uint64, len(fields)-1)
+		for _, kv := range fields[1:] {
+			key, raw, ok := strings.Cut(kv, "=")
+			if !ok {
+				continue
+			}
+			value, err := strconv.ParseUint(raw, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("io.stat: %s: %w", fields[0], err)
+			}
+			stat[key] = value
+		}
+		res[fields[0]] = stat
+	}
+	return res, nil
+}
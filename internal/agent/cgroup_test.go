@@ -0,0 +1,116 @@
+package agent
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// поддельные /proc и /sys/fs/cgroup с cgroup агента /system.slice/agent.service
+func fakeCgroup(t *testing.T, files map[string]string) (procRoot, cgroupRoot string) {
+	t.Helper()
+	procRoot, cgroupRoot = t.TempDir(), t.TempDir()
+	writeFixture(t, filepath.Join(procRoot, "self", "cgroup"), "0::/system.slice/agent.service\n")
+	writeFixture(t, filepath.Join(cgroupRoot, "cgroup.controllers"), "cpu io memory pids\n")
+	for name, content := range files {
+		writeFixture(t, filepath.Join(cgroupRoot, "system.slice", "agent.service", name), content)
+	}
+	return procRoot, cgroupRoot
+}
+
+func TestCgroupCollector(t *testing.T) {
+	files := map[string]string{
+		"memory.current": "104857600\n",
+		"memory.max":     "268435456\n",
+		"pids.current":   "12\n",
+		"pids.max":       "max\n",
+		"cpu.stat": `usage_usec 5000000
+user_usec 3000000
+system_usec 2000000
+nr_periods 100
+nr_throttled 10
+throttled_usec 400000
+`,
+		"memory.events": "low 0\nhigh 0\nmax 3\noom 1\noom_kill 1\n",
+		"io.stat":       "8:0 rbytes=4096 wbytes=8192 rios=1 wios=2 dbytes=0 dios=0\n",
+	}
+	procRoot, cgroupRoot := fakeCgroup(t, files)
+	cc := NewCgroupCollector(procRoot, cgroupRoot)
+
+	first := collectValues(t, cc)
+	gauges := map[string]float64{
+		"CgroupMemoryCurrent": 104857600,
+		"CgroupMemoryMax":     268435456,
+		"CgroupPids":          12,
+	}
+	for id, want := range gauges {
+		if got, ok := first[id]; !ok || got != want {
+			t.Errorf("%s = %v (present %v), want %v", id, got, ok, want)
+		}
+	}
+	if _, ok := first["CgroupPidsMax"]; ok {
+		t.Errorf("unlimited pids.max reported")
+	}
+
+	// контейнер троттлили и убили по OOM ещё раз
+	dir := filepath.Join(cgroupRoot, "system.slice", "agent.service")
+	writeFixture(t, filepath.Join(dir, "cpu.stat"), `usage_usec 5500000
+user_usec 3300000
+system_usec 2200000
+nr_periods 110
+nr_throttled 15
+throttled_usec 650000
+`)
+	writeFixture(t, filepath.Join(dir, "memory.events"), "low 0\nhigh 0\nmax 5\noom 2\noom_kill 2\n")
+	writeFixture(t, filepath.Join(dir, "io.stat"), "8:0 rbytes=8192 wbytes=8192 rios=2 wios=2 dbytes=0 dios=0\n")
+
+	second := collectValues(t, cc)
+	counters := map[string]float64{
+		"CgroupCPUUsageUsec":              500000,
+		"CgroupCPUThrottledPeriods":       5,
+		"CgroupCPUThrottledUsec":          250000,
+		"CgroupOOMEvents":                 1,
+		"CgroupOOMKills":                  1,
+		"CgroupMemoryMaxEvents":           2,
+		`CgroupIOReadBytes{device="8:0"}`: 4096,
+		`CgroupIOWrites{device="8:0"}`:    0,
+	}
+	for id, want := range counters {
+		if got, ok := second[id]; !ok || got != want {
+			t.Errorf("%s = %v (present %v), want %v", id, got, ok, want)
+		}
+	}
+}
+
+func TestCgroupCollectorDegrades(t *testing.T) {
+	t.Run("Missing controllers", func(t *testing.T) {
+		// без контроллеров cpu и io есть только базовые файлы
+		procRoot, cgroupRoot := fakeCgroup(t, map[string]string{
+			"memory.current": "1024\n",
+			"cpu.stat":       "usage_usec 10\nuser_usec 5\nsystem_usec 5\n",
+		})
+		got := collectValues(t, NewCgroupCollector(procRoot, cgroupRoot))
+		if len(got) != 4 {
+			t.Errorf("got %v, want memory.current and three cpu usage counters", got)
+		}
+		if _, ok := got["CgroupCPUThrottledPeriods"]; ok {
+			t.Errorf("throttling reported without cpu controller")
+		}
+	})
+
+	t.Run("cgroup v1", func(t *testing.T) {
+		procRoot, cgroupRoot := t.TempDir(), t.TempDir()
+		writeFixture(t, filepath.Join(procRoot, "self", "cgroup"), "12:memory:/docker/abc\n11:cpu,cpuacct:/docker/abc\n")
+		if got := collectValues(t, NewCgroupCollector(procRoot, cgroupRoot)); len(got) != 0 {
+			t.Errorf("got %v on cgroup v1, want nothing", got)
+		}
+	})
+
+	t.Run("Hybrid without v2 entry", func(t *testing.T) {
+		procRoot, cgroupRoot := t.TempDir(), t.TempDir()
+		writeFixture(t, filepath.Join(procRoot, "self", "cgroup"), "12:memory:/docker/abc\n")
+		writeFixture(t, filepath.Join(cgroupRoot, "cgroup.controllers"), "\n")
+		if got := collectValues(t, NewCgroupCollector(procRoot, cgroupRoot)); len(got) != 0 {
+			t.Errorf("got %v without v2 entry, want nothing", got)
+		}
+	})
+}
@@ -1,28 +1,65 @@
 package agent
 
 import (
+	"context"
+	"fmt"
+	"log"
 	"maps"
-	"math/rand"
-	"runtime"
 	"sync"
-	"sync/atomic"
 	"time"
 
 	model "github.com/shatrunoff/yap_metrics/internal/model"
 )
 
+// Collector — источник метрик агента
+type Collector interface {
+	// уникальное имя, по нему сборщик настраивается в конфигурации
+	Name() string
+	// собственный интервал сбора, 0 — общий интервал опроса
+	Interval() time.Duration
+	// gauge заменяют прежние значения, counter содержат приращения
+	Collect(ctx context.Context) ([]model.Metrics, error)
+}
+
+// сборщик с фактическим интервалом
+type scheduled struct {
+	collector Collector
+	interval  time.Duration
+}
+
+// MetricsCollector запускает сборщики и накапливает их метрики: gauge хранят
+// последнее значение, counter — сумму приращений с запуска агента; приращения
+// для каждого сервера отдельно считает DeltaTracker
 type MetricsCollector struct {
 	runtimeMetrics map[string]model.Metrics
-	PollCount      int64
+	collectors     []scheduled
 	mu             sync.RWMutex
-	rand           *rand.Rand
+	// префикс self-метрик сборщиков, пустой — не собираются
+	selfPrefix string
 }
 
-func NewMetricsCollector() *MetricsCollector {
-	return &MetricsCollector{
+type CollectorOption func(*MetricsCollector)
+
+// включает self-метрики: длительность сборов, ошибки, пропуски и отброшенные метрики
+func WithSelfMetrics(prefix string) CollectorOption {
+	return func(mc *MetricsCollector) {
+		mc.selfPrefix = prefix
+	}
+}
+
+func NewMetricsCollector(opts ...CollectorOption) *MetricsCollector {
+	mc := &MetricsCollector{
 		runtimeMetrics: make(map[string]model.Metrics),
-		rand:           rand.New(rand.NewSource(time.Now().UnixNano())),
 	}
+	for _, opt := range opts {
+		opt(mc)
+	}
+	return mc
+}
+
+// регистрирует сборщик; вызывается до Run
+func (mc *MetricsCollector) Add(c Collector, interval time.Duration) {
+	mc.collectors = append(mc.collectors, scheduled{collector: c, interval: interval})
 }
 
 // обновление gauge
@@ -37,7 +74,8 @@ func (mc *MetricsCollector) updateGauge(name string, value float64) {
 // обновление counter
 func (mc *MetricsCollector) updateCounter(name string, delta int64) {
 	if exist, ok := mc.runtimeMetrics[name]; ok && exist.Delta != nil {
-		*exist.Delta += delta
+		d := *exist.Delta + delta
+		exist.Delta = &d
 		mc.runtimeMetrics[name] = exist
 	} else {
 		d := delta
@@ -49,59 +87,119 @@ func (mc *MetricsCollector) updateCounter(name string, delta int64) {
 	}
 }
 
-// сбор метрик
-func (mc *MetricsCollector) Collect() {
+// Record добавляет метрики к накопленным: gauge заменяются, counter суммируются
+func (mc *MetricsCollector) Record(metrics ...model.Metrics) {
+	mc.store(metrics)
+}
+
+// сохраняет результат одного сбора
+func (mc *MetricsCollector) store(metrics []model.Metrics) {
 	mc.mu.Lock()
 	defer mc.mu.Unlock()
 
-	var memStats runtime.MemStats
-	runtime.ReadMemStats(&memStats)
-
-	// потокобезопасный ++
-	atomic.AddInt64(&mc.PollCount, 1)
-
-	// Генерируем случайное значение
-	randomValue := mc.rand.Float64()
-
-	// Создаем мапу метрик gauge
-	gaugeMetrics := map[string]float64{
-		"Alloc":         float64(memStats.Alloc),
-		"BuckHashSys":   float64(memStats.BuckHashSys),
-		"Frees":         float64(memStats.Frees),
-		"GCCPUFraction": memStats.GCCPUFraction,
-		"GCSys":         float64(memStats.GCSys),
-		"HeapAlloc":     float64(memStats.HeapAlloc),
-		"HeapIdle":      float64(memStats.HeapIdle),
-		"HeapInuse":     float64(memStats.HeapInuse),
-		"HeapObjects":   float64(memStats.HeapObjects),
-		"HeapReleased":  float64(memStats.HeapReleased),
-		"HeapSys":       float64(memStats.HeapSys),
-		"LastGC":        float64(memStats.LastGC),
-		"Lookups":       float64(memStats.Lookups),
-		"MCacheInuse":   float64(memStats.MCacheInuse),
-		"MCacheSys":     float64(memStats.MCacheSys),
-		"MSpanInuse":    float64(memStats.MSpanInuse),
-		"MSpanSys":      float64(memStats.MSpanSys),
-		"Mallocs":       float64(memStats.Mallocs),
-		"NextGC":        float64(memStats.NextGC),
-		"NumForcedGC":   float64(memStats.NumForcedGC),
-		"NumGC":         float64(memStats.NumGC),
-		"OtherSys":      float64(memStats.OtherSys),
-		"PauseTotalNs":  float64(memStats.PauseTotalNs),
-		"StackInuse":    float64(memStats.StackInuse),
-		"StackSys":      float64(memStats.StackSys),
-		"Sys":           float64(memStats.Sys),
-		"TotalAlloc":    float64(memStats.TotalAlloc),
-		"RandomValue":   randomValue, // Добавляем случайное значение
+	for _, metric := range metrics {
+		switch {
+		case metric.MType == model.Gauge && metric.Value != nil:
+			mc.updateGauge(metric.ID, *metric.Value)
+		case metric.MType == model.Counter && metric.Delta != nil:
+			mc.updateCounter(metric.ID, *metric.Delta)
+		}
+	}
+}
+
+// Run запускает каждый сборщик в своей горутине и блокируется до отмены ctx.
+// Паника или зависание одного сборщика не задерживает остальные.
+func (mc *MetricsCollector) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, s := range mc.collectors {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			mc.loop(ctx, s)
+		}()
 	}
+	wg.Wait()
+}
 
-	// Обновляем метрики gauge в цикле
-	for name, value := range gaugeMetrics {
-		mc.updateGauge(name, value)
+// цикл одного сборщика; новый сбор не начинается, пока не закончился предыдущий
+func (mc *MetricsCollector) loop(ctx context.Context, s scheduled) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	busy := make(chan struct{}, 1)
+	for {
+		select {
+		case <-ticker.C:
+			select {
+			case busy <- struct{}{}:
+			default:
+				log.Printf("WARNING: collector %s is still running, skipping tick", s.collector.Name())
+				mc.recordSelf(s.collector.Name(), "CollectorSkipped", 1)
+				continue
+			}
+			go func() {
+				defer func() { <-busy }()
+				mc.collectOnce(ctx, s)
+			}()
+		case <-ctx.Done():
+			return
+		}
 	}
+}
+
+// один сбор с ограничением по времени в один интервал
+func (mc *MetricsCollector) collectOnce(ctx context.Context, s scheduled) {
+	name := s.collector.Name()
+
+	ctx, cancel := context.WithTimeout(ctx, s.interval)
+	defer cancel()
 
-	// counter
-	mc.updateCounter("PollCount", 1)
+	start := time.Now()
+	metrics, err := safeCollect(ctx, s.collector)
+	mc.recordDuration(name, time.Since(start))
+	if err != nil {
+		log.Printf("ERROR: collector %s: %v", name, err)
+		mc.recordSelf(name, "CollectorErrors", 1)
+	}
+	// результат, полученный после таймаута, устарел
+	if ctx.Err() != nil {
+		log.Printf("WARNING: collector %s exceeded %v, result dropped", name, s.interval)
+		if err == nil {
+			mc.recordSelf(name, "CollectorErrors", 1)
+		}
+		mc.recordSelf(name, "DroppedMetrics", int64(len(metrics)))
+		return
+	}
+	mc.store(metrics)
+}
+
+// self-counter с меткой сборщика
+func (mc *MetricsCollector) recordSelf(collector, name string, delta int64) {
+	if mc.selfPrefix == "" || delta == 0 {
+		return
+	}
+	id := model.LabeledID(mc.selfPrefix+name, map[string]string{"collector": collector})
+	mc.store([]model.Metrics{{ID: id, MType: model.Counter, Delta: &delta}})
+}
+
+// длительность последнего сбора в секундах
+func (mc *MetricsCollector) recordDuration(collector string, d time.Duration) {
+	if mc.selfPrefix == "" {
+		return
+	}
+	id := model.LabeledID(mc.selfPrefix+"CollectorDurationSeconds", map[string]string{"collector": collector})
+	seconds := d.Seconds()
+	mc.store([]model.Metrics{{ID: id, MType: model.Gauge, Value: &seconds}})
+}
+
+// вызывает Collect, превращая панику в ошибку
+func safeCollect(ctx context.Context, c Collector) (metrics []model.Metrics, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			metrics, err = nil, fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return c.Collect(ctx)
 }
 
 // получение текущих метрик
@@ -0,0 +1,148 @@
+package agent
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	model "github.com/shatrunoff/yap_metrics/internal/model"
+)
+
+// тестовый сборщик с подменяемым поведением
+type funcCollector struct {
+	name    string
+	collect func(ctx context.Context) ([]model.Metrics, error)
+}
+
+func (f funcCollector) Name() string            { return f.name }
+func (f funcCollector) Interval() time.Duration { return 0 }
+func (f funcCollector) Collect(ctx context.Context) ([]model.Metrics, error) {
+	return f.collect(ctx)
+}
+
+func counterMetric(name string, delta int64) []model.Metrics {
+	return []model.Metrics{{ID: name, MType: model.Counter, Delta: &delta}}
+}
+
+func TestMetricsCollectorIsolation(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+
+	tests := []struct {
+		name    string
+		faulty  funcCollector
+		wantBad bool
+	}{
+		{
+			name: "Panicking collector",
+			faulty: funcCollector{name: "bad", collect: func(ctx context.Context) ([]model.Metrics, error) {
+				panic("boom")
+			}},
+		},
+		{
+			name: "Collector ignoring context",
+			faulty: funcCollector{name: "bad", collect: func(ctx context.Context) ([]model.Metrics, error) {
+				<-block
+				return counterMetric("Bad", 1), nil
+			}},
+		},
+		{
+			name: "Slow collector result dropped",
+			faulty: funcCollector{name: "bad", collect: func(ctx context.Context) ([]model.Metrics, error) {
+				<-ctx.Done()
+				return counterMetric("Bad", 1), ctx.Err()
+			}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mc := NewMetricsCollector()
+			mc.Add(tt.faulty, 10*time.Millisecond)
+			mc.Add(funcCollector{name: "good", collect: func(ctx context.Context) ([]model.Metrics, error) {
+				return counterMetric("Good", 1), nil
+			}}, 10*time.Millisecond)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+			defer cancel()
+			mc.Run(ctx)
+
+			got := mc.GetMetrics()
+			if good, ok := got["Good"]; !ok || *good.Delta < 5 {
+				t.Errorf("healthy collector stalled: %v", got["Good"])
+			}
+			if _, ok := got["Bad"]; ok != tt.wantBad {
+				t.Errorf("faulty collector result stored = %v, want %v", ok, tt.wantBad)
+			}
+		})
+	}
+}
+
+func TestRuntimeCollector(t *testing.T) {
+	metrics, err := NewRuntimeCollector().Collect(context.Background())
+	if err != nil {
+		t.Fatalf("Collect() error = %v", err)
+	}
+
+	byID := make(map[string]model.Metrics, len(metrics))
+	for _, m := range metrics {
+		byID[m.ID] = m
+	}
+	for _, name := range []string{"Alloc", "HeapAlloc", "RandomValue"} {
+		if m, ok := byID[name]; !ok || m.MType != model.Gauge || m.Value == nil {
+			t.Errorf("missing gauge %s", name)
+		}
+	}
+	if m, ok := byID["PollCount"]; !ok || m.MType != model.Counter || *m.Delta != 1 {
+		t.Errorf("PollCount = %v, want counter delta 1", m)
+	}
+}
+
+func TestMetricsCollectorSelfMetrics(t *testing.T) {
+	tests := []struct {
+		name   string
+		prefix string
+		want   []string
+	}{
+		{
+			name:   "Self metrics under prefix",
+			prefix: "Agent",
+			want: []string{
+				`AgentCollectorDurationSeconds{collector="ok"}`,
+				`AgentCollectorDurationSeconds{collector="failing"}`,
+				`AgentCollectorErrors{collector="failing"}`,
+			},
+		},
+		{
+			name:   "Disabled without prefix",
+			prefix: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mc := NewMetricsCollector(WithSelfMetrics(tt.prefix))
+			mc.Add(funcCollector{name: "ok", collect: func(ctx context.Context) ([]model.Metrics, error) {
+				return counterMetric("Good", 1), nil
+			}}, 10*time.Millisecond)
+			mc.Add(funcCollector{name: "failing", collect: func(ctx context.Context) ([]model.Metrics, error) {
+				return nil, context.Canceled
+			}}, 10*time.Millisecond)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			mc.Run(ctx)
+
+			got := mc.GetMetrics()
+			for _, id := range tt.want {
+				if _, ok := got[id]; !ok {
+					t.Errorf("missing self metric %s", id)
+				}
+			}
+			// кроме self-метрик только метрика рабочего сборщика
+			if len(got) != len(tt.want)+1 {
+				t.Errorf("got %d metrics, want %d: %v", len(got), len(tt.want)+1, got)
+			}
+		})
+	}
+}
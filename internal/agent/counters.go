@@ -0,0 +1,45 @@
+package agent
+
+import "math"
+
+// counterTracker превращает накопительные значения ядра в приращения для counter
+type counterTracker struct {
+	prev map[string]uint64
+}
+
+func newCounterTracker() *counterTracker {
+	return &counterTracker{prev: make(map[string]uint64)}
+}
+
+// приращение с прошлого сбора; первое наблюдение только запоминается,
+// иначе при старте агента на сервер ушли бы значения с загрузки системы
+func (ct *counterTracker) delta(key string, value uint64) int64 {
+	prev, ok := ct.prev[key]
+	ct.prev[key] = value
+	if !ok {
+		return 0
+	}
+	return int64(counterDelta(prev, value))
+}
+
+// забывает ключи, не встреченные в последнем сборе
+func (ct *counterTracker) retain(seen map[string]bool) {
+	for key := range ct.prev {
+		if !seen[key] {
+			delete(ct.prev, key)
+		}
+	}
+}
+
+// Приращение счётчика с учётом переполнения. Уменьшение считается переполнением
+// 32-битного счётчика, если прежнее значение было в верхней половине диапазона,
+// иначе — сбросом (устройство пересоздано), и приращением служит новое значение.
+func counterDelta(prev, value uint64) uint64 {
+	if value >= prev {
+		return value - prev
+	}
+	if prev <= math.MaxUint32 && prev > math.MaxUint32/2 {
+		return value + (math.MaxUint32 + 1 - prev)
+	}
+	return value
+}
@@ -0,0 +1,84 @@
+package agent
+
+import (
+	"errors"
+	"maps"
+
+	model "github.com/shatrunoff/yap_metrics/internal/model"
+)
+
+// PartialError — отправка прервана ошибкой, но метрики Sent сервер уже принял
+type PartialError struct {
+	Sent []string
+	Err  error
+}
+
+func (e *PartialError) Error() string { return e.Err.Error() }
+
+func (e *PartialError) Unwrap() error { return e.Err }
+
+// ошибка отправки с учётом уже принятых метрик
+func partial(sent []string, err error) error {
+	if len(sent) == 0 {
+		return err
+	}
+	return &PartialError{Sent: sent, Err: err}
+}
+
+// Unsent возвращает метрики пачки, которые сервер не принял из-за err;
+// nil, если отправка удалась
+func Unsent(metrics map[string]model.Metrics, err error) map[string]model.Metrics {
+	if err == nil {
+		return nil
+	}
+	var pe *PartialError
+	if !errors.As(err, &pe) {
+		return metrics
+	}
+	res := maps.Clone(metrics)
+	for _, id := range pe.Sent {
+		delete(res, id)
+	}
+	return res
+}
+
+// DeltaTracker помнит, какая часть накопленных счётчиков уже принята сервером,
+// чтобы отправлять только приращения с последней успешной отправки.
+// Неподтверждённые приращения остаются и уходят со следующей пачкой.
+type DeltaTracker struct {
+	acked map[string]int64
+}
+
+func NewDeltaTracker() *DeltaTracker {
+	return &DeltaTracker{acked: make(map[string]int64)}
+}
+
+// Pending превращает снимок с накопленными счётчиками в пачку для отправки:
+// gauge передаются как есть, counter — приращением сверх принятого.
+// Нулевые приращения пропускаются, кроме ещё ни разу не отправленных счётчиков.
+func (t *DeltaTracker) Pending(snapshot map[string]model.Metrics) map[string]model.Metrics {
+	res := make(map[string]model.Metrics, len(snapshot))
+	for id, metric := range snapshot {
+		if metric.MType != model.Counter || metric.Delta == nil {
+			res[id] = metric
+			continue
+		}
+		acked, ok := t.acked[id]
+		delta := *metric.Delta - acked
+		if delta == 0 && ok {
+			continue
+		}
+		res[id] = model.Metrics{ID: metric.ID, MType: model.Counter, Delta: &delta}
+	}
+	return res
+}
+
+// Ack отмечает приращения пачки принятыми, кроме оставшихся в unsent
+func (t *DeltaTracker) Ack(batch, unsent map[string]model.Metrics) {
+	for id, metric := range batch {
+		if _, ok := unsent[id]; ok || metric.MType != model.Counter || metric.Delta == nil {
+			continue
+		}
+		t.acked[id] += *metric.Delta
+	}
+}
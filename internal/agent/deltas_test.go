@@ -0,0 +1,173 @@
+package agent
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	model "github.com/shatrunoff/yap_metrics/internal/model"
+	"github.com/shatrunoff/yap_metrics/internal/storage"
+)
+
+func TestDeltaTracker(t *testing.T) {
+	tracker := NewDeltaTracker()
+
+	steps := []struct {
+		name      string
+		pollCount int64
+		failed    bool
+		want      map[string]int64
+	}{
+		{name: "First report sends the total", pollCount: 3, want: map[string]int64{"PollCount": 3}},
+		{name: "Failed report keeps the delta", pollCount: 5, failed: true, want: map[string]int64{"PollCount": 2}},
+		{name: "Next report adds unacknowledged delta", pollCount: 6, want: map[string]int64{"PollCount": 3}},
+		{name: "Unchanged counter is skipped", pollCount: 6, want: map[string]int64{}},
+	}
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			pending := tracker.Pending(batch(step.pollCount, 1))
+			if _, ok := pending["Alloc"]; !ok {
+				t.Error("gauge missing from pending batch")
+			}
+			for id, want := range step.want {
+				if m, ok := pending[id]; !ok || *m.Delta != want {
+					t.Errorf("%s delta = %v, want %d", id, m.Delta, want)
+				}
+			}
+			if len(pending) != len(step.want)+1 {
+				t.Errorf("pending = %v, want counters %v", pending, step.want)
+			}
+
+			var unsent map[string]model.Metrics
+			if step.failed {
+				unsent = pending
+			}
+			tracker.Ack(pending, unsent)
+		})
+	}
+}
+
+// сервер, принимающий все способы отправки; пока flaky, отказывает в каждом третьем запросе
+func flakyServer(t *testing.T, flaky *atomic.Bool) (*httptest.Server, *storage.MemStorage) {
+	st := storage.NewMemStorage()
+	var mu sync.Mutex
+	requests := 0
+
+	apply := func(metrics ...model.Metrics) {
+		for _, m := range metrics {
+			if m.MType == model.Counter {
+				st.UpdateCounter(m.ID, *m.Delta)
+			} else {
+				st.UpdateGauge(m.ID, *m.Value)
+			}
+		}
+	}
+	decode := func(r *http.Request, v any) error {
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			return err
+		}
+		return json.NewDecoder(gz).Decode(v)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if requests++; flaky.Load() && requests%3 == 0 {
+			http.Error(w, "ERROR: unavailable", http.StatusServiceUnavailable)
+			return
+		}
+
+		switch {
+		case r.URL.Path == "/update/":
+			var m model.Metrics
+			if err := decode(r, &m); err != nil {
+				t.Errorf("decode: %v", err)
+			}
+			apply(m)
+		case r.URL.Path == "/updates/":
+			var ms []model.Metrics
+			if err := decode(r, &ms); err != nil {
+				t.Errorf("decode: %v", err)
+			}
+			apply(ms...)
+		default:
+			// /update/{type}/{name}/{value}
+			parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/update/"), "/")
+			m := model.Metrics{ID: parts[1], MType: parts[0]}
+			if m.MType == model.Counter {
+				delta, _ := strconv.ParseInt(parts[2], 10, 64)
+				m.Delta = &delta
+			} else {
+				value, _ := strconv.ParseFloat(parts[2], 64)
+				m.Value = &value
+			}
+			apply(m)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, st
+}
+
+func TestCounterTotalsMatchPolls(t *testing.T) {
+	const polls = 50
+
+	tests := []struct {
+		name string
+		send func(s *Sender, metrics map[string]model.Metrics) error
+	}{
+		{name: "Plain", send: (*Sender).Send},
+		{name: "JSON", send: (*Sender).SendJSON},
+		{name: "Batch", send: (*Sender).SendBatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var flaky atomic.Bool
+			flaky.Store(true)
+			server, st := flakyServer(t, &flaky)
+			sender := NewSender(server.URL)
+			tracker := NewDeltaTracker()
+			mc := NewMetricsCollector()
+			runtime := NewRuntimeCollector()
+
+			report := func() error {
+				pending := tracker.Pending(mc.GetMetrics())
+				err := tt.send(sender, pending)
+				tracker.Ack(pending, Unsent(pending, err))
+				return err
+			}
+
+			failures := 0
+			for i := 1; i <= polls; i++ {
+				metrics, err := runtime.Collect(context.Background())
+				if err != nil {
+					t.Fatal(err)
+				}
+				mc.Record(metrics...)
+				if i%2 == 0 && report() != nil {
+					failures++
+				}
+			}
+			// сервер восстановился: уходит всё, что не было подтверждено
+			flaky.Store(false)
+			if err := report(); err != nil {
+				t.Fatalf("final report error = %v", err)
+			}
+
+			if failures == 0 {
+				t.Error("flaky server never failed, test proves nothing")
+			}
+			got, ok := st.GetMetric(model.Counter, "PollCount")
+			if !ok || *got.Delta != polls {
+				t.Errorf("server PollCount = %v, want %d", got.Delta, polls)
+			}
+		})
+	}
+}
@@ -0,0 +1,193 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	model "github.com/shatrunoff/yap_metrics/internal/model"
+)
+
+// лимит вывода команды
+const maxExecOutput = 1 << 20
+
+// счётчик ошибок команд, помечается меткой command
+const ExecErrorsMetric = "ExecErrors"
+
+// ExecCommand — внешняя команда, печатающая метрики в stdout
+type ExecCommand struct {
+	Name string
+	// программа и аргументы, без оболочки
+	Args []string
+	// 0 — до конца интервала сборщика
+	Timeout time.Duration
+}
+
+// ExecCollector запускает команды параллельно и разбирает их вывод:
+// строки "name type value" или JSON-массив model.Metrics.
+// При таймауте команда убивается вместе с дочерними процессами.
+type ExecCollector struct {
+	commands []ExecCommand
+}
+
+func NewExecCollector(commands []ExecCommand) *ExecCollector {
+	return &ExecCollector{commands: commands}
+}
+
+func (ec *ExecCollector) Name() string { return "exec" }
+
+func (ec *ExecCollector) Interval() time.Duration { return 0 }
+
+func (ec *ExecCollector) Collect(ctx context.Context) ([]model.Metrics, error) {
+	results := make([][]model.Metrics, len(ec.commands))
+	var wg sync.WaitGroup
+	for i, cmd := range ec.commands {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			var failed int64
+			metrics, err := runCommand(ctx, cmd)
+			if err != nil {
+				log.Printf("ERROR: exec command %s: %v", cmd.Name, err)
+				failed = 1
+			}
+			// счётчик отправляется всегда, чтобы ряд существовал и без ошибок
+			id := model.LabeledID(ExecErrorsMetric, map[string]string{"command": cmd.Name})
+			results[i] = append(metrics, model.Metrics{ID: id, MType: model.Counter, Delta: &failed})
+		}()
+	}
+	wg.Wait()
+
+	var res []model.Metrics
+	for _, metrics := range results {
+		res = append(res, metrics...)
+	}
+	return res, nil
+}
+
+// буфер, отбрасывающий вывод сверх лимита
+type limitedBuffer struct {
+	bytes.Buffer
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := maxExecOutput - b.Len(); len(p) > room {
+		b.truncated = true
+		b.Buffer.Write(p[:max(room, 0)])
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
+
+// запускает команду и разбирает её вывод
+func runCommand(ctx context.Context, command ExecCommand) ([]model.Metrics, error) {
+	if command.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, command.Timeout)
+		defer cancel()
+	}
+
+	var stdout, stderr limitedBuffer
+	cmd := exec.CommandContext(ctx, command.Args[0], command.Args[1:]...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	killGroupOnCancel(cmd)
+	// внуки, унаследовавшие stdout, не должны задерживать Wait
+	cmd.WaitDelay = time.Second
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("killed on timeout: %w", ctx.Err())
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%w: %s", err, msg)
+		}
+		return nil, err
+	}
+	if stdout.truncated {
+		return nil, fmt.Errorf("output exceeds %d bytes", maxExecOutput)
+	}
+	return ParseExecOutput(stdout.Bytes())
+}
+
+// ParseExecOutput разбирает вывод команды: JSON-массив или строки "name type value";
+// пустые строки и строки с # пропускаются
+func ParseExecOutput(data []byte) ([]model.Metrics, error) {
+	trimmed := bytes.TrimSpace(data)
+	if bytes.HasPrefix(trimmed, []byte("[")) {
+		var metrics []model.Metrics
+		if err := json.Unmarshal(trimmed, &metrics); err != nil {
+			return nil, fmt.Errorf("invalid JSON output: %w", err)
+		}
+		for i, m := range metrics {
+			if err := validateExecMetric(m); err != nil {
+				return nil, fmt.Errorf("metric %d: %w", i, err)
+			}
+		}
+		return metrics, nil
+	}
+
+	var metrics []model.Metrics
+	for n, line := range strings.Split(string(trimmed), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		m, err := parseExecLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n+1, err)
+		}
+		metrics = append(metrics, m)
+	}
+	return metrics, nil
+}
+
+func parseExecLine(line string) (model.Metrics, error) {
+	fields := strings.Fields(line)
+	if len(fields) != 3 {
+		return model.Metrics{}, fmt.Errorf("expected \"name type value\", got %q", line)
+	}
+	name, mtype, raw := fields[0], fields[1], fields[2]
+
+	m := model.Metrics{ID: name, MType: mtype}
+	switch mtype {
+	case model.Gauge:
+		value, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return model.Metrics{}, fmt.Errorf("invalid gauge value %q", raw)
+		}
+		m.Value = &value
+	case model.Counter:
+		delta, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return model.Metrics{}, fmt.Errorf("invalid counter value %q", raw)
+		}
+		m.Delta = &delta
+	}
+	return m, validateExecMetric(m)
+}
+
+func validateExecMetric(m model.Metrics) error {
+	if m.ID == "" {
+		return errors.New("empty metric name")
+	}
+	switch {
+	case m.MType == model.Gauge && m.Value != nil:
+	case m.MType == model.Counter && m.Delta != nil:
+	case m.MType != model.Gauge && m.MType != model.Counter:
+		return fmt.Errorf("metric %s: unknown type %q", m.ID, m.MType)
+	default:
+		return fmt.Errorf("metric %s: missing value", m.ID)
+	}
+	return nil
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package agent
+
+import "os/exec"
+
+// без групп процессов убивается только сама команда
+func killGroupOnCancel(cmd *exec.Cmd) {}
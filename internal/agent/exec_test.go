@@ -0,0 +1,109 @@
+package agent
+
+import (
+	"context"
+	"os/exec"
+	"strconv"
+	"testing"
+	"time"
+
+	model "github.com/shatrunoff/yap_metrics/internal/model"
+)
+
+func TestParseExecOutput(t *testing.T) {
+	tests := []struct {
+		name    string
+		output  string
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name:   "Line format",
+			output: "# queue stats\nqueue_size gauge 12.5\n\njobs_done counter 3\n",
+			want:   map[string]string{"queue_size": "12.5", "jobs_done": "3"},
+		},
+		{
+			name:   "JSON array",
+			output: ` [{"id":"queue_size","type":"gauge","value":7},{"id":"jobs_done","type":"counter","delta":2}]`,
+			want:   map[string]string{"queue_size": "7", "jobs_done": "2"},
+		},
+		{
+			name:   "Empty output",
+			output: "\n",
+			want:   map[string]string{},
+		},
+		{name: "Wrong field count", output: "queue_size 12", wantErr: true},
+		{name: "Unknown type", output: "queue_size histogram 1", wantErr: true},
+		{name: "Fractional counter", output: "jobs_done counter 1.5", wantErr: true},
+		{name: "JSON without value", output: `[{"id":"queue_size","type":"gauge"}]`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metrics, err := ParseExecOutput([]byte(tt.output))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseExecOutput() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(metrics) != len(tt.want) {
+				t.Fatalf("got %d metrics, want %d", len(metrics), len(tt.want))
+			}
+			for _, m := range metrics {
+				if got := metricValue(m); got != tt.want[m.ID] {
+					t.Errorf("%s = %s, want %s", m.ID, got, tt.want[m.ID])
+				}
+			}
+		})
+	}
+}
+
+func metricValue(m model.Metrics) string {
+	if m.MType == model.Gauge {
+		return strconv.FormatFloat(*m.Value, 'f', -1, 64)
+	}
+	return strconv.FormatInt(*m.Delta, 10)
+}
+
+func TestExecCollector(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available")
+	}
+
+	ec := NewExecCollector([]ExecCommand{
+		{Name: "ok", Args: []string{"sh", "-c", "echo queue_size gauge 4"}},
+		{Name: "fail", Args: []string{"sh", "-c", "echo broken >&2; exit 3"}},
+		{Name: "garbage", Args: []string{"sh", "-c", "echo not a metric line"}},
+		// дочерний sleep держит stdout открытым: убивается вся группа
+		{Name: "hang", Args: []string{"sh", "-c", "sleep 30 & sleep 30"}, Timeout: 100 * time.Millisecond},
+	})
+
+	start := time.Now()
+	metrics, err := ec.Collect(context.Background())
+	if err != nil {
+		t.Fatalf("Collect() error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Collect() took %v, hung command was not killed", elapsed)
+	}
+
+	got := make(map[string]string, len(metrics))
+	for _, m := range metrics {
+		got[m.ID] = metricValue(m)
+	}
+	want := map[string]string{
+		"queue_size":                    "4",
+		`ExecErrors{command="ok"}`:      "0",
+		`ExecErrors{command="fail"}`:    "1",
+		`ExecErrors{command="garbage"}`: "1",
+		`ExecErrors{command="hang"}`:    "1",
+	}
+	for id, value := range want {
+		if got[id] != value {
+			t.Errorf("%s = %q, want %q", id, got[id], value)
+		}
+	}
+	if len(got) != len(want) {
+		t.Errorf("got metrics %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,146 @@
+package agent
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	model "github.com/shatrunoff/yap_metrics/internal/model"
+)
+
+// заполненность файловой системы в байтах
+type fsUsage struct {
+	size  uint64
+	free  uint64
+	avail uint64
+}
+
+// FilesystemCollector отдаёт размер, занятое и доступное место смонтированных
+// файловых систем из <root>/mounts. Псевдо-ФС с нулевым размером пропускаются.
+type FilesystemCollector struct {
+	root        string
+	mountpoints *NameFilter
+	statfs      func(path string) (fsUsage, error)
+}
+
+// root — каталог procfs, пустой — /proc; include/exclude — шаблоны точек монтирования
+func NewFilesystemCollector(root string, include, exclude []string) *FilesystemCollector {
+	if root == "" {
+		root = "/proc"
+	}
+	return &FilesystemCollector{
+		root:        root,
+		mountpoints: NewNameFilter(include, exclude),
+		statfs:      statfs,
+	}
+}
+
+func (fc *FilesystemCollector) Name() string { return "filesystem" }
+
+func (fc *FilesystemCollector) Interval() time.Duration { return 0 }
+
+// точка монтирования
+type mount struct {
+	device string
+	path   string
+	fstype string
+}
+
+func (fc *FilesystemCollector) Collect(ctx context.Context) ([]model.Metrics, error) {
+	mounts, err := readMounts(filepath.Join(fc.root, "mounts"))
+	if err != nil {
+		return nil, err
+	}
+
+	var res []model.Metrics
+	var errs []error
+	for _, m := range mounts {
+		if !fc.mountpoints.Allow(m.path) {
+			continue
+		}
+		usage, err := fc.statfs(m.path)
+		if err != nil {
+			// недоступные точки (нет прав, отвалившийся NFS) не мешают остальным
+			if !errors.Is(err, os.ErrPermission) && !errors.Is(err, os.ErrNotExist) {
+				errs = append(errs, fmt.Errorf("statfs %s: %w", m.path, err))
+			}
+			continue
+		}
+		if usage.size == 0 {
+			continue
+		}
+
+		labels := map[string]string{"mountpoint": m.path, "device": m.device, "fstype": m.fstype}
+		gauges := []struct {
+			name  string
+			value uint64
+		}{
+			{"FsSizeBytes", usage.size},
+			{"FsUsedBytes", usage.size - usage.free},
+			{"FsAvailBytes", usage.avail},
+		}
+		for _, g := range gauges {
+			v := float64(g.value)
+			res = append(res, model.Metrics{ID: model.LabeledID(g.name, labels), MType: model.Gauge, Value: &v})
+		}
+	}
+	return res, errors.Join(errs...)
+}
+
+// читает таблицу монтирования; повторно смонтированный путь учитывается один раз
+func readMounts(path string) ([]mount, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var res []mount
+	index := make(map[string]int)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// device mountpoint fstype options dump pass
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 {
+			continue
+		}
+		m := mount{
+			device: unescapeMount(fields[0]),
+			path:   unescapeMount(fields[1]),
+			fstype: fields[2],
+		}
+		// верхнее монтирование перекрывает нижнее
+		if i, ok := index[m.path]; ok {
+			res[i] = m
+			continue
+		}
+		index[m.path] = len(res)
+		res = append(res, m)
+	}
+	return res, scanner.Err()
+}
+
+// раскрывает восьмеричные escape-последовательности (\040 — пробел)
+func unescapeMount(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) {
+			if v, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(v))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
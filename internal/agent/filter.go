@@ -0,0 +1,51 @@
+package agent
+
+import (
+	"regexp"
+	"strings"
+)
+
+// NameFilter отбирает метрики по спискам шаблонов; '*' совпадает с любой подстрокой
+type NameFilter struct {
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+}
+
+func NewNameFilter(include, exclude []string) *NameFilter {
+	return &NameFilter{
+		include: compilePatterns(include),
+		exclude: compilePatterns(exclude),
+	}
+}
+
+func compilePatterns(patterns []string) []*regexp.Regexp {
+	res := make([]*regexp.Regexp, 0, len(patterns))
+	for _, p := range patterns {
+		parts := strings.Split(p, "*")
+		for i := range parts {
+			parts[i] = regexp.QuoteMeta(parts[i])
+		}
+		res = append(res, regexp.MustCompile("^"+strings.Join(parts, ".*")+"$"))
+	}
+	return res
+}
+
+func matchAny(patterns []*regexp.Regexp, name string) bool {
+	for _, p := range patterns {
+		if p.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// пустой include пропускает всё, exclude имеет приоритет
+func (f *NameFilter) Allow(name string) bool {
+	if f == nil {
+		return true
+	}
+	if len(f.include) > 0 && !matchAny(f.include, name) {
+		return false
+	}
+	return !matchAny(f.exclude, name)
+}
@@ -0,0 +1,146 @@
+package agent
+
+import (
+	"context"
+	"math"
+	"runtime/metrics"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	model "github.com/shatrunoff/yap_metrics/internal/model"
+	"github.com/shatrunoff/yap_metrics/internal/prom"
+)
+
+// квантили, в которые сворачиваются гистограммы runtime/metrics
+var histogramQuantiles = []float64{0.5, 0.9, 0.99}
+
+// GoMetricsCollector читает runtime/metrics без остановки мира.
+// Накопительные целые значения отправляются как counter (приращения),
+// остальные скаляры — как gauge. Гистограмма превращается в gauge-квантили
+// с меткой quantile по наблюдениям с прошлого сбора и counter <имя>_count.
+type GoMetricsCollector struct {
+	samples    []metrics.Sample
+	cumulative map[string]bool
+	// накопительные значения с прошлого сбора: для приращений
+	mu        sync.Mutex
+	prev      map[string]uint64
+	prevHists map[string][]uint64
+}
+
+// include/exclude — шаблоны исходных имён, например "/gc/*" или "*:bytes"
+func NewGoMetricsCollector(include, exclude []string) *GoMetricsCollector {
+	filter := NewNameFilter(include, exclude)
+
+	gc := &GoMetricsCollector{
+		cumulative: make(map[string]bool),
+		prev:       make(map[string]uint64),
+		prevHists:  make(map[string][]uint64),
+	}
+	for _, d := range metrics.All() {
+		if !filter.Allow(d.Name) {
+			continue
+		}
+		gc.samples = append(gc.samples, metrics.Sample{Name: d.Name})
+		gc.cumulative[d.Name] = d.Cumulative
+	}
+	return gc
+}
+
+func (gc *GoMetricsCollector) Name() string { return "gometrics" }
+
+func (gc *GoMetricsCollector) Interval() time.Duration { return 0 }
+
+// имя runtime/metrics в имя метрики: /gc/heap/allocs:bytes -> go_gc_heap_allocs_bytes
+func goMetricName(name string) string {
+	name = strings.TrimPrefix(name, "/")
+	name = strings.ReplaceAll(name, ":", "_")
+	return "go_" + prom.SanitizeName(name)
+}
+
+func (gc *GoMetricsCollector) Collect(ctx context.Context) ([]model.Metrics, error) {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+
+	metrics.Read(gc.samples)
+
+	res := make([]model.Metrics, 0, len(gc.samples))
+	for _, s := range gc.samples {
+		name := goMetricName(s.Name)
+
+		switch s.Value.Kind() {
+		case metrics.KindUint64:
+			value := s.Value.Uint64()
+			if gc.cumulative[s.Name] {
+				delta := gc.delta(s.Name, value)
+				res = append(res, model.Metrics{ID: name, MType: model.Counter, Delta: &delta})
+			} else {
+				v := float64(value)
+				res = append(res, model.Metrics{ID: name, MType: model.Gauge, Value: &v})
+			}
+		case metrics.KindFloat64:
+			// дробные накопительные значения counter не выражает, отправляем итог
+			v := s.Value.Float64()
+			res = append(res, model.Metrics{ID: name, MType: model.Gauge, Value: &v})
+		case metrics.KindFloat64Histogram:
+			res = append(res, gc.histogram(s.Name, name, s.Value.Float64Histogram())...)
+		}
+	}
+	return res, nil
+}
+
+// приращение накопительного значения с прошлого сбора
+func (gc *GoMetricsCollector) delta(key string, value uint64) int64 {
+	prev := gc.prev[key]
+	gc.prev[key] = value
+	if value < prev {
+		return 0
+	}
+	return int64(value - prev)
+}
+
+// сворачивает гистограмму в квантили и счётчик наблюдений
+func (gc *GoMetricsCollector) histogram(key, name string, h *metrics.Float64Histogram) []model.Metrics {
+	prev := gc.prevHists[key]
+	counts := make([]uint64, len(h.Counts))
+	var total uint64
+	for i, c := range h.Counts {
+		if i < len(prev) && c >= prev[i] {
+			c -= prev[i]
+		}
+		counts[i] = c
+		total += c
+	}
+	gc.prevHists[key] = append(prev[:0], h.Counts...)
+
+	count := int64(total)
+	res := []model.Metrics{{ID: name + "_count", MType: model.Counter, Delta: &count}}
+	if total == 0 {
+		return res
+	}
+	for _, q := range histogramQuantiles {
+		v := quantile(counts, h.Buckets, total, q)
+		id := model.LabeledID(name, map[string]string{"quantile": strconv.FormatFloat(q, 'f', -1, 64)})
+		res = append(res, model.Metrics{ID: id, MType: model.Gauge, Value: &v})
+	}
+	return res
+}
+
+// оценка квантиля по корзинам: граница корзины, в которую он попал
+func quantile(counts []uint64, buckets []float64, total uint64, q float64) float64 {
+	rank := uint64(math.Ceil(q * float64(total)))
+	var seen uint64
+	for i, c := range counts {
+		seen += c
+		if seen < rank || c == 0 {
+			continue
+		}
+		// buckets[i], buckets[i+1] — границы корзины i, крайние могут быть бесконечны
+		if upper := buckets[i+1]; !math.IsInf(upper, 1) {
+			return upper
+		}
+		return buckets[i]
+	}
+	return buckets[len(buckets)-1]
+}
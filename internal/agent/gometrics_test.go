@@ -0,0 +1,121 @@
+package agent
+
+import (
+	"context"
+	"math"
+	"runtime"
+	"strings"
+	"testing"
+
+	model "github.com/shatrunoff/yap_metrics/internal/model"
+)
+
+func TestGoMetricName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"/gc/heap/allocs:bytes", "go_gc_heap_allocs_bytes"},
+		{"/sched/goroutines:goroutines", "go_sched_goroutines_goroutines"},
+		{"/cpu/classes/gc/mark/assist:cpu-seconds", "go_cpu_classes_gc_mark_assist_cpu_seconds"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := goMetricName(tt.name); got != tt.want {
+				t.Errorf("goMetricName() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNameFilter(t *testing.T) {
+	tests := []struct {
+		name    string
+		include []string
+		exclude []string
+		metric  string
+		want    bool
+	}{
+		{"Empty filter", nil, nil, "/gc/cycles/total:gc-cycles", true},
+		{"Include matches", []string{"/gc/*"}, nil, "/gc/cycles/total:gc-cycles", true},
+		{"Include misses", []string{"/gc/*"}, nil, "/sched/goroutines:goroutines", false},
+		{"Exclude wins", []string{"/gc/*"}, []string{"*:bytes"}, "/gc/heap/allocs:bytes", false},
+		{"Exact name", []string{"/sched/goroutines:goroutines"}, nil, "/sched/goroutines:goroutines", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NewNameFilter(tt.include, tt.exclude).Allow(tt.metric); got != tt.want {
+				t.Errorf("Allow(%q) = %v, want %v", tt.metric, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuantile(t *testing.T) {
+	buckets := []float64{math.Inf(-1), 1, 2, 4, math.Inf(1)}
+	tests := []struct {
+		name   string
+		counts []uint64
+		q      float64
+		want   float64
+	}{
+		{"Median in middle bucket", []uint64{0, 1, 8, 1}, 0.5, 4},
+		{"Low quantile in first finite bucket", []uint64{0, 9, 1, 0}, 0.5, 2},
+		{"Overflow bucket uses lower bound", []uint64{0, 0, 1, 9}, 0.9, 4},
+		{"Underflow bucket uses upper bound", []uint64{5, 0, 0, 0}, 0.5, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var total uint64
+			for _, c := range tt.counts {
+				total += c
+			}
+			if got := quantile(tt.counts, buckets, total, tt.q); got != tt.want {
+				t.Errorf("quantile() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGoMetricsCollector(t *testing.T) {
+	gc := NewGoMetricsCollector([]string{"/gc/*", "/sched/goroutines:goroutines"}, []string{"/gc/heap/*"})
+
+	collect := func() map[string]model.Metrics {
+		metrics, err := gc.Collect(context.Background())
+		if err != nil {
+			t.Fatalf("Collect() error = %v", err)
+		}
+		res := make(map[string]model.Metrics, len(metrics))
+		for _, m := range metrics {
+			if strings.HasPrefix(m.ID, "go_gc_heap_") {
+				t.Errorf("excluded metric %s collected", m.ID)
+			}
+			res[m.ID] = m
+		}
+		return res
+	}
+
+	first := collect()
+	if m, ok := first["go_sched_goroutines_goroutines"]; !ok || m.MType != model.Gauge || *m.Value < 1 {
+		t.Errorf("goroutines gauge = %v", m)
+	}
+
+	// накопительные значения отправляются приращениями
+	runtime.GC()
+	second := collect()
+	cycles, ok := second["go_gc_cycles_total_gc_cycles"]
+	if !ok || cycles.MType != model.Counter {
+		t.Fatalf("gc cycles counter missing: %v", cycles)
+	}
+	if *cycles.Delta < 1 || *cycles.Delta > 10 {
+		t.Errorf("gc cycles delta = %d, want the cycles since previous collect", *cycles.Delta)
+	}
+
+	// гистограмма пауз GC: счётчик наблюдений и квантили
+	if m, ok := second["go_gc_pauses_seconds_count"]; !ok || m.MType != model.Counter || *m.Delta < 1 {
+		t.Errorf("gc pauses count = %v", m)
+	}
+	if m, ok := second[`go_gc_pauses_seconds{quantile="0.99"}`]; !ok || m.MType != model.Gauge {
+		t.Errorf("gc pauses p99 missing")
+	}
+}
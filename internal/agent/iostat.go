@@ -0,0 +1,199 @@
+package agent
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	model "github.com/shatrunoff/yap_metrics/internal/model"
+)
+
+// размер сектора в /proc/diskstats, не зависит от устройства
+const diskSectorSize = 512
+
+// DiskCollector читает /proc/diskstats: байты и операции чтения/записи по устройствам
+type DiskCollector struct {
+	root    string
+	devices *NameFilter
+
+	mu       sync.Mutex
+	counters *counterTracker
+}
+
+// root — каталог procfs, пустой — /proc; include/exclude — шаблоны имён устройств
+func NewDiskCollector(root string, include, exclude []string) *DiskCollector {
+	if root == "" {
+		root = "/proc"
+	}
+	return &DiskCollector{
+		root:     root,
+		devices:  NewNameFilter(include, exclude),
+		counters: newCounterTracker(),
+	}
+}
+
+func (dc *DiskCollector) Name() string { return "disk" }
+
+func (dc *DiskCollector) Interval() time.Duration { return 0 }
+
+func (dc *DiskCollector) Collect(ctx context.Context) ([]model.Metrics, error) {
+	path := filepath.Join(dc.root, "diskstats")
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+
+	// major minor name reads merged sectors ms writes merged sectors ...
+	var res []model.Metrics
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 {
+			continue
+		}
+		device := fields[2]
+		if !dc.devices.Allow(device) {
+			continue
+		}
+		values, err := parseUints(fields[3:10])
+		if err != nil {
+			return nil, fmt.Errorf("%s: device %s: %w", path, device, err)
+		}
+
+		// переполнение отслеживается в секторах, как их считает ядро
+		counters := []kernelCounter{
+			{name: "DiskReads", value: values[0]},
+			{name: "DiskReadBytes", value: values[2], scale: diskSectorSize},
+			{name: "DiskWrites", value: values[4]},
+			{name: "DiskWriteBytes", value: values[6], scale: diskSectorSize},
+		}
+		res = append(res, emitCounters(dc.counters, counters, map[string]string{"device": device}, seen)...)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	dc.counters.retain(seen)
+	return res, nil
+}
+
+// NetCollector читает /proc/net/dev: байты, ошибки и отброшенные пакеты по интерфейсам
+type NetCollector struct {
+	root       string
+	interfaces *NameFilter
+
+	mu       sync.Mutex
+	counters *counterTracker
+}
+
+// root — каталог procfs, пустой — /proc; include/exclude — шаблоны имён интерфейсов
+func NewNetCollector(root string, include, exclude []string) *NetCollector {
+	if root == "" {
+		root = "/proc"
+	}
+	return &NetCollector{
+		root:       root,
+		interfaces: NewNameFilter(include, exclude),
+		counters:   newCounterTracker(),
+	}
+}
+
+func (nc *NetCollector) Name() string { return "net" }
+
+func (nc *NetCollector) Interval() time.Duration { return 0 }
+
+func (nc *NetCollector) Collect(ctx context.Context) ([]model.Metrics, error) {
+	path := filepath.Join(nc.root, "net", "dev")
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+
+	// iface: rx bytes packets errs drop fifo frame compressed multicast tx bytes packets errs drop ...
+	var res []model.Metrics
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		name, stats, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			// две строки заголовка
+			continue
+		}
+		iface := strings.TrimSpace(name)
+		if !nc.interfaces.Allow(iface) {
+			continue
+		}
+		fields := strings.Fields(stats)
+		if len(fields) < 12 {
+			return nil, fmt.Errorf("%s: interface %s: too few fields", path, iface)
+		}
+		values, err := parseUints(fields[:12])
+		if err != nil {
+			return nil, fmt.Errorf("%s: interface %s: %w", path, iface, err)
+		}
+
+		counters := []kernelCounter{
+			{name: "NetRxBytes", value: values[0]},
+			{name: "NetRxErrors", value: values[2]},
+			{name: "NetRxDrops", value: values[3]},
+			{name: "NetTxBytes", value: values[8]},
+			{name: "NetTxErrors", value: values[10]},
+			{name: "NetTxDrops", value: values[11]},
+		}
+		res = append(res, emitCounters(nc.counters, counters, map[string]string{"interface": iface}, seen)...)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	nc.counters.retain(seen)
+	return res, nil
+}
+
+// накопительное значение ядра
+type kernelCounter struct {
+	name  string
+	value uint64
+	// множитель единиц ядра, 0 — без пересчёта
+	scale int64
+}
+
+// counter-метрики с метками по накопительным значениям
+func emitCounters(tracker *counterTracker, counters []kernelCounter, labels map[string]string, seen map[string]bool) []model.Metrics {
+	res := make([]model.Metrics, 0, len(counters))
+	for _, c := range counters {
+		id := model.LabeledID(c.name, labels)
+		seen[id] = true
+		delta := tracker.delta(id, c.value)
+		if c.scale > 0 {
+			delta *= c.scale
+		}
+		res = append(res, model.Metrics{ID: id, MType: model.Counter, Delta: &delta})
+	}
+	return res
+}
+
+func parseUints(fields []string) ([]uint64, error) {
+	res := make([]uint64, len(fields))
+	for i, f := range fields {
+		v, err := strconv.ParseUint(f, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		res[i] = v
+	}
+	return res, nil
+}
@@ -0,0 +1,192 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCounterDelta(t *testing.T) {
+	tests := []struct {
+		name  string
+		prev  uint64
+		value uint64
+		want  uint64
+	}{
+		{"Growth", 100, 250, 150},
+		{"No change", 7, 7, 0},
+		{"32-bit wrap", math.MaxUint32 - 9, 5, 15},
+		{"Reset of small counter", 1000, 10, 10},
+		{"Reset of 64-bit counter", 1 << 40, 300, 300},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := counterDelta(tt.prev, tt.value); got != tt.want {
+				t.Errorf("counterDelta(%d, %d) = %d, want %d", tt.prev, tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func writeFixture(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// собирает метрики в карту ID -> значение
+func collectValues(t *testing.T, c Collector) map[string]float64 {
+	t.Helper()
+	metrics, err := c.Collect(context.Background())
+	if err != nil {
+		t.Fatalf("Collect() error = %v", err)
+	}
+	res := make(map[string]float64, len(metrics))
+	for _, m := range metrics {
+		if m.Delta != nil {
+			res[m.ID] = float64(*m.Delta)
+		} else {
+			res[m.ID] = *m.Value
+		}
+	}
+	return res
+}
+
+func TestDiskCollector(t *testing.T) {
+	root := t.TempDir()
+	path := filepath.Join(root, "diskstats")
+	writeFixture(t, path, `   7       0 loop0 12 0 100 3 0 0 0 0 0 4 3 0 0 0 0
+   8       0 sda 1000 20 4000 500 2000 30 8000 900 0 1200 1400 0 0 0 0
+   8       1 sda1 900 20 3600 450 1900 30 7600 850 0 1100 1300 0 0 0 0
+ 259       0 nvme0n1 50 0 400 10 4294967290 0 4294967290 20 0 30 30
+`)
+
+	dc := NewDiskCollector(root, nil, []string{"loop*", "sda1"})
+	first := collectValues(t, dc)
+	if len(first) != 8 {
+		t.Fatalf("got %d metrics, want 8 (sda and nvme0n1): %v", len(first), first)
+	}
+	if got := first[`DiskReadBytes{device="sda"}`]; got != 0 {
+		t.Errorf("first collect delta = %v, want 0", got)
+	}
+
+	// sda прочитал 10 секторов, счётчики записи nvme0n1 переполнились
+	writeFixture(t, path, `   8       0 sda 1003 20 4010 500 2000 30 8000 900 0 1200 1400 0 0 0 0
+ 259       0 nvme0n1 50 0 400 10 4 0 4 20 0 30 30
+`)
+	second := collectValues(t, dc)
+	tests := []struct {
+		id   string
+		want float64
+	}{
+		{`DiskReads{device="sda"}`, 3},
+		{`DiskReadBytes{device="sda"}`, 10 * 512},
+		{`DiskWrites{device="sda"}`, 0},
+		{`DiskWrites{device="nvme0n1"}`, 10},
+		{`DiskWriteBytes{device="nvme0n1"}`, 10 * 512},
+	}
+	for _, tt := range tests {
+		if got := second[tt.id]; got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.id, got, tt.want)
+		}
+	}
+}
+
+func TestNetCollector(t *testing.T) {
+	root := t.TempDir()
+	path := filepath.Join(root, "net", "dev")
+	header := `Inter-|   Receive                                                |  Transmit
+ face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+`
+	writeFixture(t, path, header+`    lo: 5000 50 0 0 0 0 0 0 5000 50 0 0 0 0 0 0
+  eth0: 1000000 900 1 2 0 0 0 0 200000 800 3 4 0 0 0 0
+docker0:100 1 0 0 0 0 0 0 100 1 0 0 0 0 0 0
+`)
+
+	nc := NewNetCollector(root, []string{"eth*", "docker*"}, nil)
+	collectValues(t, nc)
+
+	writeFixture(t, path, header+`  eth0: 1500000 1400 2 2 0 0 0 0 260000 900 3 6 0 0 0 0
+docker0:150 2 0 0 0 0 0 0 100 1 0 0 0 0 0 0
+`)
+	second := collectValues(t, nc)
+	want := map[string]float64{
+		`NetRxBytes{interface="eth0"}`:     500000,
+		`NetRxErrors{interface="eth0"}`:    1,
+		`NetRxDrops{interface="eth0"}`:     0,
+		`NetTxBytes{interface="eth0"}`:     60000,
+		`NetTxErrors{interface="eth0"}`:    0,
+		`NetTxDrops{interface="eth0"}`:     2,
+		`NetRxBytes{interface="docker0"}`:  50,
+		`NetRxErrors{interface="docker0"}`: 0,
+		`NetRxDrops{interface="docker0"}`:  0,
+		`NetTxBytes{interface="docker0"}`:  0,
+		`NetTxErrors{interface="docker0"}`: 0,
+		`NetTxDrops{interface="docker0"}`:  0,
+	}
+	for id, value := range want {
+		if got, ok := second[id]; !ok || got != value {
+			t.Errorf("%s = %v (present %v), want %v", id, got, ok, value)
+		}
+	}
+	if len(second) != len(want) {
+		t.Errorf("got %d metrics, want %d: %v", len(second), len(want), second)
+	}
+}
+
+func TestFilesystemCollector(t *testing.T) {
+	root := t.TempDir()
+	writeFixture(t, filepath.Join(root, "mounts"), `/dev/sda1 / ext4 rw,relatime 0 0
+proc /proc proc rw,nosuid 0 0
+/dev/sdb1 /mnt/my\040data xfs rw 0 0
+/dev/sdc1 /srv ext4 rw 0 0
+tmpfs /srv tmpfs rw 0 0
+/dev/sdd1 /secret ext4 rw 0 0
+/dev/sde1 /boot ext4 rw 0 0
+`)
+
+	fc := NewFilesystemCollector(root, nil, []string{"/boot"})
+	fc.statfs = func(path string) (fsUsage, error) {
+		switch path {
+		case "/":
+			return fsUsage{size: 1000, free: 400, avail: 300}, nil
+		case "/mnt/my data":
+			return fsUsage{size: 50, free: 50, avail: 50}, nil
+		case "/srv":
+			return fsUsage{size: 10, free: 1, avail: 1}, nil
+		case "/secret":
+			return fsUsage{}, os.ErrPermission
+		case "/boot":
+			return fsUsage{}, errors.New("must be excluded")
+		}
+		return fsUsage{}, nil
+	}
+
+	got := collectValues(t, fc)
+	want := map[string]float64{
+		`FsSizeBytes{device="/dev/sda1",fstype="ext4",mountpoint="/"}`:            1000,
+		`FsUsedBytes{device="/dev/sda1",fstype="ext4",mountpoint="/"}`:            600,
+		`FsAvailBytes{device="/dev/sda1",fstype="ext4",mountpoint="/"}`:           300,
+		`FsUsedBytes{device="/dev/sdb1",fstype="xfs",mountpoint="/mnt/my data"}`:  0,
+		`FsSizeBytes{device="/dev/sdb1",fstype="xfs",mountpoint="/mnt/my data"}`:  50,
+		`FsAvailBytes{device="/dev/sdb1",fstype="xfs",mountpoint="/mnt/my data"}`: 50,
+		`FsSizeBytes{device="tmpfs",fstype="tmpfs",mountpoint="/srv"}`:            10,
+		`FsUsedBytes{device="tmpfs",fstype="tmpfs",mountpoint="/srv"}`:            9,
+		`FsAvailBytes{device="tmpfs",fstype="tmpfs",mountpoint="/srv"}`:           1,
+	}
+	for id, value := range want {
+		if g, ok := got[id]; !ok || g != value {
+			t.Errorf("%s = %v (present %v), want %v", id, g, ok, value)
+		}
+	}
+	if len(got) != len(want) {
+		t.Errorf("got %d metrics, want %d: %v", len(got), len(want), got)
+	}
+}
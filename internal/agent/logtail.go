@@ -0,0 +1,345 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	model "github.com/shatrunoff/yap_metrics/internal/model"
+)
+
+const (
+	// сколько байт начала файла запоминается, чтобы узнать его после перезапуска
+	logHeadSize = 256
+	// сколько байт читается из файла за один сбор
+	maxLogRead = 16 << 20
+)
+
+// счётчик строк длиннее maxLogRead, пропущенных без разбора; помечается меткой file
+const LogTailDroppedMetric = "LogTailDroppedLines"
+
+// LogRule превращает совпадения регулярного выражения в метрику.
+// Именованные группы становятся метками, кроме группы value:
+// для gauge она задаёт значение, для counter — приращение (по умолчанию 1).
+type LogRule struct {
+	Name    string
+	Type    string
+	Pattern *regexp.Regexp
+}
+
+// положение в файле, переживающее перезапуск агента
+type tailState struct {
+	Offset   int64  `json:"offset"`
+	HeadLen  int64  `json:"head_len"`
+	HeadHash string `json:"head_hash"`
+}
+
+// отслеживаемый файл
+type tailFile struct {
+	file   *os.File
+	offset int64
+	// позиция внутри слишком длинной строки, её остаток пропускается
+	skipping bool
+}
+
+// LogTailCollector дочитывает файлы журналов и считает строки по правилам.
+// Переименование (ротация) обнаруживается по смене файла за путём: старый
+// файл дочитывается до конца, новый читается с начала. Уменьшение размера
+// считается усечением. Позиции сохраняются в stateFile, если он задан;
+// без сохранённой позиции файл читается с конца.
+type LogTailCollector struct {
+	paths     []string
+	rules     []LogRule
+	stateFile string
+	maxRead   int64
+
+	mu    sync.Mutex
+	files map[string]*tailFile
+	saved map[string]tailState
+	// файлы, которых не было при прошлой попытке: появившись, читаются с начала
+	missing map[string]bool
+}
+
+func NewLogTailCollector(paths []string, rules []LogRule, stateFile string) (*LogTailCollector, error) {
+	lc := &LogTailCollector{
+		paths:     paths,
+		rules:     rules,
+		stateFile: stateFile,
+		maxRead:   maxLogRead,
+		files:     make(map[string]*tailFile),
+		saved:     make(map[string]tailState),
+		missing:   make(map[string]bool),
+	}
+	if stateFile != "" {
+		data, err := os.ReadFile(stateFile)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+		case err != nil:
+			return nil, err
+		default:
+			if err := json.Unmarshal(data, &lc.saved); err != nil {
+				return nil, fmt.Errorf("logtail state %s: %w", stateFile, err)
+			}
+		}
+	}
+	return lc, nil
+}
+
+func (lc *LogTailCollector) Name() string { return "logtail" }
+
+func (lc *LogTailCollector) Interval() time.Duration { return 0 }
+
+// счётчики и значения одного сбора
+type logMatches struct {
+	counters map[string]int64
+	gauges   map[string]float64
+}
+
+func (lc *LogTailCollector) Collect(ctx context.Context) ([]model.Metrics, error) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+
+	matches := logMatches{counters: make(map[string]int64), gauges: make(map[string]float64)}
+	var errs []error
+	for _, path := range lc.paths {
+		if err := lc.tail(path, &matches); err != nil {
+			errs = append(errs, fmt.Errorf("logtail %s: %w", path, err))
+		}
+	}
+	if err := lc.saveState(); err != nil {
+		errs = append(errs, err)
+	}
+
+	res := make([]model.Metrics, 0, len(matches.counters)+len(matches.gauges))
+	for id, delta := range matches.counters {
+		d := delta
+		res = append(res, model.Metrics{ID: id, MType: model.Counter, Delta: &d})
+	}
+	for id, value := range matches.gauges {
+		v := value
+		res = append(res, model.Metrics{ID: id, MType: model.Gauge, Value: &v})
+	}
+	return res, errors.Join(errs...)
+}
+
+// дочитывает файл по пути с учётом ротации и усечения
+func (lc *LogTailCollector) tail(path string, matches *logMatches) error {
+	tf := lc.files[path]
+	if tf == nil {
+		var err error
+		if tf, err = lc.open(path); err != nil || tf == nil {
+			return err
+		}
+		lc.files[path] = tf
+	}
+
+	current, err := os.Stat(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	opened, err := tf.file.Stat()
+	if err != nil {
+		return err
+	}
+
+	// за путём уже другой файл или его ещё нет: дочитываем старый
+	if current == nil || !os.SameFile(current, opened) {
+		if err := lc.read(path, tf, opened.Size(), matches); err != nil {
+			return err
+		}
+		if current == nil {
+			return nil
+		}
+		tf.file.Close()
+		delete(lc.files, path)
+
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		tf = &tailFile{file: file}
+		lc.files[path] = tf
+		if opened, err = file.Stat(); err != nil {
+			return err
+		}
+	}
+
+	if opened.Size() < tf.offset {
+		tf.offset = 0
+	}
+	return lc.read(path, tf, opened.Size(), matches)
+}
+
+// открывает файл с сохранённой позиции или с конца
+func (lc *LogTailCollector) open(path string) (*tailFile, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		lc.missing[path] = true
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	tf := &tailFile{file: file, offset: info.Size()}
+	if state, ok := lc.saved[path]; ok {
+		// тот же файл, если совпадает начало и он не короче позиции,
+		// иначе он сменился, пока агент не работал
+		tf.offset = 0
+		if head, err := readHead(file, state.HeadLen); err == nil && head == state.HeadHash && info.Size() >= state.Offset {
+			tf.offset = state.Offset
+		}
+	} else if lc.missing[path] {
+		tf.offset = 0
+	}
+	delete(lc.missing, path)
+	return tf, nil
+}
+
+// читает полные строки от позиции до size; строка, не уместившаяся
+// в maxRead, пропускается целиком и учитывается как отброшенная
+func (lc *LogTailCollector) read(path string, tf *tailFile, size int64, matches *logMatches) error {
+	if size <= tf.offset {
+		return nil
+	}
+	n := min(size-tf.offset, lc.maxRead)
+	buf := make([]byte, n)
+	read, err := tf.file.ReadAt(buf, tf.offset)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	buf = buf[:read]
+
+	// дочитываем остаток пропускаемой строки
+	start := 0
+	if tf.skipping {
+		i := bytes.IndexByte(buf, '\n')
+		if i < 0 {
+			tf.offset += int64(len(buf))
+			return nil
+		}
+		start = i + 1
+		tf.skipping = false
+	}
+
+	end := bytes.LastIndexByte(buf, '\n')
+	if end < start {
+		if start == 0 && int64(len(buf)) == lc.maxRead {
+			// перевода строки нет во всём окне: без пропуска позиция не сдвинется
+			log.Printf("WARNING: logtail %s: line longer than %d bytes skipped", path, lc.maxRead)
+			matches.counters[model.LabeledID(LogTailDroppedMetric, map[string]string{"file": path})]++
+			tf.skipping = true
+			start = len(buf)
+		}
+		// незаконченная строка останется до следующего сбора
+		tf.offset += int64(start)
+		return nil
+	}
+	for _, line := range bytes.Split(buf[start:end], []byte("\n")) {
+		lc.match(string(line), matches)
+	}
+	tf.offset += int64(end + 1)
+	return nil
+}
+
+// применяет правила к строке
+func (lc *LogTailCollector) match(line string, matches *logMatches) {
+	for _, rule := range lc.rules {
+		groups := rule.Pattern.FindStringSubmatch(line)
+		if groups == nil {
+			continue
+		}
+
+		labels := make(map[string]string)
+		raw := ""
+		for i, name := range rule.Pattern.SubexpNames() {
+			switch name {
+			case "":
+			case "value":
+				raw = groups[i]
+			default:
+				labels[name] = groups[i]
+			}
+		}
+		id := model.LabeledID(rule.Name, labels)
+
+		switch rule.Type {
+		case model.Counter:
+			delta := int64(1)
+			if raw != "" {
+				var err error
+				if delta, err = strconv.ParseInt(raw, 10, 64); err != nil {
+					continue
+				}
+			}
+			matches.counters[id] += delta
+		case model.Gauge:
+			value, err := strconv.ParseFloat(raw, 64)
+			if err != nil {
+				continue
+			}
+			matches.gauges[id] = value
+		}
+	}
+}
+
+// хэш первых n байт файла
+func readHead(file *os.File, n int64) (string, error) {
+	buf := make([]byte, n)
+	if _, err := file.ReadAt(buf, 0); err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(buf)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// сохраняет позиции файлов атомарной заменой
+func (lc *LogTailCollector) saveState() error {
+	if lc.stateFile == "" {
+		return nil
+	}
+	for path, tf := range lc.files {
+		headLen := min(tf.offset, logHeadSize)
+		head, err := readHead(tf.file, headLen)
+		if err != nil {
+			// файл усекли после чтения, позиция сбросится при следующем сборе
+			continue
+		}
+		lc.saved[path] = tailState{Offset: tf.offset, HeadLen: headLen, HeadHash: head}
+	}
+
+	data, err := json.Marshal(lc.saved)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(lc.stateFile), filepath.Base(lc.stateFile)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), lc.stateFile)
+}
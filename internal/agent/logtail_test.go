@@ -0,0 +1,184 @@
+package agent
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/shatrunoff/yap_metrics/internal/model"
+)
+
+var testLogRules = []LogRule{
+	{Name: "LogErrors", Type: "counter", Pattern: regexp.MustCompile(`\bERROR\b`)},
+	{Name: "HTTPResponses", Type: "counter", Pattern: regexp.MustCompile(`" (?P<status>5\d\d) `)},
+	{Name: "ResponseBytes", Type: "counter", Pattern: regexp.MustCompile(`bytes=(?P<value>\d+)`)},
+	{Name: "QueueDepth", Type: "gauge", Pattern: regexp.MustCompile(`queue=(?P<value>[0-9.]+)`)},
+}
+
+func appendLog(t *testing.T, path, lines string) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(lines); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLogTailCollector(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	state := filepath.Join(dir, "state.json")
+	appendLog(t, path, "ERROR old line before start\n")
+
+	lc, err := NewLogTailCollector([]string{path}, testLogRules, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		name   string
+		action func()
+		want   map[string]float64
+	}{
+		{
+			name:   "Existing content is skipped",
+			action: func() {},
+			want:   map[string]float64{},
+		},
+		{
+			name: "Appended lines are counted",
+			action: func() {
+				appendLog(t, path, `ERROR db timeout
+"GET / HTTP/1.1" 502 bytes=100
+"GET /a HTTP/1.1" 503 bytes=20
+"GET /b HTTP/1.1" 502 bytes=5
+INFO queue=3
+INFO queue=7.5
+ERROR partial`)
+			},
+			want: map[string]float64{
+				"LogErrors":                   1,
+				`HTTPResponses{status="502"}`: 2,
+				`HTTPResponses{status="503"}`: 1,
+				"ResponseBytes":               125,
+				"QueueDepth":                  7.5,
+			},
+		},
+		{
+			name:   "Partial line is finished",
+			action: func() { appendLog(t, path, " write\n") },
+			want:   map[string]float64{"LogErrors": 1},
+		},
+		{
+			name: "Rotation drains the old file",
+			action: func() {
+				appendLog(t, path, "ERROR last in old file\n")
+				os.Rename(path, path+".1")
+				appendLog(t, path, "ERROR first in new file\n")
+			},
+			want: map[string]float64{"LogErrors": 2},
+		},
+		{
+			name: "Truncation restarts from the beginning",
+			action: func() {
+				os.Truncate(path, 0)
+				appendLog(t, path, "ERROR after truncate\n")
+			},
+			want: map[string]float64{"LogErrors": 1},
+		},
+	}
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			step.action()
+			got := collectValues(t, lc)
+			if len(got) != len(step.want) {
+				t.Errorf("got %v, want %v", got, step.want)
+			}
+			for id, want := range step.want {
+				if got[id] != want {
+					t.Errorf("%s = %v, want %v", id, got[id], want)
+				}
+			}
+		})
+	}
+}
+
+func TestLogTailCollectorRestart(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	state := filepath.Join(dir, "state.json")
+	missing := filepath.Join(dir, "later.log")
+	appendLog(t, path, "INFO start\n")
+
+	lc, err := NewLogTailCollector([]string{path, missing}, testLogRules, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	collectValues(t, lc)
+	appendLog(t, path, "ERROR seen before restart\n")
+	collectValues(t, lc)
+
+	// пока агент не работал, в журнал дописали строку; появившийся второй
+	// файл без сохранённой позиции читается с конца
+	appendLog(t, path, "ERROR written while stopped\n")
+	appendLog(t, missing, "ERROR in new file\n")
+
+	restarted, err := NewLogTailCollector([]string{path, missing}, testLogRules, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := collectValues(t, restarted)["LogErrors"]; got != 1 {
+		t.Errorf("LogErrors after restart = %v, want 1 (only the line written while stopped)", got)
+	}
+	if got := collectValues(t, restarted)["LogErrors"]; got != 0 {
+		t.Errorf("LogErrors = %v, want 0", got)
+	}
+
+	// файл подменили, пока агент не работал: читаем заново
+	os.WriteFile(path, []byte("ERROR replaced file with a different head\n"), 0644)
+	again, err := NewLogTailCollector([]string{path}, testLogRules, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := collectValues(t, again)["LogErrors"]; got != 1 {
+		t.Errorf("LogErrors after replacement = %v, want 1", got)
+	}
+}
+
+func TestLogTailCollectorLongLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	appendLog(t, path, "")
+
+	lc, err := NewLogTailCollector([]string{path}, testLogRules, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lc.maxRead = 16
+	collectValues(t, lc)
+
+	// строка длиннее окна чтения без перевода строки не должна останавливать чтение
+	appendLog(t, path, "ERROR "+strings.Repeat("x", 40)+" ERROR tail\nERROR short\n")
+	got := make(map[string]float64)
+	for i := 0; i < 10; i++ {
+		for id, v := range collectValues(t, lc) {
+			got[id] += v
+		}
+	}
+
+	want := map[string]float64{
+		"LogErrors": 1,
+		model.LabeledID(LogTailDroppedMetric, map[string]string{"file": path}): 1,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if info, _ := os.Stat(path); lc.files[path].offset != info.Size() {
+		t.Errorf("offset = %d, want %d", lc.files[path].offset, info.Size())
+	}
+}
@@ -0,0 +1,246 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	model "github.com/shatrunoff/yap_metrics/internal/model"
+)
+
+const segmentExt = ".json"
+
+// сегмент очереди: одна неотправленная пачка метрик в отдельном файле
+type segment struct {
+	seq  uint64
+	size int64
+}
+
+// состояние очереди для self-метрик
+type OutboxStats struct {
+	Depth   int
+	Bytes   int64
+	Evicted int64
+}
+
+// очередь неотправленных пачек на диске; при переполнении выбрасывает
+// самые старые, перенося их счётчики в следующую пачку
+type Outbox struct {
+	dir      string
+	maxBytes int64
+
+	segments []segment
+	bytes    int64
+	nextSeq  uint64
+	evicted  int64
+	mu       sync.Mutex
+}
+
+// открывает очередь в dir, подхватывая сегменты прошлого запуска
+func OpenOutbox(dir string, maxBytes int64) (*Outbox, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	o := &Outbox{dir: dir, maxBytes: maxBytes, nextSeq: 1}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		o.segments = append(o.segments, segment{seq: seq, size: info.Size()})
+		o.bytes += info.Size()
+		if seq >= o.nextSeq {
+			o.nextSeq = seq + 1
+		}
+	}
+	sort.Slice(o.segments, func(i, j int) bool { return o.segments[i].seq < o.segments[j].seq })
+
+	return o, nil
+}
+
+func (o *Outbox) path(seq uint64) string {
+	return filepath.Join(o.dir, fmt.Sprintf("%020d%s", seq, segmentExt))
+}
+
+// атомарно записывает пачку в файл сегмента
+func (o *Outbox) write(seq uint64, batch map[string]model.Metrics) (int64, error) {
+	metrics := make([]model.Metrics, 0, len(batch))
+	for _, metric := range batch {
+		metrics = append(metrics, metric)
+	}
+	data, err := json.Marshal(metrics)
+	if err != nil {
+		return 0, err
+	}
+
+	tmp := o.path(seq) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(tmp, o.path(seq)); err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+	return int64(len(data)), nil
+}
+
+func (o *Outbox) read(seq uint64) (map[string]model.Metrics, error) {
+	data, err := os.ReadFile(o.path(seq))
+	if err != nil {
+		return nil, err
+	}
+
+	var metrics []model.Metrics
+	if err := json.Unmarshal(data, &metrics); err != nil {
+		return nil, fmt.Errorf("corrupted outbox segment %d: %w", seq, err)
+	}
+
+	batch := make(map[string]model.Metrics, len(metrics))
+	for _, metric := range metrics {
+		batch[metric.ID] = metric
+	}
+	return batch, nil
+}
+
+// ставит пачку в конец очереди
+func (o *Outbox) Push(batch map[string]model.Metrics) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	seq := o.nextSeq
+	size, err := o.write(seq, batch)
+	if err != nil {
+		return fmt.Errorf("failed to persist batch: %w", err)
+	}
+	o.nextSeq++
+	o.segments = append(o.segments, segment{seq: seq, size: size})
+	o.bytes += size
+
+	// последнюю пачку не выбрасываем, даже если она одна больше лимита
+	for o.maxBytes > 0 && o.bytes > o.maxBytes && len(o.segments) > 1 {
+		if err := o.evictOldest(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// выбрасывает самую старую пачку: gauge теряются, а приращения
+// счётчиков переносятся в следующую, чтобы сервер получил их сумму
+func (o *Outbox) evictOldest() error {
+	oldest, next := o.segments[0], o.segments[1]
+
+	oldBatch, err := o.read(oldest.seq)
+	if err == nil {
+		nextBatch, err := o.read(next.seq)
+		if err != nil {
+			return err
+		}
+		MergeCounters(nextBatch, oldBatch)
+
+		size, err := o.write(next.seq, nextBatch)
+		if err != nil {
+			return err
+		}
+		o.bytes += size - next.size
+		o.segments[1].size = size
+	}
+
+	if err := os.Remove(o.path(oldest.seq)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	o.segments = o.segments[1:]
+	o.bytes -= oldest.size
+	o.evicted++
+	return nil
+}
+
+// добавляет приращения счётчиков из src в dst
+func MergeCounters(dst, src map[string]model.Metrics) {
+	for name, metric := range src {
+		if metric.MType != model.Counter || metric.Delta == nil {
+			continue
+		}
+		delta := *metric.Delta
+		if exist, ok := dst[name]; ok && exist.MType == model.Counter && exist.Delta != nil {
+			delta += *exist.Delta
+		}
+		dst[name] = model.Metrics{ID: name, MType: model.Counter, Delta: &delta}
+	}
+}
+
+// самая старая пачка без удаления из очереди
+func (o *Outbox) Peek() (map[string]model.Metrics, bool, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if len(o.segments) == 0 {
+		return nil, false, nil
+	}
+	batch, err := o.read(o.segments[0].seq)
+	if err != nil {
+		return nil, false, err
+	}
+	return batch, true, nil
+}
+
+// удаляет самую старую пачку после успешной отправки
+func (o *Outbox) Pop() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if len(o.segments) == 0 {
+		return nil
+	}
+	oldest := o.segments[0]
+	if err := os.Remove(o.path(oldest.seq)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	o.segments = o.segments[1:]
+	o.bytes -= oldest.size
+	return nil
+}
+
+// заменяет самую старую пачку её неотправленной частью
+func (o *Outbox) ReplaceHead(batch map[string]model.Metrics) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if len(o.segments) == 0 {
+		return nil
+	}
+	head := o.segments[0]
+	size, err := o.write(head.seq, batch)
+	if err != nil {
+		return fmt.Errorf("failed to persist batch: %w", err)
+	}
+	o.bytes += size - head.size
+	o.segments[0].size = size
+	return nil
+}
+
+func (o *Outbox) Stats() OutboxStats {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return OutboxStats{Depth: len(o.segments), Bytes: o.bytes, Evicted: o.evicted}
+}
@@ -0,0 +1,77 @@
+package agent
+
+import (
+	"testing"
+
+	model "github.com/shatrunoff/yap_metrics/internal/model"
+)
+
+func batch(pollCount int64, alloc float64) map[string]model.Metrics {
+	return map[string]model.Metrics{
+		"PollCount": {ID: "PollCount", MType: model.Counter, Delta: &pollCount},
+		"Alloc":     {ID: "Alloc", MType: model.Gauge, Value: &alloc},
+	}
+}
+
+func TestOutbox(t *testing.T) {
+	dir := t.TempDir()
+
+	outbox, err := OpenOutbox(dir, 0)
+	if err != nil {
+		t.Fatalf("OpenOutbox() error = %v", err)
+	}
+	for i := int64(1); i <= 3; i++ {
+		if err := outbox.Push(batch(i, float64(i))); err != nil {
+			t.Fatalf("Push() error = %v", err)
+		}
+	}
+	segmentSize := outbox.Stats().Bytes / 3
+
+	// очередь переживает перезапуск и сохраняет порядок
+	reopened, err := OpenOutbox(dir, 2*segmentSize+segmentSize/2)
+	if err != nil {
+		t.Fatalf("OpenOutbox() error = %v", err)
+	}
+	if got := reopened.Stats().Depth; got != 3 {
+		t.Fatalf("Depth after reopen = %d, want 3", got)
+	}
+
+	// переполнение выбрасывает самые старые пачки, сохраняя сумму счётчиков
+	if err := reopened.Push(batch(4, 4)); err != nil {
+		t.Fatalf("Push() error = %v", err)
+	}
+	stats := reopened.Stats()
+	if stats.Depth != 2 || stats.Evicted != 2 {
+		t.Fatalf("Stats() = %+v, want depth 2 and 2 evicted", stats)
+	}
+
+	tests := []struct {
+		name      string
+		pollCount int64
+		alloc     float64
+	}{
+		{name: "Oldest kept batch has merged counters", pollCount: 1 + 2 + 3, alloc: 3},
+		{name: "Newest batch is untouched", pollCount: 4, alloc: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok, err := reopened.Peek()
+			if err != nil || !ok {
+				t.Fatalf("Peek() = %v, %v", ok, err)
+			}
+			if delta := *got["PollCount"].Delta; delta != tt.pollCount {
+				t.Errorf("PollCount = %d, want %d", delta, tt.pollCount)
+			}
+			if value := *got["Alloc"].Value; value != tt.alloc {
+				t.Errorf("Alloc = %v, want %v", value, tt.alloc)
+			}
+			if err := reopened.Pop(); err != nil {
+				t.Fatalf("Pop() error = %v", err)
+			}
+		})
+	}
+
+	if _, ok, _ := reopened.Peek(); ok {
+		t.Errorf("Peek() on drained outbox returned a batch")
+	}
+}
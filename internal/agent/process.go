@@ -0,0 +1,231 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	model "github.com/shatrunoff/yap_metrics/internal/model"
+)
+
+// тиков процессорного времени в секунде (USER_HZ, на Linux почти всегда 100)
+const clockTicks = 100
+
+// ProcessCollector собирает метрики выбранных процессов из /proc.
+// Процессы выбираются по шаблонам имени (comm) и pid-файлам;
+// метрики помечаются метками process и pid.
+type ProcessCollector struct {
+	root     string
+	names    *NameFilter
+	pidfiles []string
+	now      func() time.Time
+
+	// процессорное время с прошлого сбора: для загрузки в процентах
+	mu   sync.Mutex
+	prev map[int]cpuSample
+}
+
+type cpuSample struct {
+	ticks uint64
+	at    time.Time
+}
+
+// снимок процесса
+type procStat struct {
+	comm     string
+	cpuTicks uint64
+	rss      uint64
+	threads  uint64
+	// -1 — каталог fd недоступен
+	fds int
+}
+
+// root — каталог procfs, пустой — /proc
+func NewProcessCollector(root string, names, pidfiles []string) *ProcessCollector {
+	if root == "" {
+		root = "/proc"
+	}
+	var filter *NameFilter
+	if len(names) > 0 {
+		filter = NewNameFilter(names, nil)
+	}
+	return &ProcessCollector{
+		root:     root,
+		names:    filter,
+		pidfiles: pidfiles,
+		now:      time.Now,
+		prev:     make(map[int]cpuSample),
+	}
+}
+
+func (pc *ProcessCollector) Name() string { return "process" }
+
+func (pc *ProcessCollector) Interval() time.Duration { return 0 }
+
+func (pc *ProcessCollector) Collect(ctx context.Context) ([]model.Metrics, error) {
+	// ошибки pid-файлов не мешают собрать остальные процессы
+	pids, err := pc.pids()
+	errs := []error{err}
+
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	now := pc.now()
+	seen := make(map[int]bool, len(pids))
+	var res []model.Metrics
+	for _, pid := range pids {
+		stat, err := readProcStat(pc.root, pid)
+		if err != nil {
+			// процесс мог завершиться между чтением каталога и файлов
+			if !errors.Is(err, os.ErrNotExist) {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		seen[pid] = true
+
+		labels := map[string]string{"process": stat.comm, "pid": strconv.Itoa(pid)}
+		gauge := func(name string, value float64) {
+			res = append(res, model.Metrics{ID: model.LabeledID(name, labels), MType: model.Gauge, Value: &value})
+		}
+		gauge("ProcessCPUSeconds", float64(stat.cpuTicks)/clockTicks)
+		gauge("ProcessRSS", float64(stat.rss))
+		gauge("ProcessThreads", float64(stat.threads))
+		if stat.fds >= 0 {
+			gauge("ProcessOpenFDs", float64(stat.fds))
+		}
+
+		// загрузка считается между сборами, первый сбор её не даёт
+		if prev, ok := pc.prev[pid]; ok && now.After(prev.at) && stat.cpuTicks >= prev.ticks {
+			cpu := float64(stat.cpuTicks-prev.ticks) / clockTicks
+			gauge("ProcessCPUPercent", 100*cpu/now.Sub(prev.at).Seconds())
+		}
+		pc.prev[pid] = cpuSample{ticks: stat.cpuTicks, at: now}
+	}
+
+	for pid := range pc.prev {
+		if !seen[pid] {
+			delete(pc.prev, pid)
+		}
+	}
+	return res, errors.Join(errs...)
+}
+
+// pid процессов, подходящих под шаблоны имён или указанных в pid-файлах
+func (pc *ProcessCollector) pids() ([]int, error) {
+	set := make(map[int]bool)
+	var errs []error
+
+	for _, path := range pc.pidfiles {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("pidfile: %w", err))
+			continue
+		}
+		pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+		if err != nil || pid <= 0 {
+			errs = append(errs, fmt.Errorf("pidfile %s: invalid pid %q", path, bytes.TrimSpace(data)))
+			continue
+		}
+		set[pid] = true
+	}
+
+	if pc.names != nil {
+		entries, err := os.ReadDir(pc.root)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		for _, entry := range entries {
+			pid, err := strconv.Atoi(entry.Name())
+			if err != nil || !entry.IsDir() {
+				continue
+			}
+			comm, err := os.ReadFile(filepath.Join(pc.root, entry.Name(), "comm"))
+			if err != nil {
+				continue
+			}
+			if pc.names.Allow(strings.TrimSpace(string(comm))) {
+				set[pid] = true
+			}
+		}
+	}
+
+	pids := make([]int, 0, len(set))
+	for pid := range set {
+		pids = append(pids, pid)
+	}
+	return pids, errors.Join(errs...)
+}
+
+// читает stat, status и fd процесса
+func readProcStat(root string, pid int) (procStat, error) {
+	dir := filepath.Join(root, strconv.Itoa(pid))
+	res := procStat{fds: -1}
+
+	// pid (comm) state ppid ...; comm может содержать пробелы и скобки
+	data, err := os.ReadFile(filepath.Join(dir, "stat"))
+	if err != nil {
+		return res, err
+	}
+	open, closing := bytes.IndexByte(data, '('), bytes.LastIndexByte(data, ')')
+	if open < 0 || closing < open {
+		return res, fmt.Errorf("%s/stat: malformed", dir)
+	}
+	res.comm = string(data[open+1 : closing])
+	fields := strings.Fields(string(data[closing+1:]))
+	// после comm: state(3) ... utime(14) stime(15)
+	if len(fields) < 13 {
+		return res, fmt.Errorf("%s/stat: too few fields", dir)
+	}
+	utime, err1 := strconv.ParseUint(fields[11], 10, 64)
+	stime, err2 := strconv.ParseUint(fields[12], 10, 64)
+	if err := errors.Join(err1, err2); err != nil {
+		return res, fmt.Errorf("%s/stat: %w", dir, err)
+	}
+	res.cpuTicks = utime + stime
+
+	status, err := os.Open(filepath.Join(dir, "status"))
+	if err != nil {
+		return res, err
+	}
+	defer status.Close()
+	scanner := bufio.NewScanner(status)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch key {
+		case "VmRSS":
+			kb, err := strconv.ParseUint(strings.TrimSuffix(value, " kB"), 10, 64)
+			if err != nil {
+				return res, fmt.Errorf("%s/status: VmRSS: %w", dir, err)
+			}
+			res.rss = kb * 1024
+		case "Threads":
+			if res.threads, err = strconv.ParseUint(value, 10, 64); err != nil {
+				return res, fmt.Errorf("%s/status: Threads: %w", dir, err)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return res, err
+	}
+
+	// fd чужих процессов без прав не читается — тогда метрики нет
+	if fds, err := os.ReadDir(filepath.Join(dir, "fd")); err == nil {
+		res.fds = len(fds)
+	} else if errors.Is(err, os.ErrNotExist) {
+		return res, err
+	}
+	return res, nil
+}
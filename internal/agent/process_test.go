@@ -0,0 +1,122 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// создаёт процесс в поддельном /proc
+func writeFakeProc(t *testing.T, root string, pid int, comm string, utime, stime uint64, rssKB, threads, fds int) {
+	t.Helper()
+	dir := filepath.Join(root, strconv.Itoa(pid))
+	if err := os.MkdirAll(filepath.Join(dir, "fd"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	stat := fmt.Sprintf("%d (%s) S 1 %d %d 0 -1 4194560 100 0 0 0 %d %d 0 0 20 0 %d 0 100 1000 10\n",
+		pid, comm, pid, pid, utime, stime, threads)
+	status := fmt.Sprintf("Name:\t%s\nState:\tS (sleeping)\nVmRSS:\t%8d kB\nThreads:\t%d\n", comm, rssKB, threads)
+	files := map[string]string{"stat": stat, "status": status, "comm": comm + "\n"}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < fds; i++ {
+		os.WriteFile(filepath.Join(dir, "fd", strconv.Itoa(i)), nil, 0644)
+	}
+}
+
+func TestProcessCollector(t *testing.T) {
+	root := t.TempDir()
+	writeFakeProc(t, root, 100, "nginx", 150, 50, 2048, 4, 3)
+	writeFakeProc(t, root, 200, "nginx", 10, 0, 1024, 1, 1)
+	writeFakeProc(t, root, 300, "postgres", 500, 100, 8192, 8, 12)
+	writeFakeProc(t, root, 400, "my (odd) app", 0, 0, 512, 2, 0)
+	os.WriteFile(filepath.Join(root, "self"), nil, 0644)
+
+	pidfile := filepath.Join(t.TempDir(), "db.pid")
+	os.WriteFile(pidfile, []byte("300\n"), 0644)
+
+	pc := NewProcessCollector(root, []string{"ngin*", "my (odd) app"}, []string{pidfile})
+	now := time.Unix(1000, 0)
+	pc.now = func() time.Time { return now }
+
+	collect := func() map[string]float64 {
+		t.Helper()
+		metrics, err := pc.Collect(context.Background())
+		if err != nil {
+			t.Fatalf("Collect() error = %v", err)
+		}
+		res := make(map[string]float64, len(metrics))
+		for _, m := range metrics {
+			res[m.ID] = *m.Value
+		}
+		return res
+	}
+
+	first := collect()
+	tests := []struct {
+		id   string
+		want float64
+	}{
+		{`ProcessCPUSeconds{pid="100",process="nginx"}`, 2},
+		{`ProcessRSS{pid="100",process="nginx"}`, 2048 * 1024},
+		{`ProcessThreads{pid="100",process="nginx"}`, 4},
+		{`ProcessOpenFDs{pid="100",process="nginx"}`, 3},
+		{`ProcessRSS{pid="200",process="nginx"}`, 1024 * 1024},
+		{`ProcessOpenFDs{pid="300",process="postgres"}`, 12},
+		{`ProcessThreads{pid="400",process="my (odd) app"}`, 2},
+	}
+	for _, tt := range tests {
+		if got, ok := first[tt.id]; !ok || got != tt.want {
+			t.Errorf("%s = %v (present %v), want %v", tt.id, got, ok, tt.want)
+		}
+	}
+	if _, ok := first[`ProcessCPUPercent{pid="100",process="nginx"}`]; ok {
+		t.Errorf("CPU percent reported on first collect")
+	}
+	if len(first) != 4*4 {
+		t.Errorf("got %d metrics, want 16: %v", len(first), first)
+	}
+
+	// за 10 секунд процесс 100 потратил 5 секунд процессора, процесс 200 завершился
+	writeFakeProc(t, root, 100, "nginx", 550, 150, 2048, 4, 3)
+	os.RemoveAll(filepath.Join(root, "200"))
+	now = now.Add(10 * time.Second)
+
+	second := collect()
+	if got := second[`ProcessCPUPercent{pid="100",process="nginx"}`]; got != 50 {
+		t.Errorf("CPU percent = %v, want 50", got)
+	}
+	if got, ok := second[`ProcessCPUPercent{pid="300",process="postgres"}`]; !ok || got != 0 {
+		t.Errorf("idle CPU percent = %v (present %v), want 0", got, ok)
+	}
+	if _, ok := second[`ProcessRSS{pid="200",process="nginx"}`]; ok {
+		t.Errorf("exited process still reported")
+	}
+	if _, ok := pc.prev[200]; ok {
+		t.Errorf("state of exited process kept")
+	}
+}
+
+func TestProcessCollectorBadPidfile(t *testing.T) {
+	root := t.TempDir()
+	writeFakeProc(t, root, 100, "nginx", 1, 1, 1, 1, 1)
+
+	pidfile := filepath.Join(t.TempDir(), "bad.pid")
+	os.WriteFile(pidfile, []byte("not-a-pid"), 0644)
+
+	pc := NewProcessCollector(root, []string{"nginx"}, []string{pidfile, "/nonexistent.pid"})
+	metrics, err := pc.Collect(context.Background())
+	if err == nil {
+		t.Errorf("Collect() error = nil, want pidfile errors")
+	}
+	if len(metrics) == 0 {
+		t.Errorf("pidfile errors dropped metrics of matched processes")
+	}
+}
@@ -0,0 +1,31 @@
+package agent
+
+import (
+	"net"
+	"net/url"
+	"strings"
+)
+
+// определяет адрес интерфейса, через который агент ходит на сервер;
+// UDP-сокет только выбирает маршрут, пакеты не отправляются
+func OutboundIP(serverURL string) (string, error) {
+	host := serverURL
+	if strings.Contains(serverURL, "://") {
+		u, err := url.Parse(serverURL)
+		if err != nil {
+			return "", err
+		}
+		host = u.Host
+	}
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(host, "80")
+	}
+
+	conn, err := net.Dial("udp", host)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	return conn.LocalAddr().(*net.UDPAddr).IP.String(), nil
+}
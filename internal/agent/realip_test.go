@@ -0,0 +1,45 @@
+package agent
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/shatrunoff/yap_metrics/internal/model"
+)
+
+func TestOutboundIP(t *testing.T) {
+	for _, serverURL := range []string{"127.0.0.1:8080", "http://127.0.0.1:8080", "127.0.0.1"} {
+		ip, err := OutboundIP(serverURL)
+		if err != nil {
+			t.Fatalf("OutboundIP(%q) error = %v", serverURL, err)
+		}
+		if ip != "127.0.0.1" {
+			t.Errorf("OutboundIP(%q) = %s, want 127.0.0.1", serverURL, ip)
+		}
+	}
+}
+
+func TestSenderSetsRealIP(t *testing.T) {
+	for _, realIP := range []string{"10.0.0.7", ""} {
+		var got []string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = append(got, r.Header.Get("X-Real-IP"))
+		}))
+
+		sender := NewSender(server.URL)
+		sender.RealIP = realIP
+		value := 1.5
+		err := sender.SendJSON(map[string]model.Metrics{
+			"Alloc": {ID: "Alloc", MType: model.Gauge, Value: &value},
+		})
+		server.Close()
+		if err != nil {
+			t.Fatalf("SendJSON() error = %v", err)
+		}
+
+		if len(got) != 1 || got[0] != realIP {
+			t.Errorf("X-Real-IP = %q, want [%q]", got, realIP)
+		}
+	}
+}
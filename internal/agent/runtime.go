@@ -0,0 +1,81 @@
+package agent
+
+import (
+	"context"
+	"math/rand"
+	"runtime"
+	"sync"
+	"time"
+
+	model "github.com/shatrunoff/yap_metrics/internal/model"
+)
+
+// RuntimeCollector собирает runtime.MemStats, RandomValue и PollCount
+type RuntimeCollector struct {
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+func NewRuntimeCollector() *RuntimeCollector {
+	return &RuntimeCollector{
+		rand: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+func (rc *RuntimeCollector) Name() string { return "runtime" }
+
+func (rc *RuntimeCollector) Interval() time.Duration { return 0 }
+
+// сбор метрик
+func (rc *RuntimeCollector) Collect(ctx context.Context) ([]model.Metrics, error) {
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+
+	// Генерируем случайное значение
+	rc.mu.Lock()
+	randomValue := rc.rand.Float64()
+	rc.mu.Unlock()
+
+	// Создаем мапу метрик gauge
+	gaugeMetrics := map[string]float64{
+		"Alloc":         float64(memStats.Alloc),
+		"BuckHashSys":   float64(memStats.BuckHashSys),
+		"Frees":         float64(memStats.Frees),
+		"GCCPUFraction": memStats.GCCPUFraction,
+		"GCSys":         float64(memStats.GCSys),
+		"HeapAlloc":     float64(memStats.HeapAlloc),
+		"HeapIdle":      float64(memStats.HeapIdle),
+		"HeapInuse":     float64(memStats.HeapInuse),
+		"HeapObjects":   float64(memStats.HeapObjects),
+		"HeapReleased":  float64(memStats.HeapReleased),
+		"HeapSys":       float64(memStats.HeapSys),
+		"LastGC":        float64(memStats.LastGC),
+		"Lookups":       float64(memStats.Lookups),
+		"MCacheInuse":   float64(memStats.MCacheInuse),
+		"MCacheSys":     float64(memStats.MCacheSys),
+		"MSpanInuse":    float64(memStats.MSpanInuse),
+		"MSpanSys":      float64(memStats.MSpanSys),
+		"Mallocs":       float64(memStats.Mallocs),
+		"NextGC":        float64(memStats.NextGC),
+		"NumForcedGC":   float64(memStats.NumForcedGC),
+		"NumGC":         float64(memStats.NumGC),
+		"OtherSys":      float64(memStats.OtherSys),
+		"PauseTotalNs":  float64(memStats.PauseTotalNs),
+		"StackInuse":    float64(memStats.StackInuse),
+		"StackSys":      float64(memStats.StackSys),
+		"Sys":           float64(memStats.Sys),
+		"TotalAlloc":    float64(memStats.TotalAlloc),
+		"RandomValue":   randomValue, // Добавляем случайное значение
+	}
+
+	metrics := make([]model.Metrics, 0, len(gaugeMetrics)+1)
+	for name, value := range gaugeMetrics {
+		metrics = append(metrics, model.Metrics{ID: name, MType: model.Gauge, Value: &value})
+	}
+
+	// counter
+	pollCount := int64(1)
+	metrics = append(metrics, model.Metrics{ID: "PollCount", MType: model.Counter, Delta: &pollCount})
+
+	return metrics, nil
+}
@@ -3,6 +3,8 @@ package agent
 import (
 	"bytes"
 	"compress/gzip"
+	"crypto/rsa"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -11,14 +13,36 @@ import (
 	"net/url"
 	"path"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/shatrunoff/yap_metrics/internal/envelope"
 	model "github.com/shatrunoff/yap_metrics/internal/model"
 )
 
 type Sender struct {
 	ServerURL string
 	Client    *http.Client
+	// токен доступа к серверу, пустой — без аутентификации
+	Token string
+	// схема, если ServerURL задан без неё
+	Scheme string
+	// открытый ключ сервера; если задан, тела запросов шифруются
+	PublicKey *rsa.PublicKey
+	// адрес агента для заголовка X-Real-IP
+	RealIP string
+	// накопленная статистика отправки
+	Stats SendStats
+}
+
+// счётчики отправки с момента запуска
+type SendStats struct {
+	// байты JSON до и после сжатия
+	RawBytes        atomic.Int64
+	CompressedBytes atomic.Int64
+	// метрики, которые не удалось подготовить к отправке
+	Dropped atomic.Int64
 }
 
 func NewSender(ServerURL string) *Sender {
@@ -27,9 +51,26 @@ func NewSender(ServerURL string) *Sender {
 		Client: &http.Client{
 			Timeout: 4 * time.Second,
 		},
+		Scheme: "http",
 	}
 }
 
+// переключает отправку на HTTPS с заданными настройками TLS
+func (s *Sender) UseTLS(cfg *tls.Config) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = cfg
+	s.Client.Transport = transport
+	s.Scheme = "https"
+}
+
+// адрес сервера со схемой
+func (s *Sender) baseURL() string {
+	if strings.Contains(s.ServerURL, "://") {
+		return s.ServerURL
+	}
+	return s.Scheme + "://" + s.ServerURL
+}
+
 func newMetricURL(baseURL, metricType, metricID, value string) (string, error) {
 	u, err := url.Parse(baseURL)
 	if err != nil {
@@ -40,6 +81,16 @@ func newMetricURL(baseURL, metricType, metricID, value string) (string, error) {
 	return u.String(), nil
 }
 
+// добавляет токен доступа и адрес агента к запросу
+func (s *Sender) authorize(request *http.Request) {
+	if s.Token != "" {
+		request.Header.Set("Authorization", "Bearer "+s.Token)
+	}
+	if s.RealIP != "" {
+		request.Header.Set("X-Real-IP", s.RealIP)
+	}
+}
+
 // compressData сжимает данные с помощью gzip
 func compressData(data []byte) ([]byte, error) {
 	var buf bytes.Buffer
@@ -55,9 +106,11 @@ func compressData(data []byte) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
-// Новый метод для отправки через JSON с поддержкой gzip
+// Новый метод для отправки через JSON с поддержкой gzip;
+// при ошибке после части метрик возвращает *PartialError
 func (s *Sender) SendJSON(metrics map[string]model.Metrics) error {
-	for _, metric := range metrics {
+	var sent []string
+	for id, metric := range metrics {
 		// Пропускаем метрики без значений
 		if (metric.MType == model.Gauge && metric.Value == nil) ||
 			(metric.MType == model.Counter && metric.Delta == nil) {
@@ -68,6 +121,7 @@ func (s *Sender) SendJSON(metrics map[string]model.Metrics) error {
 		jsonData, err := json.Marshal(metric)
 		if err != nil {
 			log.Printf("ERROR: failed to marshal metric %s: %v", metric.ID, err)
+			s.Stats.Dropped.Add(1)
 			continue
 		}
 
@@ -75,39 +129,92 @@ func (s *Sender) SendJSON(metrics map[string]model.Metrics) error {
 		compressedData, err := compressData(jsonData)
 		if err != nil {
 			log.Printf("ERROR: failed to compress data for metric %s: %v", metric.ID, err)
+			s.Stats.Dropped.Add(1)
 			continue
 		}
+		s.Stats.RawBytes.Add(int64(len(jsonData)))
+		s.Stats.CompressedBytes.Add(int64(len(compressedData)))
 
-		// Создаем запрос
-		url := "http://" + s.ServerURL + "/update/"
-		request, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(compressedData))
-		if err != nil {
-			return fmt.Errorf("FAILED to create request: %w", err)
+		if err := s.postJSON("/update/", compressedData, "metric "+metric.ID); err != nil {
+			return partial(sent, err)
 		}
-		request.Header.Set("Content-Type", "application/json")
-		request.Header.Set("Content-Encoding", "gzip")
-		request.Header.Set("Accept-Encoding", "gzip")
+		sent = append(sent, id)
+	}
+	return nil
+}
 
-		// Отправляем
-		response, err := s.Client.Do(request)
-		if err != nil {
-			return fmt.Errorf("FAILED to send metric %s: %w", metric.ID, err)
+// SendBatch отправляет все метрики одним запросом на /updates/
+func (s *Sender) SendBatch(metrics map[string]model.Metrics) error {
+	batch := make([]model.Metrics, 0, len(metrics))
+	for _, metric := range metrics {
+		if (metric.MType == model.Gauge && metric.Value == nil) ||
+			(metric.MType == model.Counter && metric.Delta == nil) {
+			continue
 		}
-		defer response.Body.Close()
+		batch = append(batch, metric)
+	}
+	if len(batch) == 0 {
+		return nil
+	}
 
-		// Читаем тело ответа для диагностики
-		body, _ := io.ReadAll(response.Body)
+	jsonData, err := json.Marshal(batch)
+	if err != nil {
+		s.Stats.Dropped.Add(int64(len(batch)))
+		return fmt.Errorf("FAILED to marshal batch: %w", err)
+	}
+	compressedData, err := compressData(jsonData)
+	if err != nil {
+		s.Stats.Dropped.Add(int64(len(batch)))
+		return fmt.Errorf("FAILED to compress batch: %w", err)
+	}
+	s.Stats.RawBytes.Add(int64(len(jsonData)))
+	s.Stats.CompressedBytes.Add(int64(len(compressedData)))
 
-		if response.StatusCode != http.StatusOK {
-			return fmt.Errorf("FAIL status for %s: %d, body: %s", metric.ID, response.StatusCode, string(body))
+	return s.postJSON("/updates/", compressedData, fmt.Sprintf("batch of %d metrics", len(batch)))
+}
+
+// отправляет сжатый JSON, при необходимости зашифровав его; what — что отправляется, для ошибок
+func (s *Sender) postJSON(path string, compressedData []byte, what string) error {
+	body := compressedData
+	if s.PublicKey != nil {
+		var err error
+		if body, err = envelope.Encrypt(s.PublicKey, compressedData); err != nil {
+			return fmt.Errorf("FAILED to encrypt %s: %w", what, err)
 		}
 	}
+
+	request, err := http.NewRequest(http.MethodPost, s.baseURL()+path, bytes.NewBuffer(body))
+	if err != nil {
+		return fmt.Errorf("FAILED to create request: %w", err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Content-Encoding", "gzip")
+	request.Header.Set("Accept-Encoding", "gzip")
+	s.authorize(request)
+	if s.PublicKey != nil {
+		request.Header.Set(envelope.Header, envelope.Scheme)
+		request.Header.Set(envelope.KeyIDHeader, envelope.KeyID(s.PublicKey))
+	}
+
+	response, err := s.Client.Do(request)
+	if err != nil {
+		return fmt.Errorf("FAILED to send %s: %w", what, err)
+	}
+	defer response.Body.Close()
+
+	// тело ответа для диагностики
+	respBody, _ := io.ReadAll(response.Body)
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("FAIL status for %s: %d, body: %s", what, response.StatusCode, string(respBody))
+	}
 	return nil
 }
 
+// отправка по одной метрике в пути запроса; при ошибке после части
+// метрик возвращает *PartialError
 func (s *Sender) Send(metrics map[string]model.Metrics) error {
-
-	for _, metric := range metrics {
+	var sent []string
+	for id, metric := range metrics {
 		// парсим значение метрики в строку
 		var strValue string
 		switch metric.MType {
@@ -127,7 +234,7 @@ func (s *Sender) Send(metrics map[string]model.Metrics) error {
 
 		// полный URL
 		url, err := newMetricURL(
-			"http://"+s.ServerURL,
+			s.baseURL(),
 			metric.MType,
 			metric.ID,
 			strValue,
@@ -139,21 +246,23 @@ func (s *Sender) Send(metrics map[string]model.Metrics) error {
 		// POST-запрос
 		request, err := http.NewRequest(http.MethodPost, url, nil)
 		if err != nil {
-			return fmt.Errorf("FAILED to create request: %w", err)
+			return partial(sent, fmt.Errorf("FAILED to create request: %w", err))
 		}
 		request.Header.Set("Content-Type", "text/plain")
 		request.Header.Set("Accept-Encoding", "gzip")
+		s.authorize(request)
 
 		// отправляем
 		response, err := s.Client.Do(request)
 		if err != nil {
-			return fmt.Errorf("FAILED to send metric %s: %w", metric.ID, err)
+			return partial(sent, fmt.Errorf("FAILED to send metric %s: %w", metric.ID, err))
 		}
 		response.Body.Close()
 
 		if response.StatusCode != http.StatusOK {
-			return fmt.Errorf("FAIL status for %s: %d", metric.ID, response.StatusCode)
+			return partial(sent, fmt.Errorf("FAIL status for %s: %d", metric.ID, response.StatusCode))
 		}
+		sent = append(sent, id)
 	}
 	return nil
 }
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package agent
+
+import "errors"
+
+// таблица монтирования из procfs есть только в Linux
+func statfs(path string) (fsUsage, error) {
+	return fsUsage{}, errors.New("statfs is not supported on this platform")
+}
@@ -0,0 +1,435 @@
+package aggregate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shatrunoff/yap_metrics/internal/expr"
+	"github.com/shatrunoff/yap_metrics/internal/model"
+	"github.com/shatrunoff/yap_metrics/internal/selfmetrics"
+)
+
+// операции агрегации
+const (
+	OpSum   = "sum"
+	OpAvg   = "avg"
+	OpMin   = "min"
+	OpMax   = "max"
+	OpCount = "count"
+)
+
+// собственные метрики вычисления правил
+const (
+	DurationMetric = "aggregation_duration_seconds"
+	ErrorsMetric   = "aggregation_errors_total"
+)
+
+// Rule — правило агрегации: значения всех метрик, подходящих под Selector,
+// сводятся операцией Op в gauge Name отдельно для каждого набора значений меток By.
+// Selector записывается как ID метрики: HeapAlloc{dc="eu"}; '*' в имени совпадает
+// с любой подстрокой, метки должны совпадать точно.
+//
+// Правило записи вместо Op и Selector задаёт выражение Expr (см. пакет expr);
+// каждая серия результата записывается в gauge Name со своими метками.
+type Rule struct {
+	Name     string   `json:"name"`
+	Op       string   `json:"op,omitempty"`
+	Selector string   `json:"selector,omitempty"`
+	By       []string `json:"by,omitempty"`
+	Expr     string   `json:"expr,omitempty"`
+
+	pattern *regexp.Regexp
+	labels  map[string]string
+	expr    *expr.Expr
+}
+
+// содержимое файла правил
+type Config struct {
+	Rules []Rule `json:"rules"`
+}
+
+// читает и проверяет файл правил
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("invalid aggregation file %s: %w", path, err)
+	}
+	if err := cfg.compile(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// проверяет правила и разбирает их селекторы
+func (c *Config) compile() error {
+	seen := make(map[string]bool, len(c.Rules))
+	for i := range c.Rules {
+		rule := &c.Rules[i]
+		if rule.Name == "" || strings.ContainsAny(rule.Name, `{}"*`) {
+			return fmt.Errorf("rule %d: invalid name %q", i, rule.Name)
+		}
+		if selfmetrics.Reserved(rule.Name) {
+			return fmt.Errorf("rule %d (%s): names starting with %s are reserved", i, rule.Name, selfmetrics.Namespace)
+		}
+		if seen[rule.Name] {
+			return fmt.Errorf("rule %d: duplicate name %q", i, rule.Name)
+		}
+		seen[rule.Name] = true
+
+		if rule.Expr != "" {
+			if rule.Op != "" || rule.Selector != "" || len(rule.By) > 0 {
+				return fmt.Errorf("rule %d (%s): expr cannot be combined with op, selector or by", i, rule.Name)
+			}
+			parsed, err := expr.Parse(rule.Expr)
+			if err != nil {
+				return fmt.Errorf("rule %d (%s): invalid expr: %w", i, rule.Name, err)
+			}
+			rule.expr = parsed
+			continue
+		}
+
+		switch rule.Op {
+		case OpSum, OpAvg, OpMin, OpMax, OpCount:
+		default:
+			return fmt.Errorf("rule %d (%s): unknown op %q", i, rule.Name, rule.Op)
+		}
+
+		name, labels := model.ParseID(rule.Selector)
+		if name == "" || strings.ContainsAny(name, `{}"`) {
+			return fmt.Errorf("rule %d (%s): invalid selector %q", i, rule.Name, rule.Selector)
+		}
+		parts := strings.Split(name, "*")
+		for j := range parts {
+			parts[j] = regexp.QuoteMeta(parts[j])
+		}
+		rule.pattern = regexp.MustCompile("^" + strings.Join(parts, ".*") + "$")
+		rule.labels = labels
+
+		for _, key := range rule.By {
+			if key == "" || strings.ContainsAny(key, `{}"=,`) {
+				return fmt.Errorf("rule %d (%s): invalid group-by label %q", i, rule.Name, key)
+			}
+		}
+	}
+	return nil
+}
+
+// подходит ли серия под селектор правила
+func (r *Rule) match(name string, labels map[string]string) bool {
+	if !r.pattern.MatchString(name) {
+		return false
+	}
+	for k, v := range r.labels {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// накопленное значение одной группы
+type group struct {
+	sum, min, max float64
+	count         int
+}
+
+func (g *group) add(v float64) {
+	if g.count == 0 {
+		g.min, g.max = v, v
+	}
+	g.sum += v
+	g.min = math.Min(g.min, v)
+	g.max = math.Max(g.max, v)
+	g.count++
+}
+
+func (g *group) result(op string) float64 {
+	switch op {
+	case OpAvg:
+		return g.sum / float64(g.count)
+	case OpMin:
+		return g.min
+	case OpMax:
+		return g.max
+	case OpCount:
+		return float64(g.count)
+	default:
+		return g.sum
+	}
+}
+
+// вычисляет правило по всем метрикам; метрики с именами из skip не участвуют,
+// чтобы результаты правил не попадали обратно в агрегаты.
+// Возвращает значения по ID результирующих метрик и число учтённых серий.
+func (r *Rule) evaluate(all map[string]model.Metrics, skip map[string]bool) (map[string]float64, int) {
+	groups := make(map[string]*group)
+	series := 0
+	for id, metric := range all {
+		name, labels := model.ParseID(id)
+		if skip[name] || !r.match(name, labels) {
+			continue
+		}
+
+		var value float64
+		switch {
+		case metric.MType == model.Gauge && metric.Value != nil:
+			value = *metric.Value
+		case metric.MType == model.Counter && metric.Delta != nil:
+			value = float64(*metric.Delta)
+		default:
+			continue
+		}
+
+		// метки группы; отсутствующая метка в ID не попадает
+		key := make(map[string]string, len(r.By))
+		for _, k := range r.By {
+			if v, ok := labels[k]; ok && v != "" {
+				key[k] = v
+			}
+		}
+		outID := model.LabeledID(r.Name, key)
+
+		g, ok := groups[outID]
+		if !ok {
+			g = &group{}
+			groups[outID] = g
+		}
+		g.add(value)
+		series++
+	}
+
+	res := make(map[string]float64, len(groups))
+	for id, g := range groups {
+		res[id] = g.result(r.Op)
+	}
+	return res, series
+}
+
+// вычисляет правило записи; результаты — по ID результирующих метрик
+func (r *Rule) record(src expr.Source) (map[string]float64, error) {
+	res, err := r.expr.Eval(src)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]float64, len(res.Samples))
+	for _, sample := range res.Samples {
+		values[model.LabeledID(r.Name, sample.Labels)] = sample.Value
+	}
+	return values, nil
+}
+
+// хранилище, по которому вычисляются правила
+type Target interface {
+	GetAll() map[string]model.Metrics
+	GetRates() map[string]model.Rate
+	UpdateGauge(name string, value float64)
+	Delete(metricType, name string) bool
+}
+
+// результат последнего вычисления правила
+type Status struct {
+	LastEvaluation  *time.Time `json:"last_evaluation,omitempty"`
+	DurationSeconds float64    `json:"duration_seconds"`
+	// учтённые серии и записанные результирующие метрики
+	Series int    `json:"series"`
+	Groups int    `json:"groups"`
+	Error  string `json:"error,omitempty"`
+}
+
+// правило вместе с его состоянием для API
+type RuleStatus struct {
+	Rule
+	Status
+}
+
+// Engine периодически вычисляет правила по хранилищам арендаторов
+// и записывает результаты в них же
+type Engine struct {
+	targets func() map[string]Target
+	metrics *selfmetrics.Registry
+
+	mu     sync.Mutex
+	rules  []Rule
+	status map[string]map[string]Status
+
+	// записанные метрики по арендаторам и правилам, чтобы удалять пропавшие группы
+	evalMu  sync.Mutex
+	written map[string]map[string]map[string]bool
+}
+
+// дополнительная настройка вычисления правил
+type Option func(e *Engine)
+
+// включает собственные метрики: длительность вычисления и ошибки по правилам
+func WithMetrics(reg *selfmetrics.Registry) Option {
+	return func(e *Engine) {
+		e.metrics = reg
+	}
+}
+
+// targets возвращает хранилища по идентификаторам арендаторов; nil cfg — без правил
+func NewEngine(cfg *Config, targets func() map[string]Target, opts ...Option) *Engine {
+	e := &Engine{
+		targets: targets,
+		status:  make(map[string]map[string]Status),
+		written: make(map[string]map[string]map[string]bool),
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	e.SetConfig(cfg)
+	return e
+}
+
+// заменяет правила; результаты удалённых правил стираются при следующем вычислении
+func (e *Engine) SetConfig(cfg *Config) {
+	var rules []Rule
+	if cfg != nil {
+		rules = slices.Clone(cfg.Rules)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.rules = rules
+}
+
+// вычисляет все правила по всем арендаторам
+func (e *Engine) Evaluate() {
+	e.evalMu.Lock()
+	defer e.evalMu.Unlock()
+
+	e.mu.Lock()
+	rules := e.rules
+	e.mu.Unlock()
+
+	skip := make(map[string]bool, len(rules))
+	for _, rule := range rules {
+		skip[rule.Name] = true
+	}
+
+	for id, target := range e.targets() {
+		written := e.written[id]
+		if written == nil {
+			written = make(map[string]map[string]bool)
+			e.written[id] = written
+		}
+
+		all := target.GetAll()
+		statuses := make(map[string]Status, len(rules))
+		for _, rule := range rules {
+			statuses[rule.Name] = e.evaluateRule(target, &rule, all, skip, written)
+		}
+
+		// результаты правил, которых больше нет
+		for name, ids := range written {
+			if _, ok := statuses[name]; ok {
+				continue
+			}
+			for outID := range ids {
+				target.Delete(model.Gauge, outID)
+			}
+			delete(written, name)
+		}
+
+		e.mu.Lock()
+		e.status[id] = statuses
+		e.mu.Unlock()
+	}
+}
+
+// вычисляет одно правило и записывает результаты в хранилище арендатора
+func (e *Engine) evaluateRule(target Target, rule *Rule, all map[string]model.Metrics,
+	skip map[string]bool, written map[string]map[string]bool) Status {
+	start := time.Now()
+	labels := map[string]string{"rule": rule.Name}
+
+	var values map[string]float64
+	var series int
+	if rule.expr != nil {
+		// выражение читает хранилище заново, чтобы видеть результаты предыдущих правил;
+		// при ошибке прежние результаты остаются на месте
+		var err error
+		if values, err = rule.record(target); err != nil {
+			e.metrics.Add(ErrorsMetric, labels, 1)
+			return Status{LastEvaluation: &start, DurationSeconds: time.Since(start).Seconds(),
+				Groups: len(written[rule.Name]), Error: err.Error()}
+		}
+		series = len(values)
+	} else {
+		values, series = rule.evaluate(all, skip)
+	}
+
+	var errs []error
+	current := make(map[string]bool, len(values))
+	for outID, value := range values {
+		// gauge затёр бы счётчик клиента с тем же ID
+		if existing, ok := all[outID]; ok && existing.MType != model.Gauge {
+			errs = append(errs, fmt.Errorf("metric %s is a %s, result not written", outID, existing.MType))
+			continue
+		}
+		target.UpdateGauge(outID, value)
+		current[outID] = true
+	}
+	for outID := range written[rule.Name] {
+		if !current[outID] {
+			target.Delete(model.Gauge, outID)
+		}
+	}
+	written[rule.Name] = current
+
+	duration := time.Since(start)
+	e.metrics.Observe(DurationMetric, labels, duration)
+
+	status := Status{
+		LastEvaluation:  &start,
+		DurationSeconds: duration.Seconds(),
+		Series:          series,
+		Groups:          len(current),
+	}
+	if err := errors.Join(errs...); err != nil {
+		e.metrics.Add(ErrorsMetric, labels, 1)
+		status.Error = err.Error()
+	}
+	return status
+}
+
+// правила и результаты их последнего вычисления для арендатора
+func (e *Engine) Statuses(tenantID string) []RuleStatus {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	res := make([]RuleStatus, 0, len(e.rules))
+	for _, rule := range e.rules {
+		res = append(res, RuleStatus{Rule: rule, Status: e.status[tenantID][rule.Name]})
+	}
+	return res
+}
+
+// вычисляет правила раз в interval до отмены контекста
+func (e *Engine) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			e.Evaluate()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
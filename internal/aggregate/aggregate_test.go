@@ -0,0 +1,182 @@
+package aggregate
+
+import (
+	"testing"
+
+	"github.com/shatrunoff/yap_metrics/internal/model"
+	"github.com/shatrunoff/yap_metrics/internal/storage"
+	"github.com/shatrunoff/yap_metrics/internal/tenant"
+)
+
+func TestConfigCompile(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    Rule
+		wantErr bool
+	}{
+		{name: "Valid rule", rule: Rule{Name: "fleet_HeapAlloc", Op: OpSum, Selector: `HeapAlloc{dc="eu"}`, By: []string{"dc"}}},
+		{name: "Wildcard selector", rule: Rule{Name: "fleet_Heap", Op: OpMax, Selector: "*_HeapAlloc"}},
+		{name: "Empty name", rule: Rule{Op: OpSum, Selector: "HeapAlloc"}, wantErr: true},
+		{name: "Labels in name", rule: Rule{Name: `total{dc="eu"}`, Op: OpSum, Selector: "HeapAlloc"}, wantErr: true},
+		{name: "Reserved name", rule: Rule{Name: "_server_total", Op: OpSum, Selector: "HeapAlloc"}, wantErr: true},
+		{name: "Unknown op", rule: Rule{Name: "total", Op: "median", Selector: "HeapAlloc"}, wantErr: true},
+		{name: "Empty selector", rule: Rule{Name: "total", Op: OpSum}, wantErr: true},
+		{name: "Broken selector", rule: Rule{Name: "total", Op: OpSum, Selector: `HeapAlloc{dc=eu}`}, wantErr: true},
+		{name: "Recording rule", rule: Rule{Name: "heap_usage", Expr: "HeapInuse / HeapSys * 100"}},
+		{name: "Invalid expr", rule: Rule{Name: "heap_usage", Expr: "HeapInuse /"}, wantErr: true},
+		{name: "Expr with op", rule: Rule{Name: "heap_usage", Op: OpSum, Expr: "HeapInuse"}, wantErr: true},
+		{name: "Invalid group-by label", rule: Rule{Name: "total", Op: OpSum, Selector: "HeapAlloc", By: []string{""}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{Rules: []Rule{tt.rule}}
+			if err := cfg.compile(); (err != nil) != tt.wantErr {
+				t.Errorf("compile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	t.Run("Duplicate name", func(t *testing.T) {
+		rule := Rule{Name: "total", Op: OpSum, Selector: "HeapAlloc"}
+		if err := (&Config{Rules: []Rule{rule, rule}}).compile(); err == nil {
+			t.Error("compile() accepted duplicate rule names")
+		}
+	})
+}
+
+func gauge(t *testing.T, st *storage.MemStorage, id string) (float64, bool) {
+	t.Helper()
+	m, ok := st.GetMetric(model.Gauge, id)
+	if !ok {
+		return 0, false
+	}
+	return *m.Value, true
+}
+
+func TestEngineEvaluate(t *testing.T) {
+	st := storage.NewMemStorage()
+	st.UpdateGauge(`HeapAlloc{dc="eu",host="a"}`, 1)
+	st.UpdateGauge(`HeapAlloc{dc="eu",host="b"}`, 3)
+	st.UpdateGauge(`HeapAlloc{dc="us",host="c"}`, 8)
+	st.UpdateGauge("web1_HeapAlloc", 10)
+	st.UpdateCounter(`PollCount{host="a"}`, 4)
+	st.UpdateCounter(`PollCount{host="b"}`, 6)
+
+	cfg := &Config{Rules: []Rule{
+		{Name: "heap_sum", Op: OpSum, Selector: "HeapAlloc", By: []string{"dc"}},
+		{Name: "heap_avg", Op: OpAvg, Selector: "HeapAlloc"},
+		{Name: "heap_min", Op: OpMin, Selector: `HeapAlloc{dc="eu"}`},
+		{Name: "heap_max", Op: OpMax, Selector: "*HeapAlloc"},
+		{Name: "hosts", Op: OpCount, Selector: "HeapAlloc", By: []string{"host"}},
+		{Name: "polls", Op: OpSum, Selector: "PollCount"},
+	}}
+	if err := cfg.compile(); err != nil {
+		t.Fatal(err)
+	}
+	engine := NewEngine(cfg, func() map[string]Target {
+		return map[string]Target{tenant.Default: st}
+	})
+	engine.Evaluate()
+
+	want := map[string]float64{
+		`heap_sum{dc="eu"}`: 4,
+		`heap_sum{dc="us"}`: 8,
+		"heap_avg":          4,
+		"heap_min":          1,
+		"heap_max":          10,
+		`hosts{host="a"}`:   1,
+		`hosts{host="c"}`:   1,
+		"polls":             10,
+	}
+	for id, value := range want {
+		if got, ok := gauge(t, st, id); !ok || got != value {
+			t.Errorf("%s = %v (found %v), want %v", id, got, ok, value)
+		}
+	}
+
+	statuses := engine.Statuses(tenant.Default)
+	if len(statuses) != len(cfg.Rules) {
+		t.Fatalf("Statuses() returned %d rules, want %d", len(statuses), len(cfg.Rules))
+	}
+	if s := statuses[0]; s.LastEvaluation == nil || s.Series != 3 || s.Groups != 2 || s.Error != "" {
+		t.Errorf("heap_sum status = %+v", s.Status)
+	}
+
+	t.Run("Derived metrics are not aggregated again", func(t *testing.T) {
+		engine.Evaluate()
+		if got, _ := gauge(t, st, "heap_max"); got != 10 {
+			t.Errorf("heap_max = %v after second evaluation, want 10", got)
+		}
+	})
+
+	t.Run("Vanished group is deleted", func(t *testing.T) {
+		st.Delete(model.Gauge, `HeapAlloc{dc="us",host="c"}`)
+		engine.Evaluate()
+		if _, ok := gauge(t, st, `heap_sum{dc="us"}`); ok {
+			t.Error(`heap_sum{dc="us"} still stored`)
+		}
+	})
+
+	t.Run("Counter with the same ID is not overwritten", func(t *testing.T) {
+		st.UpdateCounter("heap_avg", 1)
+		engine.Evaluate()
+		if _, ok := st.GetMetric(model.Counter, "heap_avg"); !ok {
+			t.Error("counter heap_avg was overwritten")
+		}
+		if s := engine.Statuses(tenant.Default)[1]; s.Error == "" {
+			t.Error("heap_avg status has no error")
+		}
+	})
+
+	t.Run("Removed rule results are deleted", func(t *testing.T) {
+		engine.SetConfig(&Config{Rules: cfg.Rules[:1]})
+		engine.Evaluate()
+		if _, ok := gauge(t, st, "polls"); ok {
+			t.Error("polls still stored after its rule was removed")
+		}
+		if _, ok := gauge(t, st, `heap_sum{dc="eu"}`); !ok {
+			t.Error("heap_sum removed together with other rules")
+		}
+	})
+}
+
+func TestEngineRecordingRules(t *testing.T) {
+	st := storage.NewMemStorage()
+	st.UpdateGauge(`HeapInuse{host="a"}`, 50)
+	st.UpdateGauge(`HeapSys{host="a"}`, 200)
+	st.UpdateGauge(`HeapInuse{host="b"}`, 30)
+	st.UpdateGauge(`HeapSys{host="b"}`, 100)
+
+	cfg := &Config{Rules: []Rule{
+		{Name: "heap_total", Op: OpSum, Selector: "HeapSys"},
+		{Name: "heap_usage", Expr: "HeapInuse / HeapSys * 100"},
+		// результаты предыдущих правил доступны выражениям
+		{Name: "heap_avg", Expr: "heap_total / count(HeapSys)"},
+		{Name: "broken", Expr: "1 / 0"},
+	}}
+	if err := cfg.compile(); err != nil {
+		t.Fatal(err)
+	}
+	engine := NewEngine(cfg, func() map[string]Target {
+		return map[string]Target{tenant.Default: st}
+	})
+	engine.Evaluate()
+
+	want := map[string]float64{
+		`heap_usage{host="a"}`: 25,
+		`heap_usage{host="b"}`: 30,
+		"heap_avg":             150,
+	}
+	for id, value := range want {
+		if got, ok := gauge(t, st, id); !ok || got != value {
+			t.Errorf("%s = %v (found %v), want %v", id, got, ok, value)
+		}
+	}
+	statuses := engine.Statuses(tenant.Default)
+	if s := statuses[1]; s.Series != 2 || s.Groups != 2 || s.Error != "" {
+		t.Errorf("heap_usage status = %+v", s.Status)
+	}
+	if s := statuses[3]; s.Error == "" {
+		t.Error("broken rule status has no error")
+	}
+}
@@ -0,0 +1,176 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+type Role string
+
+const (
+	// обновление метрик
+	RoleWriter Role = "writer"
+	// чтение значений, списка и выгрузок
+	RoleReader Role = "reader"
+	// удаление, резервное копирование и настройка; включает остальные роли
+	RoleAdmin Role = "admin"
+)
+
+type Token struct {
+	Name string `json:"name"`
+	// sha256 токена в hex, сам токен на сервере не хранится
+	SHA256 string `json:"sha256"`
+	Roles  []Role `json:"roles"`
+}
+
+// содержимое файла токенов
+type Config struct {
+	Tokens []Token `json:"tokens"`
+}
+
+// хэш токена в том виде, в котором он лежит в конфиге
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// токен из заголовка Authorization: Bearer <token>
+func BearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if len(header) > 7 && strings.EqualFold(header[:7], "Bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+	return ""
+}
+
+// читает и проверяет файл токенов
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("invalid auth file %s: %w", path, err)
+	}
+
+	for i, t := range cfg.Tokens {
+		if len(t.SHA256) != sha256.Size*2 {
+			return nil, fmt.Errorf("token %d (%s): sha256 must be %d hex chars", i, t.Name, sha256.Size*2)
+		}
+		if _, err := hex.DecodeString(t.SHA256); err != nil {
+			return nil, fmt.Errorf("token %d (%s): invalid sha256: %w", i, t.Name, err)
+		}
+		for _, role := range t.Roles {
+			switch role {
+			case RoleWriter, RoleReader, RoleAdmin:
+			default:
+				return nil, fmt.Errorf("token %d (%s): unknown role %q", i, t.Name, role)
+			}
+		}
+	}
+
+	return &cfg, nil
+}
+
+type ctxKey struct{}
+
+// имя токена, которым аутентифицирован запрос
+func NameFromContext(ctx context.Context) string {
+	name, _ := ctx.Value(ctxKey{}).(string)
+	return name
+}
+
+// проверяет токены запросов; набор токенов можно заменить на лету
+type Authenticator struct {
+	// nil — аутентификация выключена
+	tokens atomic.Pointer[map[string]Token]
+}
+
+// cfg == nil выключает аутентификацию
+func NewAuthenticator(cfg *Config) *Authenticator {
+	a := &Authenticator{}
+	a.Update(cfg)
+	return a
+}
+
+// атомарно заменяет набор токенов; cfg == nil выключает аутентификацию
+func (a *Authenticator) Update(cfg *Config) {
+	if cfg == nil {
+		a.tokens.Store(nil)
+		return
+	}
+
+	tokens := make(map[string]Token, len(cfg.Tokens))
+	for _, t := range cfg.Tokens {
+		tokens[strings.ToLower(t.SHA256)] = t
+	}
+	a.tokens.Store(&tokens)
+}
+
+// включена ли аутентификация
+func (a *Authenticator) Enabled() bool {
+	return a != nil && a.tokens.Load() != nil
+}
+
+func (t Token) hasRole(role Role) bool {
+	for _, r := range t.Roles {
+		if r == role || r == RoleAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// предъявлен ли в запросе действующий токен с ролью role;
+// без аутентификации ни у одного запроса ролей нет
+func (a *Authenticator) HasRole(r *http.Request, role Role) bool {
+	if a == nil {
+		return false
+	}
+	tokens := a.tokens.Load()
+	if tokens == nil {
+		return false
+	}
+	token, ok := (*tokens)[HashToken(BearerToken(r))]
+	return ok && token.hasRole(role)
+}
+
+// middleware, пропускающий только токены с ролью role:
+// 401 без токена или с неизвестным токеном, 403 без нужной роли
+func (a *Authenticator) Require(role Role) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		if a == nil {
+			return h
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tokens := a.tokens.Load()
+			if tokens == nil {
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			token, ok := (*tokens)[HashToken(BearerToken(r))]
+			if !ok {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+				http.Error(w, "ERROR: unauthorized", http.StatusUnauthorized)
+				return
+			}
+			if !token.hasRole(role) {
+				http.Error(w, "ERROR: forbidden", http.StatusForbidden)
+				return
+			}
+
+			h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), ctxKey{}, token.Name)))
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAuthenticatorRequire(t *testing.T) {
+	a := NewAuthenticator(&Config{Tokens: []Token{
+		{Name: "agent", SHA256: HashToken("writer-token"), Roles: []Role{RoleWriter}},
+		{Name: "root", SHA256: HashToken("admin-token"), Roles: []Role{RoleAdmin}},
+	}})
+
+	tests := []struct {
+		name   string
+		role   Role
+		token  string
+		status int
+	}{
+		{name: "No token", role: RoleWriter, token: "", status: http.StatusUnauthorized},
+		{name: "Unknown token", role: RoleWriter, token: "nope", status: http.StatusUnauthorized},
+		{name: "Writer writes", role: RoleWriter, token: "writer-token", status: http.StatusOK},
+		{name: "Writer cannot read", role: RoleReader, token: "writer-token", status: http.StatusForbidden},
+		{name: "Admin reads", role: RoleReader, token: "admin-token", status: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := a.Require(tt.role)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.token != "" {
+				request.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			recorder := httptest.NewRecorder()
+			h.ServeHTTP(recorder, request)
+
+			if recorder.Code != tt.status {
+				t.Errorf("Require(%s) status = %d, want %d", tt.role, recorder.Code, tt.status)
+			}
+		})
+	}
+}
+
+func TestAuthenticatorHasRole(t *testing.T) {
+	a := NewAuthenticator(&Config{Tokens: []Token{
+		{Name: "agent", SHA256: HashToken("writer-token"), Roles: []Role{RoleWriter}},
+		{Name: "root", SHA256: HashToken("admin-token"), Roles: []Role{RoleAdmin}},
+	}})
+
+	tests := []struct {
+		name  string
+		auth  *Authenticator
+		token string
+		want  bool
+	}{
+		{name: "Admin token", auth: a, token: "admin-token", want: true},
+		{name: "Writer token", auth: a, token: "writer-token"},
+		{name: "Unknown token", auth: a, token: "nope"},
+		{name: "No token", auth: a},
+		{name: "Auth disabled", auth: NewAuthenticator(nil), token: "admin-token"},
+		{name: "No authenticator", token: "admin-token"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.token != "" {
+				request.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			if got := tt.auth.HasRole(request, RoleAdmin); got != tt.want {
+				t.Errorf("HasRole(admin) = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
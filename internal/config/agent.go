@@ -1,17 +1,510 @@
 package config
 
-import "time"
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// известные сборщики метрик агента
+var KnownCollectors = []string{"runtime", "gometrics", "exec", "process", "disk", "net", "filesystem", "cgroup", "logtail"}
+
+// сборщики, включённые без явной настройки
+var DefaultCollectors = []string{"runtime"}
+
+// настройки отдельного сборщика
+type CollectorConfig struct {
+	// nil — по умолчанию (см. DefaultCollectors)
+	Enabled *bool `json:"enabled,omitempty"`
+	// 0 — общий PollInterval
+	Interval Duration `json:"interval,omitempty"`
+	// шаблоны имён ('*' — любая подстрока): метрик, устройств, интерфейсов
+	// или точек монтирования в зависимости от сборщика; пустой include — все
+	Include []string `json:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+	// команды сборщика exec
+	Commands []ExecCommandConfig `json:"commands,omitempty"`
+	// корень procfs или cgroup2 (для cgroup), пустой — системный
+	Root string `json:"root,omitempty"`
+	// шаблоны имён процессов и pid-файлы сборщика process
+	Processes []string `json:"processes,omitempty"`
+	Pidfiles  []string `json:"pidfiles,omitempty"`
+	// журналы, файл позиций и правила сборщика logtail
+	Files     []string        `json:"files,omitempty"`
+	StateFile string          `json:"state_file,omitempty"`
+	Rules     []LogRuleConfig `json:"rules,omitempty"`
+}
+
+// правило сборщика logtail: совпадения pattern превращаются в метрику name;
+// именованные группы — метки, группа value — значение gauge или приращение counter
+type LogRuleConfig struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Pattern string `json:"pattern"`
+}
+
+// внешняя команда, печатающая метрики
+type ExecCommandConfig struct {
+	Name string `json:"name"`
+	// программа и аргументы, без оболочки
+	Command []string `json:"command"`
+	// 0 — до конца интервала сборщика
+	Timeout Duration `json:"timeout,omitempty"`
+}
+
+// способы отправки метрик на сервер
+const (
+	// POST /update/{type}/{name}/{value} на каждую метрику
+	TransportPlain = "plain"
+	// POST /update/ с JSON на каждую метрику
+	TransportJSON = "json"
+	// POST /updates/ со всей пачкой одним JSON-массивом
+	TransportBatch = "batch"
+)
+
+// сервер, на который агент отправляет метрики
+type DestinationConfig struct {
+	// имя в логах, self-метриках и каталоге очереди
+	Name    string `json:"name"`
+	Address string `json:"address"`
+	// plain, json или batch; пустой — json
+	Transport string `json:"transport,omitempty"`
+	Token     string `json:"token,omitempty"`
+	// открытый ключ RSA сервера для шифрования тел запросов
+	CryptoKey string      `json:"crypto_key,omitempty"`
+	TLSCA     string      `json:"tls_ca,omitempty"`
+	TLSCert   string      `json:"tls_cert,omitempty"`
+	TLSKey    string      `json:"tls_key,omitempty"`
+	Retry     RetryConfig `json:"retry,omitempty"`
+}
+
+// повтор неудачной отправки с экспоненциальной паузой
+type RetryConfig struct {
+	// число попыток, 0 — одна попытка без повторов
+	Attempts int `json:"attempts,omitempty"`
+	// пауза перед первым повтором, удваивается до MaxBackoff
+	Backoff    Duration `json:"backoff,omitempty"`
+	MaxBackoff Duration `json:"max_backoff,omitempty"`
+}
+
+// имя единственного получателя, заданного адресом сервера
+const DefaultDestination = "default"
+
+var destinationName = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
 
 type AgentConfig struct {
 	PollInterval   time.Duration
 	ReportInterval time.Duration
 	ServerURL      string
+	// токен доступа к серверу
+	Token string
+	// TLS: закреплённый CA сервера и клиентский сертификат для mTLS
+	TLSCAFile   string
+	TLSCertFile string
+	TLSKeyFile  string
+	// открытый ключ RSA сервера для шифрования тел запросов
+	CryptoKey string
+	// каталог очереди неотправленных пачек (пустой — без очереди) и её лимит в байтах
+	OutboxDir      string
+	OutboxMaxBytes int64
+	// метки, добавляемые ко всем метрикам агента
+	Labels map[string]string
+	// настройки сборщиков по имени
+	Collectors map[string]CollectorConfig
+	// адрес локального HTTP-эндпоинта агента, пустой — выключен
+	Listen string
+	// префикс собственных метрик агента, пустой — не отправляются
+	SelfMetricsPrefix string
+	// получатели метрик; если не заданы, единственный получатель строится
+	// из ServerURL, Token, CryptoKey и настроек TLS
+	Destinations []DestinationConfig
+}
+
+// получатели метрик с учётом адреса сервера по умолчанию
+func (c *AgentConfig) DestinationList() []DestinationConfig {
+	if len(c.Destinations) > 0 {
+		return c.Destinations
+	}
+	return []DestinationConfig{{
+		Name:      DefaultDestination,
+		Address:   c.ServerURL,
+		Transport: TransportJSON,
+		Token:     c.Token,
+		CryptoKey: c.CryptoKey,
+		TLSCA:     c.TLSCAFile,
+		TLSCert:   c.TLSCertFile,
+		TLSKey:    c.TLSKeyFile,
+	}}
 }
 
 func DefaultAgentConfig() *AgentConfig {
 	return &AgentConfig{
-		PollInterval:   2 * time.Second,
-		ReportInterval: 10 * time.Second,
-		ServerURL:      "localhost:8080",
+		PollInterval:      2 * time.Second,
+		ReportInterval:    10 * time.Second,
+		ServerURL:         "localhost:8080",
+		OutboxMaxBytes:    10 << 20,
+		SelfMetricsPrefix: "Agent",
+	}
+}
+
+// включён ли сборщик
+func (c *AgentConfig) CollectorEnabled(name string) bool {
+	if cc, ok := c.Collectors[name]; ok && cc.Enabled != nil {
+		return *cc.Enabled
+	}
+	return slices.Contains(DefaultCollectors, name)
+}
+
+// интервал сборщика: переопределение из конфигурации, иначе def, иначе PollInterval
+func (c *AgentConfig) CollectorInterval(name string, def time.Duration) time.Duration {
+	if cc, ok := c.Collectors[name]; ok && cc.Interval > 0 {
+		return time.Duration(cc.Interval)
+	}
+	if def > 0 {
+		return def
+	}
+	return c.PollInterval
+}
+
+// формат JSON-файла конфигурации агента; nil — не задано
+type agentFile struct {
+	Address        *string                    `json:"address"`
+	PollInterval   *Duration                  `json:"poll_interval"`
+	ReportInterval *Duration                  `json:"report_interval"`
+	Token          *string                    `json:"token"`
+	CryptoKey      *string                    `json:"crypto_key"`
+	TLSCA          *string                    `json:"tls_ca"`
+	TLSCert        *string                    `json:"tls_cert"`
+	TLSKey         *string                    `json:"tls_key"`
+	OutboxDir      *string                    `json:"outbox_dir"`
+	OutboxMaxBytes *int64                     `json:"outbox_max_bytes"`
+	Labels         map[string]string          `json:"labels"`
+	Collectors     map[string]CollectorConfig `json:"collectors"`
+	Listen         *string                    `json:"listen"`
+	SelfPrefix     *string                    `json:"self_metrics_prefix"`
+	Destinations   []DestinationConfig        `json:"destinations"`
+}
+
+func (f *agentFile) apply(cfg *AgentConfig) {
+	setString(&cfg.ServerURL, f.Address)
+	if f.PollInterval != nil {
+		cfg.PollInterval = time.Duration(*f.PollInterval)
+	}
+	if f.ReportInterval != nil {
+		cfg.ReportInterval = time.Duration(*f.ReportInterval)
+	}
+	setString(&cfg.Token, f.Token)
+	setString(&cfg.CryptoKey, f.CryptoKey)
+	setString(&cfg.TLSCAFile, f.TLSCA)
+	setString(&cfg.TLSCertFile, f.TLSCert)
+	setString(&cfg.TLSKeyFile, f.TLSKey)
+	setString(&cfg.OutboxDir, f.OutboxDir)
+	if f.OutboxMaxBytes != nil {
+		cfg.OutboxMaxBytes = *f.OutboxMaxBytes
+	}
+	if f.Labels != nil {
+		cfg.Labels = f.Labels
+	}
+	if f.Collectors != nil {
+		cfg.Collectors = f.Collectors
+	}
+	setString(&cfg.Listen, f.Listen)
+	setString(&cfg.SelfMetricsPrefix, f.SelfPrefix)
+	if f.Destinations != nil {
+		cfg.Destinations = f.Destinations
+	}
+}
+
+// разбирает метки вида "k1=v1,k2=v2"
+func parseLabels(s string) (map[string]string, error) {
+	labels := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid label %q: expected key=value", pair)
+		}
+		labels[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return labels, nil
+}
+
+// значение флага с метками
+type labelsFlag struct {
+	labels *map[string]string
+}
+
+func (f labelsFlag) String() string {
+	if f.labels == nil {
+		return ""
+	}
+	pairs := make([]string, 0, len(*f.labels))
+	for k, v := range *f.labels {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (f labelsFlag) Set(s string) error {
+	labels, err := parseLabels(s)
+	if err != nil {
+		return err
+	}
+	*f.labels = labels
+	return nil
+}
+
+// Конфигурация агента: файл (-c/CONFIG) < переменные окружения < флаги.
+// Ошибки во всех источниках собираются и возвращаются вместе.
+func ParseAgentConfig(args []string, lookupEnv func(string) (string, bool)) (*AgentConfig, error) {
+	cfg := DefaultAgentConfig()
+
+	// флаги разбираем в отдельную копию, чтобы применить их последними
+	flagged := DefaultAgentConfig()
+	var configPath string
+
+	fs := flag.NewFlagSet("agent", flag.ContinueOnError)
+	fs.StringVar(&configPath, "c", "", "Config file (JSON)")
+	fs.StringVar(&flagged.ServerURL, "a", flagged.ServerURL, "Server address host:port")
+	fs.Var(durationFlag{&flagged.PollInterval}, "p", "PollInterval (seconds or duration like 2s)")
+	fs.Var(durationFlag{&flagged.ReportInterval}, "r", "ReportInterval (seconds or duration like 10s)")
+	fs.StringVar(&flagged.Token, "token", flagged.Token, "Server access token")
+	fs.StringVar(&flagged.CryptoKey, "crypto-key", flagged.CryptoKey, "Server RSA public key file to encrypt payloads (PEM)")
+	fs.StringVar(&flagged.OutboxDir, "outbox-dir", flagged.OutboxDir, "Directory to buffer unsent metrics (empty disables)")
+	fs.Int64Var(&flagged.OutboxMaxBytes, "outbox-max-bytes", flagged.OutboxMaxBytes, "Max outbox size in bytes")
+	fs.StringVar(&flagged.TLSCAFile, "tls-ca", flagged.TLSCAFile, "CA bundle to verify the server (enables HTTPS)")
+	fs.StringVar(&flagged.TLSCertFile, "tls-cert", flagged.TLSCertFile, "Client TLS certificate file (PEM)")
+	fs.StringVar(&flagged.TLSKeyFile, "tls-key", flagged.TLSKeyFile, "Client TLS private key file (PEM)")
+	fs.Var(labelsFlag{&flagged.Labels}, "labels", "Labels for all metrics: key=value,...")
+	fs.StringVar(&flagged.Listen, "listen", flagged.Listen, "Address for the local metrics and status endpoint (empty disables)")
+	fs.StringVar(&flagged.SelfMetricsPrefix, "self-prefix", flagged.SelfMetricsPrefix, "Name prefix for the agent's own metrics (empty disables)")
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unknown arguments: %v", fs.Args())
+	}
+
+	var errs []error
+
+	// файл конфигурации
+	if configPath == "" {
+		configPath, _ = lookupEnv("CONFIG")
+	}
+	if configPath != "" {
+		var file agentFile
+		if err := loadJSONFile(configPath, &file); err != nil {
+			errs = append(errs, err)
+		} else {
+			file.apply(cfg)
+		}
+	}
+
+	// переменные окружения
+	env := envReader{lookup: lookupEnv}
+	env.string("ADDRESS", &cfg.ServerURL)
+	env.duration("POLL_INTERVAL", &cfg.PollInterval)
+	env.duration("REPORT_INTERVAL", &cfg.ReportInterval)
+	env.string("TOKEN", &cfg.Token)
+	env.string("CRYPTO_KEY", &cfg.CryptoKey)
+	env.string("OUTBOX_DIR", &cfg.OutboxDir)
+	env.int64("OUTBOX_MAX_BYTES", &cfg.OutboxMaxBytes)
+	env.string("TLS_CA", &cfg.TLSCAFile)
+	env.string("TLS_CERT", &cfg.TLSCertFile)
+	env.string("TLS_KEY", &cfg.TLSKeyFile)
+	env.string("LISTEN", &cfg.Listen)
+	env.string("SELF_METRICS_PREFIX", &cfg.SelfMetricsPrefix)
+	if value, ok := lookupEnv("LABELS"); ok && value != "" {
+		if labels, err := parseLabels(value); err != nil {
+			env.errs = append(env.errs, fmt.Errorf("LABELS: %w", err))
+		} else {
+			cfg.Labels = labels
+		}
+	}
+	errs = append(errs, env.errs...)
+
+	// флаги
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "a":
+			cfg.ServerURL = flagged.ServerURL
+		case "p":
+			cfg.PollInterval = flagged.PollInterval
+		case "r":
+			cfg.ReportInterval = flagged.ReportInterval
+		case "token":
+			cfg.Token = flagged.Token
+		case "crypto-key":
+			cfg.CryptoKey = flagged.CryptoKey
+		case "outbox-dir":
+			cfg.OutboxDir = flagged.OutboxDir
+		case "outbox-max-bytes":
+			cfg.OutboxMaxBytes = flagged.OutboxMaxBytes
+		case "tls-ca":
+			cfg.TLSCAFile = flagged.TLSCAFile
+		case "tls-cert":
+			cfg.TLSCertFile = flagged.TLSCertFile
+		case "tls-key":
+			cfg.TLSKeyFile = flagged.TLSKeyFile
+		case "labels":
+			cfg.Labels = flagged.Labels
+		case "listen":
+			cfg.Listen = flagged.Listen
+		case "self-prefix":
+			cfg.SelfMetricsPrefix = flagged.SelfMetricsPrefix
+		}
+	})
+
+	errs = append(errs, cfg.Validate()...)
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return cfg, nil
+}
+
+var labelKey = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// проверяет конфигурацию и возвращает все найденные ошибки
+func (c *AgentConfig) Validate() []error {
+	var errs []error
+
+	if err := validateAddress(c.ServerURL); err != nil {
+		errs = append(errs, fmt.Errorf("address: %w", err))
+	}
+	if c.PollInterval <= 0 {
+		errs = append(errs, fmt.Errorf("poll interval must be positive, got %v", c.PollInterval))
+	}
+	if c.ReportInterval <= 0 {
+		errs = append(errs, fmt.Errorf("report interval must be positive, got %v", c.ReportInterval))
+	}
+	if c.Listen != "" {
+		if err := validateAddress(c.Listen); err != nil {
+			errs = append(errs, fmt.Errorf("listen: %w", err))
+		}
+	}
+	if c.SelfMetricsPrefix != "" && !labelKey.MatchString(c.SelfMetricsPrefix) {
+		errs = append(errs, fmt.Errorf("invalid self metrics prefix %q", c.SelfMetricsPrefix))
+	}
+	if c.OutboxMaxBytes < 0 {
+		errs = append(errs, fmt.Errorf("outbox max bytes must not be negative, got %d", c.OutboxMaxBytes))
+	}
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		errs = append(errs, errors.New("tls cert and tls key must be set together"))
+	}
+	for k := range c.Labels {
+		if !labelKey.MatchString(k) {
+			errs = append(errs, fmt.Errorf("invalid label name %q", k))
+		}
+	}
+
+	destinations := make(map[string]bool)
+	for i, dest := range c.Destinations {
+		prefix := fmt.Sprintf("destination %d", i)
+		switch {
+		case !destinationName.MatchString(dest.Name):
+			errs = append(errs, fmt.Errorf("%s: invalid name %q", prefix, dest.Name))
+		case destinations[dest.Name]:
+			errs = append(errs, fmt.Errorf("%s: duplicate name %q", prefix, dest.Name))
+		default:
+			prefix = "destination " + dest.Name
+		}
+		destinations[dest.Name] = true
+		errs = append(errs, dest.validate(prefix)...)
+	}
+
+	names := make([]string, 0, len(c.Collectors))
+	for name := range c.Collectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !slices.Contains(KnownCollectors, name) {
+			errs = append(errs, fmt.Errorf("unknown collector %q, known: %s", name, strings.Join(KnownCollectors, ", ")))
+		}
+		if c.Collectors[name].Interval < 0 {
+			errs = append(errs, fmt.Errorf("collector %s: interval must not be negative", name))
+		}
+		if cc := c.Collectors[name]; name == "process" && c.CollectorEnabled(name) && len(cc.Processes) == 0 && len(cc.Pidfiles) == 0 {
+			errs = append(errs, errors.New("collector process: processes or pidfiles are required"))
+		}
+		for i, rule := range c.Collectors[name].Rules {
+			errs = append(errs, rule.validate(fmt.Sprintf("collector %s: rule %d", name, i))...)
+		}
+		if cc := c.Collectors[name]; name == "logtail" && c.CollectorEnabled(name) && (len(cc.Files) == 0 || len(cc.Rules) == 0) {
+			errs = append(errs, errors.New("collector logtail: files and rules are required"))
+		}
+		seen := make(map[string]bool)
+		for i, cmd := range c.Collectors[name].Commands {
+			switch {
+			case cmd.Name == "":
+				errs = append(errs, fmt.Errorf("collector %s: command %d: name is required", name, i))
+			case seen[cmd.Name]:
+				errs = append(errs, fmt.Errorf("collector %s: duplicate command %q", name, cmd.Name))
+			}
+			seen[cmd.Name] = true
+			if len(cmd.Command) == 0 {
+				errs = append(errs, fmt.Errorf("collector %s: command %q is empty", name, cmd.Name))
+			}
+			if cmd.Timeout < 0 {
+				errs = append(errs, fmt.Errorf("collector %s: command %q: timeout must not be negative", name, cmd.Name))
+			}
+		}
+	}
+
+	return errs
+}
+
+func (d DestinationConfig) validate(prefix string) []error {
+	var errs []error
+	if err := validateAddress(d.Address); err != nil {
+		errs = append(errs, fmt.Errorf("%s: address: %w", prefix, err))
+	}
+	switch d.Transport {
+	case "", TransportPlain, TransportJSON, TransportBatch:
+	default:
+		errs = append(errs, fmt.Errorf("%s: transport must be plain, json or batch, got %q", prefix, d.Transport))
+	}
+	if d.Transport == TransportPlain && d.CryptoKey != "" {
+		errs = append(errs, fmt.Errorf("%s: plain transport does not support crypto key", prefix))
+	}
+	if (d.TLSCert == "") != (d.TLSKey == "") {
+		errs = append(errs, fmt.Errorf("%s: tls cert and tls key must be set together", prefix))
+	}
+	if d.Retry.Attempts < 0 || d.Retry.Backoff < 0 || d.Retry.MaxBackoff < 0 {
+		errs = append(errs, fmt.Errorf("%s: retry settings must not be negative", prefix))
+	}
+	return errs
+}
+
+func (r LogRuleConfig) validate(prefix string) []error {
+	var errs []error
+	if r.Name == "" {
+		errs = append(errs, fmt.Errorf("%s: name is required", prefix))
+	}
+	if r.Type != "counter" && r.Type != "gauge" {
+		errs = append(errs, fmt.Errorf("%s: type must be counter or gauge, got %q", prefix, r.Type))
+	}
+	re, err := regexp.Compile(r.Pattern)
+	if err != nil {
+		return append(errs, fmt.Errorf("%s: %w", prefix, err))
+	}
+	if r.Type == "gauge" && !slices.Contains(re.SubexpNames(), "value") {
+		errs = append(errs, fmt.Errorf("%s: gauge pattern needs a (?P<value>...) group", prefix))
+	}
+	for _, group := range re.SubexpNames() {
+		if group != "" && group != "value" && !labelKey.MatchString(group) {
+			errs = append(errs, fmt.Errorf("%s: invalid label name %q", prefix, group))
+		}
 	}
+	return errs
 }
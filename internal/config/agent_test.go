@@ -1,7 +1,10 @@
 package config
 
 import (
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 )
@@ -14,9 +17,11 @@ func TestDefaultAgentConfig(t *testing.T) {
 		{
 			name: "Default configuration values",
 			want: &AgentConfig{
-				PollInterval:   2 * time.Second,
-				ReportInterval: 10 * time.Second,
-				ServerURL:      "localhost:8080",
+				PollInterval:      2 * time.Second,
+				ReportInterval:    10 * time.Second,
+				ServerURL:         "localhost:8080",
+				OutboxMaxBytes:    10 << 20,
+				SelfMetricsPrefix: "Agent",
 			},
 		},
 	}
@@ -28,3 +33,149 @@ func TestDefaultAgentConfig(t *testing.T) {
 		})
 	}
 }
+
+func TestParseAgentConfig(t *testing.T) {
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "agent.json")
+	os.WriteFile(configFile, []byte(`{
+		"address": "file:1111",
+		"poll_interval": "500ms",
+		"report_interval": 7,
+		"labels": {"host": "web-1"},
+		"collectors": {"runtime": {"interval": "3s"}, "gometrics": {"enabled": true, "include": ["/gc/*"]}}
+	}`), 0644)
+
+	badExecFile := filepath.Join(dir, "bad-exec.json")
+	os.WriteFile(badExecFile, []byte(`{"collectors": {"exec": {"commands": [
+		{"name": "q", "command": ["queue-stats"]},
+		{"name": "q", "command": []},
+		{"command": ["x"], "timeout": "-1s"}
+	]}}}`), 0644)
+
+	destFile := filepath.Join(dir, "destinations.json")
+	os.WriteFile(destFile, []byte(`{"destinations": [
+		{"name": "old", "address": "old:8080"},
+		{"name": "new", "address": "https://new:8443", "transport": "batch", "retry": {"attempts": 3, "backoff": "1s"}}
+	]}`), 0644)
+
+	badDestFile := filepath.Join(dir, "bad-destinations.json")
+	os.WriteFile(badDestFile, []byte(`{"destinations": [
+		{"name": "a", "address": "a:1"},
+		{"name": "a", "address": "nohost", "transport": "grpc"},
+		{"name": "b/c", "address": "b:1", "retry": {"attempts": -1}}
+	]}`), 0644)
+
+	tests := []struct {
+		name    string
+		args    []string
+		env     map[string]string
+		check   func(t *testing.T, cfg *AgentConfig)
+		wantErr []string
+	}{
+		{
+			name: "File only",
+			args: []string{"-c", configFile},
+			check: func(t *testing.T, cfg *AgentConfig) {
+				if cfg.ServerURL != "file:1111" || cfg.PollInterval != 500*time.Millisecond || cfg.ReportInterval != 7*time.Second {
+					t.Errorf("got %s %v %v", cfg.ServerURL, cfg.PollInterval, cfg.ReportInterval)
+				}
+				if cfg.Labels["host"] != "web-1" || cfg.CollectorInterval("runtime", 0) != 3*time.Second {
+					t.Errorf("labels %v, runtime interval %v", cfg.Labels, cfg.CollectorInterval("runtime", 0))
+				}
+				if !cfg.CollectorEnabled("gometrics") || cfg.CollectorInterval("gometrics", 0) != 500*time.Millisecond {
+					t.Errorf("gometrics enabled %v, interval %v", cfg.CollectorEnabled("gometrics"), cfg.CollectorInterval("gometrics", 0))
+				}
+			},
+		},
+		{
+			name: "Env overrides file, flags override env",
+			args: []string{"-a", "flag:3333", "-listen", ":9100"},
+			env:  map[string]string{"CONFIG": configFile, "ADDRESS": "env:2222", "POLL_INTERVAL": "4s", "LISTEN": "localhost:9000"},
+			check: func(t *testing.T, cfg *AgentConfig) {
+				if cfg.Listen != ":9100" {
+					t.Errorf("Listen = %s, want :9100", cfg.Listen)
+				}
+				if cfg.ServerURL != "flag:3333" {
+					t.Errorf("ServerURL = %s, want flag:3333", cfg.ServerURL)
+				}
+				if cfg.PollInterval != 4*time.Second {
+					t.Errorf("PollInterval = %v, want 4s", cfg.PollInterval)
+				}
+			},
+		},
+		{
+			name: "Integer seconds in flags",
+			args: []string{"-p", "5", "-r", "1m"},
+			check: func(t *testing.T, cfg *AgentConfig) {
+				if cfg.PollInterval != 5*time.Second || cfg.ReportInterval != time.Minute {
+					t.Errorf("got %v %v", cfg.PollInterval, cfg.ReportInterval)
+				}
+				// без настройки включены только сборщики по умолчанию
+				if !cfg.CollectorEnabled("runtime") || cfg.CollectorEnabled("gometrics") {
+					t.Errorf("default collectors: runtime %v, gometrics %v", cfg.CollectorEnabled("runtime"), cfg.CollectorEnabled("gometrics"))
+				}
+			},
+		},
+		{
+			name:    "All errors reported at once",
+			args:    []string{"-a", "nohost"},
+			env:     map[string]string{"REPORT_INTERVAL": "soon", "POLL_INTERVAL": "-1s", "OUTBOX_MAX_BYTES": "lots", "LISTEN": "9100", "SELF_METRICS_PREFIX": "agent."},
+			wantErr: []string{"address", "REPORT_INTERVAL", "poll interval", "OUTBOX_MAX_BYTES", "listen", "self metrics prefix"},
+		},
+		{
+			name: "Destinations from file",
+			args: []string{"-c", destFile},
+			check: func(t *testing.T, cfg *AgentConfig) {
+				dests := cfg.DestinationList()
+				if len(dests) != 2 || dests[1].Transport != TransportBatch || dests[1].Retry.Attempts != 3 {
+					t.Errorf("destinations = %+v", dests)
+				}
+			},
+		},
+		{
+			name: "Default destination from address",
+			args: []string{"-a", "flag:3333", "-token", "secret"},
+			check: func(t *testing.T, cfg *AgentConfig) {
+				dests := cfg.DestinationList()
+				if len(dests) != 1 || dests[0].Name != DefaultDestination || dests[0].Address != "flag:3333" || dests[0].Token != "secret" {
+					t.Errorf("destinations = %+v", dests)
+				}
+			},
+		},
+		{
+			name:    "Invalid destinations",
+			args:    []string{"-c", badDestFile},
+			wantErr: []string{`duplicate name "a"`, "address", "transport must be plain, json or batch", `invalid name "b/c"`, "retry settings must not be negative"},
+		},
+		{
+			name:    "Invalid exec commands",
+			args:    []string{"-c", badExecFile},
+			wantErr: []string{`duplicate command "q"`, `command "q" is empty`, "command 2: name is required", "timeout must not be negative"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lookupEnv := func(name string) (string, bool) {
+				value, ok := tt.env[name]
+				return value, ok
+			}
+
+			cfg, err := ParseAgentConfig(tt.args, lookupEnv)
+			if len(tt.wantErr) > 0 {
+				if err == nil {
+					t.Fatalf("ParseAgentConfig() error = nil, want errors about %v", tt.wantErr)
+				}
+				for _, want := range tt.wantErr {
+					if !strings.Contains(err.Error(), want) {
+						t.Errorf("ParseAgentConfig() error = %q, want mention of %q", err, want)
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseAgentConfig() error = %v", err)
+			}
+			tt.check(t, cfg)
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// длительность, принимающая строку Go ("2s", "1m30s") или целое число секунд
+type Duration time.Duration
+
+// разбирает "2s" или "10" (секунды)
+func ParseDuration(s string) (time.Duration, error) {
+	if sec, err := strconv.Atoi(s); err == nil {
+		return time.Duration(sec) * time.Second, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q: use seconds or Go duration like \"2s\"", s)
+	}
+	return d, nil
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var sec int64
+	if err := json.Unmarshal(data, &sec); err == nil {
+		*d = Duration(time.Duration(sec) * time.Second)
+		return nil
+	}
+
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("invalid duration %s: use seconds or Go duration like \"2s\"", data)
+	}
+	parsed, err := ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// значение флага с длительностью
+type durationFlag struct {
+	d *time.Duration
+}
+
+func (f durationFlag) String() string {
+	if f.d == nil {
+		return ""
+	}
+	return f.d.String()
+}
+
+func (f durationFlag) Set(s string) error {
+	d, err := ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*f.d = d
+	return nil
+}
@@ -1,17 +1,56 @@
 package config
 
 import (
+	"errors"
 	"flag"
+	"fmt"
+	"net/netip"
 	"os"
-	"strconv"
+	"reflect"
+	"strings"
 	"time"
 )
 
+// значение, которым заменяются секреты при печати конфигурации
+const redacted = "[REDACTED]"
+
+// Теги полей: name — имя в печати конфигурации,
+// secret — значение не печатается, reload — меняется без перезапуска (SIGHUP)
 type ServerConfig struct {
-	ServerURL       string
-	StoreInterval   time.Duration
-	FileStoragePath string
-	Restore         bool
+	ServerURL       string        `name:"address"`
+	StoreInterval   time.Duration `name:"store_interval" reload:"true"`
+	FileStoragePath string        `name:"file_storage_path"`
+	Restore         bool          `name:"restore"`
+	// JSON-файл с арендаторами, их токенами и квотами
+	TenantsFile string `name:"tenants_file"`
+	// JSON-файл с хэшами токенов доступа и их ролями
+	AuthFile string `name:"auth_file" reload:"true"`
+	// TLS: сертификат и ключ сервера, CA для проверки клиентов (mTLS)
+	TLSCertFile     string `name:"tls_cert"`
+	TLSKeyFile      string `name:"tls_key" secret:"true"`
+	TLSClientCAFile string `name:"tls_client_ca"`
+	// закрытый ключ RSA для расшифровки тел запросов агентов
+	CryptoKey string `name:"crypto_key" secret:"true"`
+	// доверенные подсети CIDR через запятую для эндпоинтов обновления
+	TrustedSubnet string `name:"trusted_subnet"`
+	// проверять адрес соединения вместо заголовка X-Real-IP
+	TrustedRemoteAddr bool `name:"trusted_remote_addr"`
+	// ограничение частоты обновлений на клиента (запросов/с, 0 — без ограничения)
+	RateLimit    float64 `name:"rate_limit" reload:"true"`
+	RateBurst    int     `name:"rate_burst" reload:"true"`
+	RateLimitKey string  `name:"rate_limit_key"`
+	// ограничения размера тела запроса до и после распаковки (байт)
+	MaxBodySize         int64 `name:"max_body_size"`
+	MaxDecompressedSize int64 `name:"max_decompressed_size"`
+	// уровень логирования zap: debug, info, warn, error
+	LogLevel string `name:"log_level" reload:"true"`
+	// интервал выгрузки собственных метрик в основное хранилище, 0 — не выгружаются
+	SelfMetricsInterval time.Duration `name:"self_metrics_interval"`
+	// JSON-файл с правилами агрегации и записи и интервал их вычисления
+	AggregationFile     string        `name:"aggregation_file" reload:"true"`
+	AggregationInterval time.Duration `name:"aggregation_interval"`
+	// файл, из которого прочитана конфигурация
+	ConfigFile string `name:"config"`
 }
 
 func DefaultServerConfig() *ServerConfig {
@@ -20,37 +59,362 @@ func DefaultServerConfig() *ServerConfig {
 		StoreInterval:   300 * time.Second,
 		FileStoragePath: "tmp/my-metrics.json",
 		Restore:         true,
+		RateBurst:       10,
+		RateLimitKey:    "ip",
+		// 1 MiB сжатых и 10 MiB распакованных данных
+		MaxBodySize:         1 << 20,
+		MaxDecompressedSize: 10 << 20,
+		LogLevel:            "info",
+		AggregationInterval: 10 * time.Second,
+	}
+}
+
+// формат JSON-файла конфигурации сервера; nil — не задано
+type serverFile struct {
+	Address             *string   `json:"address"`
+	StoreInterval       *Duration `json:"store_interval"`
+	FileStoragePath     *string   `json:"file_storage_path"`
+	Restore             *bool     `json:"restore"`
+	TenantsFile         *string   `json:"tenants_file"`
+	AuthFile            *string   `json:"auth_file"`
+	TLSCert             *string   `json:"tls_cert"`
+	TLSKey              *string   `json:"tls_key"`
+	TLSClientCA         *string   `json:"tls_client_ca"`
+	CryptoKey           *string   `json:"crypto_key"`
+	TrustedSubnet       *string   `json:"trusted_subnet"`
+	TrustedRemoteAddr   *bool     `json:"trusted_remote_addr"`
+	RateLimit           *float64  `json:"rate_limit"`
+	RateBurst           *int      `json:"rate_burst"`
+	RateLimitKey        *string   `json:"rate_limit_key"`
+	MaxBodySize         *int64    `json:"max_body_size"`
+	MaxDecompressedSize *int64    `json:"max_decompressed_size"`
+	LogLevel            *string   `json:"log_level"`
+	SelfMetricsInterval *Duration `json:"self_metrics_interval"`
+	AggregationFile     *string   `json:"aggregation_file"`
+	AggregationInterval *Duration `json:"aggregation_interval"`
+}
+
+func (f *serverFile) apply(cfg *ServerConfig) {
+	setString(&cfg.ServerURL, f.Address)
+	if f.StoreInterval != nil {
+		cfg.StoreInterval = time.Duration(*f.StoreInterval)
+	}
+	setString(&cfg.FileStoragePath, f.FileStoragePath)
+	setValue(&cfg.Restore, f.Restore)
+	setString(&cfg.TenantsFile, f.TenantsFile)
+	setString(&cfg.AuthFile, f.AuthFile)
+	setString(&cfg.TLSCertFile, f.TLSCert)
+	setString(&cfg.TLSKeyFile, f.TLSKey)
+	setString(&cfg.TLSClientCAFile, f.TLSClientCA)
+	setString(&cfg.CryptoKey, f.CryptoKey)
+	setString(&cfg.TrustedSubnet, f.TrustedSubnet)
+	setValue(&cfg.TrustedRemoteAddr, f.TrustedRemoteAddr)
+	setValue(&cfg.RateLimit, f.RateLimit)
+	setValue(&cfg.RateBurst, f.RateBurst)
+	setString(&cfg.RateLimitKey, f.RateLimitKey)
+	setValue(&cfg.MaxBodySize, f.MaxBodySize)
+	setValue(&cfg.MaxDecompressedSize, f.MaxDecompressedSize)
+	setString(&cfg.LogLevel, f.LogLevel)
+	if f.SelfMetricsInterval != nil {
+		cfg.SelfMetricsInterval = time.Duration(*f.SelfMetricsInterval)
+	}
+	setString(&cfg.AggregationFile, f.AggregationFile)
+	if f.AggregationInterval != nil {
+		cfg.AggregationInterval = time.Duration(*f.AggregationInterval)
 	}
 }
 
-func ParseServerConfig() *ServerConfig {
+// Конфигурация сервера: файл (-c/CONFIG) < флаги < переменные окружения.
+// Ошибки во всех источниках собираются и возвращаются вместе.
+func ParseServerConfig(args []string, lookupEnv func(string) (string, bool)) (*ServerConfig, error) {
 	cfg := DefaultServerConfig()
 
-	// Флаги командной строки
-	var storeIntervalSec int
-	flag.StringVar(&cfg.ServerURL, "a", cfg.ServerURL, "Server address host:port")
-	flag.IntVar(&storeIntervalSec, "i", int(cfg.StoreInterval.Seconds()), "Store interval in seconds")
-	flag.StringVar(&cfg.FileStoragePath, "f", cfg.FileStoragePath, "File storage path")
-	flag.BoolVar(&cfg.Restore, "r", cfg.Restore, "Restore from file")
-	flag.Parse()
+	// флаги разбираем в отдельную копию, чтобы применить их поверх файла
+	flagged := DefaultServerConfig()
 
-	// Переменные окружения
-	if envAddr := os.Getenv("ADDRESS"); envAddr != "" {
-		cfg.ServerURL = envAddr
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	fs.StringVar(&flagged.ConfigFile, "c", "", "Config file (JSON)")
+	fs.StringVar(&flagged.ServerURL, "a", flagged.ServerURL, "Server address host:port")
+	fs.Var(durationFlag{&flagged.StoreInterval}, "i", "Store interval (seconds or duration like 5m)")
+	fs.StringVar(&flagged.FileStoragePath, "f", flagged.FileStoragePath, "File storage path")
+	fs.BoolVar(&flagged.Restore, "r", flagged.Restore, "Restore from file")
+	fs.StringVar(&flagged.TenantsFile, "tenants", flagged.TenantsFile, "Tenants config file (JSON)")
+	fs.StringVar(&flagged.AuthFile, "auth", flagged.AuthFile, "Auth tokens config file (JSON)")
+	fs.StringVar(&flagged.TLSCertFile, "tls-cert", flagged.TLSCertFile, "TLS certificate file (PEM)")
+	fs.StringVar(&flagged.TLSKeyFile, "tls-key", flagged.TLSKeyFile, "TLS private key file (PEM)")
+	fs.StringVar(&flagged.TrustedSubnet, "t", flagged.TrustedSubnet, "Trusted subnets for updates (CIDR, comma separated)")
+	fs.BoolVar(&flagged.TrustedRemoteAddr, "trusted-remote-addr", flagged.TrustedRemoteAddr, "Check connection address instead of X-Real-IP")
+	fs.Float64Var(&flagged.RateLimit, "rate-limit", flagged.RateLimit, "Max update requests per second per client (0 disables)")
+	fs.IntVar(&flagged.RateBurst, "rate-burst", flagged.RateBurst, "Rate limit burst size")
+	fs.StringVar(&flagged.RateLimitKey, "rate-limit-key", flagged.RateLimitKey, "Client identity for rate limiting: ip, token or tenant")
+	fs.Int64Var(&flagged.MaxBodySize, "max-body", flagged.MaxBodySize, "Max request body size in bytes")
+	fs.Int64Var(&flagged.MaxDecompressedSize, "max-decompressed", flagged.MaxDecompressedSize, "Max decompressed request body size in bytes")
+	fs.StringVar(&flagged.LogLevel, "log-level", flagged.LogLevel, "Log level: debug, info, warn or error")
+	fs.StringVar(&flagged.CryptoKey, "crypto-key", flagged.CryptoKey, "RSA private key file to decrypt agent payloads (PEM)")
+	fs.StringVar(&flagged.TLSClientCAFile, "tls-client-ca", flagged.TLSClientCAFile, "CA bundle to verify client certificates (enables mTLS)")
+	fs.Var(durationFlag{&flagged.SelfMetricsInterval}, "self-metrics-interval", "Interval to copy server metrics into the store (0 disables)")
+	fs.StringVar(&flagged.AggregationFile, "aggregation", flagged.AggregationFile, "Aggregation and recording rules file (JSON)")
+	fs.Var(durationFlag{&flagged.AggregationInterval}, "aggregation-interval", "Interval to evaluate aggregation rules")
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unknown arguments: %v", fs.Args())
+	}
+
+	var errs []error
+
+	// файл конфигурации
+	cfg.ConfigFile = flagged.ConfigFile
+	if value, ok := lookupEnv("CONFIG"); ok && value != "" {
+		cfg.ConfigFile = value
+	}
+	if cfg.ConfigFile != "" {
+		var file serverFile
+		if err := loadJSONFile(cfg.ConfigFile, &file); err != nil {
+			errs = append(errs, err)
+		} else {
+			file.apply(cfg)
+		}
 	}
-	if envInterval := os.Getenv("STORE_INTERVAL"); envInterval != "" {
-		if sec, err := strconv.Atoi(envInterval); err == nil {
-			cfg.StoreInterval = time.Duration(sec) * time.Second
+
+	// флаги
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "a":
+			cfg.ServerURL = flagged.ServerURL
+		case "i":
+			cfg.StoreInterval = flagged.StoreInterval
+		case "f":
+			cfg.FileStoragePath = flagged.FileStoragePath
+		case "r":
+			cfg.Restore = flagged.Restore
+		case "tenants":
+			cfg.TenantsFile = flagged.TenantsFile
+		case "auth":
+			cfg.AuthFile = flagged.AuthFile
+		case "tls-cert":
+			cfg.TLSCertFile = flagged.TLSCertFile
+		case "tls-key":
+			cfg.TLSKeyFile = flagged.TLSKeyFile
+		case "tls-client-ca":
+			cfg.TLSClientCAFile = flagged.TLSClientCAFile
+		case "crypto-key":
+			cfg.CryptoKey = flagged.CryptoKey
+		case "t":
+			cfg.TrustedSubnet = flagged.TrustedSubnet
+		case "trusted-remote-addr":
+			cfg.TrustedRemoteAddr = flagged.TrustedRemoteAddr
+		case "rate-limit":
+			cfg.RateLimit = flagged.RateLimit
+		case "rate-burst":
+			cfg.RateBurst = flagged.RateBurst
+		case "rate-limit-key":
+			cfg.RateLimitKey = flagged.RateLimitKey
+		case "max-body":
+			cfg.MaxBodySize = flagged.MaxBodySize
+		case "max-decompressed":
+			cfg.MaxDecompressedSize = flagged.MaxDecompressedSize
+		case "log-level":
+			cfg.LogLevel = flagged.LogLevel
+		case "self-metrics-interval":
+			cfg.SelfMetricsInterval = flagged.SelfMetricsInterval
+		case "aggregation":
+			cfg.AggregationFile = flagged.AggregationFile
+		case "aggregation-interval":
+			cfg.AggregationInterval = flagged.AggregationInterval
 		}
+	})
+
+	// переменные окружения
+	env := envReader{lookup: lookupEnv}
+	env.string("ADDRESS", &cfg.ServerURL)
+	env.duration("STORE_INTERVAL", &cfg.StoreInterval)
+	env.string("FILE_STORAGE_PATH", &cfg.FileStoragePath)
+	env.bool("RESTORE", &cfg.Restore)
+	env.string("TENANTS_FILE", &cfg.TenantsFile)
+	env.string("AUTH_FILE", &cfg.AuthFile)
+	env.string("TLS_CERT", &cfg.TLSCertFile)
+	env.string("TLS_KEY", &cfg.TLSKeyFile)
+	env.string("TLS_CLIENT_CA", &cfg.TLSClientCAFile)
+	env.string("CRYPTO_KEY", &cfg.CryptoKey)
+	env.string("TRUSTED_SUBNET", &cfg.TrustedSubnet)
+	env.bool("TRUSTED_REMOTE_ADDR", &cfg.TrustedRemoteAddr)
+	env.float64("RATE_LIMIT", &cfg.RateLimit)
+	env.int("RATE_BURST", &cfg.RateBurst)
+	env.string("RATE_LIMIT_KEY", &cfg.RateLimitKey)
+	env.int64("MAX_BODY_SIZE", &cfg.MaxBodySize)
+	env.int64("MAX_DECOMPRESSED_SIZE", &cfg.MaxDecompressedSize)
+	env.string("LOG_LEVEL", &cfg.LogLevel)
+	env.duration("SELF_METRICS_INTERVAL", &cfg.SelfMetricsInterval)
+	env.string("AGGREGATION_FILE", &cfg.AggregationFile)
+	env.duration("AGGREGATION_INTERVAL", &cfg.AggregationInterval)
+	errs = append(errs, env.errs...)
+
+	errs = append(errs, cfg.Validate()...)
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return cfg, nil
+}
+
+// проверяет конфигурацию и возвращает все найденные ошибки
+func (c *ServerConfig) Validate() []error {
+	var errs []error
+
+	if err := validateAddress(c.ServerURL); err != nil {
+		errs = append(errs, fmt.Errorf("address: %w", err))
+	}
+	if c.StoreInterval < 0 {
+		errs = append(errs, fmt.Errorf("store interval must not be negative, got %v", c.StoreInterval))
+	}
+	if c.SelfMetricsInterval < 0 {
+		errs = append(errs, fmt.Errorf("self metrics interval must not be negative, got %v", c.SelfMetricsInterval))
 	}
-	if envPath := os.Getenv("FILE_STORAGE_PATH"); envPath != "" {
-		cfg.FileStoragePath = envPath
+	if c.AggregationInterval <= 0 {
+		errs = append(errs, fmt.Errorf("aggregation interval must be positive, got %v", c.AggregationInterval))
 	}
-	if envRestore := os.Getenv("RESTORE"); envRestore != "" {
-		if restore, err := strconv.ParseBool(envRestore); err == nil {
-			cfg.Restore = restore
+	if c.FileStoragePath == "" {
+		errs = append(errs, errors.New("file storage path must not be empty"))
+	} else if info, err := os.Stat(c.FileStoragePath); err == nil && info.IsDir() {
+		errs = append(errs, fmt.Errorf("file storage path %s is a directory", c.FileStoragePath))
+	}
+
+	// файлы, которые сервер только читает, должны существовать
+	inputs := []struct{ name, path string }{
+		{"tenants file", c.TenantsFile},
+		{"auth file", c.AuthFile},
+		{"aggregation file", c.AggregationFile},
+		{"tls cert", c.TLSCertFile},
+		{"tls key", c.TLSKeyFile},
+		{"tls client ca", c.TLSClientCAFile},
+		{"crypto key", c.CryptoKey},
+	}
+	for _, in := range inputs {
+		if in.path == "" {
+			continue
+		}
+		if info, err := os.Stat(in.path); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", in.name, err))
+		} else if info.IsDir() {
+			errs = append(errs, fmt.Errorf("%s: %s is a directory", in.name, in.path))
 		}
 	}
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		errs = append(errs, errors.New("tls cert and tls key must be set together"))
+	}
+	if c.TLSClientCAFile != "" && c.TLSCertFile == "" {
+		errs = append(errs, errors.New("tls client ca requires tls cert and key"))
+	}
 
-	return cfg
+	for _, part := range strings.Split(c.TrustedSubnet, ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		if _, err := netip.ParsePrefix(part); err != nil {
+			errs = append(errs, fmt.Errorf("trusted subnet: %w", err))
+		}
+	}
+
+	if c.RateLimit < 0 {
+		errs = append(errs, fmt.Errorf("rate limit must not be negative, got %v", c.RateLimit))
+	}
+	if c.RateLimit > 0 && c.RateBurst < 1 {
+		errs = append(errs, fmt.Errorf("rate burst must be at least 1, got %d", c.RateBurst))
+	}
+	switch c.RateLimitKey {
+	case "ip", "token", "tenant":
+	default:
+		errs = append(errs, fmt.Errorf("rate limit key must be ip, token or tenant, got %q", c.RateLimitKey))
+	}
+	if c.MaxBodySize <= 0 {
+		errs = append(errs, fmt.Errorf("max body size must be positive, got %d", c.MaxBodySize))
+	}
+	if c.MaxDecompressedSize <= 0 {
+		errs = append(errs, fmt.Errorf("max decompressed size must be positive, got %d", c.MaxDecompressedSize))
+	}
+	switch c.LogLevel {
+	case "debug", "info", "warn", "error":
+	default:
+		errs = append(errs, fmt.Errorf("log level must be debug, info, warn or error, got %q", c.LogLevel))
+	}
+
+	return errs
+}
+
+// значение поля для печати, секреты скрыты
+func formatField(field reflect.StructField, value reflect.Value) string {
+	switch {
+	case field.Tag.Get("secret") == "true" && !value.IsZero():
+		return redacted
+	case field.Type == reflect.TypeOf(time.Duration(0)):
+		return time.Duration(value.Int()).String()
+	default:
+		return fmt.Sprint(value.Interface())
+	}
+}
+
+// итоговая конфигурация одной строкой, секреты скрыты
+func (c *ServerConfig) String() string {
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+
+	parts := make([]string, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if name := field.Tag.Get("name"); name != "" {
+			parts = append(parts, name+"="+formatField(field, v.Field(i)))
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// параметры по именам для выдачи администратору, секреты скрыты
+func (c *ServerConfig) Redacted() map[string]string {
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+
+	res := make(map[string]string, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if name := field.Tag.Get("name"); name != "" {
+			res[name] = formatField(field, v.Field(i))
+		}
+	}
+	return res
+}
+
+// изменение одного параметра конфигурации
+type Change struct {
+	Name   string
+	Old    string
+	New    string
+	Reload bool
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("%s: %s -> %s", c.Name, c.Old, c.New)
+}
+
+// различия между двумя конфигурациями
+func (c *ServerConfig) Diff(other *ServerConfig) []Change {
+	oldV, newV := reflect.ValueOf(c).Elem(), reflect.ValueOf(other).Elem()
+	t := oldV.Type()
+
+	var changes []Change
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := field.Tag.Get("name")
+		if name == "" || reflect.DeepEqual(oldV.Field(i).Interface(), newV.Field(i).Interface()) {
+			continue
+		}
+		changes = append(changes, Change{
+			Name:   name,
+			Old:    formatField(field, oldV.Field(i)),
+			New:    formatField(field, newV.Field(i)),
+			Reload: field.Tag.Get("reload") == "true",
+		})
+	}
+	return changes
 }
@@ -0,0 +1,99 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseServerConfig(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "private.pem")
+	os.WriteFile(keyFile, []byte("key"), 0600)
+	configFile := filepath.Join(dir, "server.json")
+	os.WriteFile(configFile, []byte(`{
+		"address": ":9090",
+		"store_interval": "1m",
+		"restore": false,
+		"crypto_key": "`+keyFile+`"
+	}`), 0644)
+
+	tests := []struct {
+		name    string
+		args    []string
+		env     map[string]string
+		check   func(t *testing.T, cfg *ServerConfig)
+		wantErr []string
+	}{
+		{
+			name: "Defaults",
+			check: func(t *testing.T, cfg *ServerConfig) {
+				if cfg.ServerURL != "localhost:8080" || cfg.StoreInterval != 300*time.Second || !cfg.Restore ||
+					cfg.AggregationInterval != 10*time.Second {
+					t.Errorf("got %s", cfg)
+				}
+			},
+		},
+		{
+			name: "File, flags and env are merged",
+			args: []string{"-c", configFile, "-a", ":7070", "-i", "5"},
+			env:  map[string]string{"ADDRESS": ":6060"},
+			check: func(t *testing.T, cfg *ServerConfig) {
+				if cfg.ServerURL != ":6060" {
+					t.Errorf("ServerURL = %s, want :6060 from env", cfg.ServerURL)
+				}
+				if cfg.StoreInterval != 5*time.Second {
+					t.Errorf("StoreInterval = %v, want 5s from flag", cfg.StoreInterval)
+				}
+				if cfg.Restore || cfg.CryptoKey != keyFile {
+					t.Errorf("Restore = %v, CryptoKey = %s, want values from file", cfg.Restore, cfg.CryptoKey)
+				}
+			},
+		},
+		{
+			name: "Secrets are redacted",
+			args: []string{"-crypto-key", keyFile},
+			check: func(t *testing.T, cfg *ServerConfig) {
+				if out := cfg.String(); strings.Contains(out, keyFile) || !strings.Contains(out, "crypto_key="+redacted) {
+					t.Errorf("String() = %s, want crypto key redacted", out)
+				}
+				if fields := cfg.Redacted(); fields["crypto_key"] != redacted || fields["address"] != cfg.ServerURL {
+					t.Errorf("Redacted() = %v, want crypto key redacted", fields)
+				}
+			},
+		},
+		{
+			name:    "All errors reported at once",
+			args:    []string{"-t", "10.0.0.0/33", "-tls-cert", filepath.Join(dir, "missing.pem"), "-aggregation-interval", "0"},
+			env:     map[string]string{"STORE_INTERVAL": "often", "RESTORE": "maybe", "ADDRESS": "localhost"},
+			wantErr: []string{"STORE_INTERVAL", "RESTORE", "address", "trusted subnet", "tls cert", "tls key", "aggregation interval"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lookupEnv := func(name string) (string, bool) {
+				value, ok := tt.env[name]
+				return value, ok
+			}
+
+			cfg, err := ParseServerConfig(tt.args, lookupEnv)
+			if len(tt.wantErr) > 0 {
+				if err == nil {
+					t.Fatalf("ParseServerConfig() error = nil, want errors about %v", tt.wantErr)
+				}
+				for _, want := range tt.wantErr {
+					if !strings.Contains(err.Error(), want) {
+						t.Errorf("ParseServerConfig() error = %q, want mention of %q", err, want)
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseServerConfig() error = %v", err)
+			}
+			tt.check(t, cfg)
+		})
+	}
+}
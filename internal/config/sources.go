@@ -0,0 +1,138 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func setString(dst *string, src *string) {
+	if src != nil {
+		*dst = *src
+	}
+}
+
+func setValue[T any](dst *T, src *T) {
+	if src != nil {
+		*dst = *src
+	}
+}
+
+// читает JSON-файл; неизвестные поля считаются ошибкой
+func loadJSONFile(path string, v any) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config file: %w", err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// адрес host:port или URL со схемой http/https
+func validateAddress(addr string) error {
+	if addr == "" {
+		return errors.New("must not be empty")
+	}
+	hostPort := addr
+	if strings.Contains(addr, "://") {
+		u, err := url.Parse(addr)
+		if err != nil {
+			return err
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return fmt.Errorf("unsupported scheme %q", u.Scheme)
+		}
+		hostPort = u.Host
+	}
+	_, port, err := net.SplitHostPort(hostPort)
+	if err != nil {
+		return err
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("invalid port %q", port)
+	}
+	return nil
+}
+
+// читает переменные окружения, накапливая ошибки разбора
+type envReader struct {
+	lookup func(string) (string, bool)
+	errs   []error
+}
+
+func (e *envReader) get(name string) (string, bool) {
+	value, ok := e.lookup(name)
+	return value, ok && value != ""
+}
+
+func (e *envReader) string(name string, dst *string) {
+	if value, ok := e.get(name); ok {
+		*dst = value
+	}
+}
+
+func (e *envReader) duration(name string, dst *time.Duration) {
+	if value, ok := e.get(name); ok {
+		d, err := ParseDuration(value)
+		if err != nil {
+			e.errs = append(e.errs, fmt.Errorf("%s: %w", name, err))
+			return
+		}
+		*dst = d
+	}
+}
+
+func (e *envReader) int64(name string, dst *int64) {
+	if value, ok := e.get(name); ok {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			e.errs = append(e.errs, fmt.Errorf("%s: invalid integer %q", name, value))
+			return
+		}
+		*dst = n
+	}
+}
+
+func (e *envReader) int(name string, dst *int) {
+	if value, ok := e.get(name); ok {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			e.errs = append(e.errs, fmt.Errorf("%s: invalid integer %q", name, value))
+			return
+		}
+		*dst = n
+	}
+}
+
+func (e *envReader) float64(name string, dst *float64) {
+	if value, ok := e.get(name); ok {
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			e.errs = append(e.errs, fmt.Errorf("%s: invalid number %q", name, value))
+			return
+		}
+		*dst = f
+	}
+}
+
+func (e *envReader) bool(name string, dst *bool) {
+	if value, ok := e.get(name); ok {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			e.errs = append(e.errs, fmt.Errorf("%s: invalid boolean %q", name, value))
+			return
+		}
+		*dst = b
+	}
+}
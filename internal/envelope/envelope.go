@@ -0,0 +1,172 @@
+package envelope
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Заголовки зашифрованного запроса: схема шифрования и отпечаток
+// открытого ключа, которым обёрнут симметричный ключ
+const (
+	Header      = "X-Encryption"
+	KeyIDHeader = "X-Encryption-Key-Id"
+	Scheme      = "rsa-oaep-sha256+aes-256-gcm"
+)
+
+var (
+	ErrKeyMismatch = errors.New("payload is encrypted for a different key")
+	ErrMalformed   = errors.New("malformed encrypted payload")
+)
+
+// Формат тела: RSA-OAEP(ключ AES) || nonce || AES-GCM(данные),
+// длина обёрнутого ключа равна размеру модуля RSA
+
+// читает открытый ключ RSA из PEM (PKIX, PKCS#1 или сертификат)
+func LoadPublicKey(path string) (*rsa.PublicKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch block.Type {
+	case "PUBLIC KEY":
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid public key in %s: %w", path, err)
+		}
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("public key in %s is not RSA", path)
+		}
+		return rsaKey, nil
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid certificate in %s: %w", path, err)
+		}
+		rsaKey, ok := cert.PublicKey.(*rsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("certificate key in %s is not RSA", path)
+		}
+		return rsaKey, nil
+	default:
+		return nil, fmt.Errorf("unexpected PEM block %q in %s", block.Type, path)
+	}
+}
+
+// читает закрытый ключ RSA из PEM (PKCS#1 или PKCS#8)
+func LoadPrivateKey(path string) (*rsa.PrivateKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid private key in %s: %w", path, err)
+		}
+		rsaKey, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("private key in %s is not RSA", path)
+		}
+		return rsaKey, nil
+	default:
+		return nil, fmt.Errorf("unexpected PEM block %q in %s", block.Type, path)
+	}
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data in %s", path)
+	}
+	return block, nil
+}
+
+// короткий отпечаток открытого ключа
+func KeyID(key *rsa.PublicKey) string {
+	sum := sha256.Sum256(x509.MarshalPKCS1PublicKey(key))
+	return hex.EncodeToString(sum[:8])
+}
+
+// шифрует данные случайным ключом AES-256-GCM, обёрнутым RSA-OAEP
+func Encrypt(key *rsa.PublicKey, plaintext []byte) ([]byte, error) {
+	aesKey := make([]byte, 32)
+	if _, err := rand.Read(aesKey); err != nil {
+		return nil, err
+	}
+
+	wrapped, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, key, aesKey, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to wrap key: %w", err)
+	}
+
+	gcm, err := newGCM(aesKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, len(wrapped)+len(nonce)+len(plaintext)+gcm.Overhead())
+	out = append(out, wrapped...)
+	out = append(out, nonce...)
+	return gcm.Seal(out, nonce, plaintext, nil), nil
+}
+
+// расшифровывает данные, зашифрованные Encrypt
+func Decrypt(key *rsa.PrivateKey, data []byte) ([]byte, error) {
+	keySize := key.Size()
+	if len(data) < keySize {
+		return nil, ErrMalformed
+	}
+
+	aesKey, err := rsa.DecryptOAEP(sha256.New(), nil, key, data[:keySize], nil)
+	if err != nil {
+		return nil, ErrKeyMismatch
+	}
+
+	gcm, err := newGCM(aesKey)
+	if err != nil {
+		return nil, err
+	}
+	rest := data[keySize:]
+	if len(rest) < gcm.NonceSize()+gcm.Overhead() {
+		return nil, ErrMalformed
+	}
+
+	plaintext, err := gcm.Open(nil, rest[:gcm.NonceSize()], rest[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	return plaintext, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,55 @@
+package envelope
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"testing"
+)
+
+func TestEncryptDecrypt(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plaintext := []byte(`{"id":"PollCount","type":"counter","delta":1}`)
+	encrypted, err := Encrypt(&key.PublicKey, plaintext)
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		key     *rsa.PrivateKey
+		data    []byte
+		wantErr error
+	}{
+		{name: "Matching key", key: key, data: encrypted},
+		{name: "Different key", key: otherKey, data: encrypted, wantErr: ErrKeyMismatch},
+		{name: "Truncated payload", key: key, data: encrypted[:key.Size()+4], wantErr: ErrMalformed},
+		{name: "Tampered ciphertext", key: key, data: append(bytes.Clone(encrypted[:len(encrypted)-1]), encrypted[len(encrypted)-1]^1), wantErr: ErrMalformed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Decrypt(tt.key, tt.data)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("Decrypt() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Decrypt() error = %v", err)
+			}
+			if !bytes.Equal(got, plaintext) {
+				t.Errorf("Decrypt() = %s, want %s", got, plaintext)
+			}
+		})
+	}
+}
@@ -0,0 +1,279 @@
+package expr
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/shatrunoff/yap_metrics/internal/model"
+)
+
+// хранилище, из которого выражение берёт значения
+type Source interface {
+	GetAll() map[string]model.Metrics
+	GetRates() map[string]model.Rate
+}
+
+// значение одной серии; у числа метки пустые
+type Sample struct {
+	Labels map[string]string `json:"labels,omitempty"`
+	Value  float64           `json:"value"`
+}
+
+// Result — результат вычисления: число (одна серия без меток) или вектор серий,
+// упорядоченный по меткам. Серии с нечисловым результатом, например после
+// деления на ноль, в вектор не попадают.
+type Result struct {
+	Scalar  bool     `json:"scalar"`
+	Samples []Sample `json:"samples"`
+}
+
+// ErrNotFinite возвращается, если число получилось бесконечным или NaN
+var ErrNotFinite = errors.New("result is not a finite number")
+
+// Eval вычисляет выражение по текущему содержимому хранилища
+func (e *Expr) Eval(src Source) (Result, error) {
+	res, err := e.root.eval(src)
+	if err != nil {
+		return Result{}, err
+	}
+	if res.Scalar {
+		if v := res.Samples[0].Value; math.IsNaN(v) || math.IsInf(v, 0) {
+			return Result{}, ErrNotFinite
+		}
+		return res, nil
+	}
+
+	finite := make([]Sample, 0, len(res.Samples))
+	for _, s := range res.Samples {
+		if !math.IsNaN(s.Value) && !math.IsInf(s.Value, 0) {
+			finite = append(finite, s)
+		}
+	}
+	sort.Slice(finite, func(i, j int) bool {
+		return model.LabeledID("", finite[i].Labels) < model.LabeledID("", finite[j].Labels)
+	})
+	res.Samples = finite
+	return res, nil
+}
+
+func scalar(v float64) Result {
+	return Result{Scalar: true, Samples: []Sample{{Value: v}}}
+}
+
+// применяет f к каждой серии, сохраняя метки
+func (r Result) apply(f func(float64) float64) Result {
+	out := Result{Scalar: r.Scalar, Samples: make([]Sample, len(r.Samples))}
+	for i, s := range r.Samples {
+		out.Samples[i] = Sample{Labels: s.Labels, Value: f(s.Value)}
+	}
+	return out
+}
+
+type node interface {
+	eval(src Source) (Result, error)
+}
+
+type numberNode float64
+
+func (n numberNode) eval(Source) (Result, error) {
+	return scalar(float64(n)), nil
+}
+
+// серии метрики с указанным именем и метками
+type selectorNode struct {
+	name   string
+	labels map[string]string
+	// окно скорости, только внутри rate()
+	window time.Duration
+}
+
+func (s *selectorNode) match(id string) (map[string]string, bool) {
+	name, labels := model.ParseID(id)
+	if name != s.name {
+		return nil, false
+	}
+	for k, v := range s.labels {
+		if labels[k] != v {
+			return nil, false
+		}
+	}
+	return labels, true
+}
+
+func (s *selectorNode) eval(src Source) (Result, error) {
+	res := Result{Samples: []Sample{}}
+	for id, metric := range src.GetAll() {
+		labels, ok := s.match(id)
+		if !ok {
+			continue
+		}
+		switch {
+		case metric.MType == model.Gauge && metric.Value != nil:
+			res.Samples = append(res.Samples, Sample{Labels: labels, Value: *metric.Value})
+		case metric.MType == model.Counter && metric.Delta != nil:
+			res.Samples = append(res.Samples, Sample{Labels: labels, Value: float64(*metric.Delta)})
+		}
+	}
+	return res, nil
+}
+
+// скорость изменения счётчиков по окнам, которые считает хранилище
+var rateWindows = map[time.Duration]func(model.Rate) float64{
+	time.Minute:      func(r model.Rate) float64 { return r.Rate1m },
+	5 * time.Minute:  func(r model.Rate) float64 { return r.Rate5m },
+	15 * time.Minute: func(r model.Rate) float64 { return r.Rate15m },
+}
+
+type rateNode struct {
+	sel *selectorNode
+}
+
+func (n *rateNode) eval(src Source) (Result, error) {
+	value := rateWindows[n.sel.window]
+	res := Result{Samples: []Sample{}}
+	for id, rate := range src.GetRates() {
+		if labels, ok := n.sel.match(id); ok {
+			res.Samples = append(res.Samples, Sample{Labels: labels, Value: value(rate)})
+		}
+	}
+	return res, nil
+}
+
+type binaryNode struct {
+	op       byte
+	lhs, rhs node
+}
+
+func (n *binaryNode) calc(a, b float64) float64 {
+	switch n.op {
+	case '+':
+		return a + b
+	case '-':
+		return a - b
+	case '*':
+		return a * b
+	default:
+		return a / b
+	}
+}
+
+func (n *binaryNode) eval(src Source) (Result, error) {
+	lhs, err := n.lhs.eval(src)
+	if err != nil {
+		return Result{}, err
+	}
+	rhs, err := n.rhs.eval(src)
+	if err != nil {
+		return Result{}, err
+	}
+
+	switch {
+	case rhs.Scalar:
+		b := rhs.Samples[0].Value
+		return lhs.apply(func(a float64) float64 { return n.calc(a, b) }), nil
+	case lhs.Scalar:
+		a := lhs.Samples[0].Value
+		return rhs.apply(func(b float64) float64 { return n.calc(a, b) }), nil
+	}
+
+	// два вектора: в результат попадают серии с одинаковыми метками с обеих сторон
+	index := make(map[string]float64, len(rhs.Samples))
+	for _, s := range rhs.Samples {
+		index[model.LabeledID("", s.Labels)] = s.Value
+	}
+	res := Result{Samples: []Sample{}}
+	for _, s := range lhs.Samples {
+		if b, ok := index[model.LabeledID("", s.Labels)]; ok {
+			res.Samples = append(res.Samples, Sample{Labels: s.Labels, Value: n.calc(s.Value, b)})
+		}
+	}
+	return res, nil
+}
+
+// функция языка; args — число аргументов
+type function struct {
+	args int
+	call func(args []Result) (Result, error)
+}
+
+// свёртка вектора в число; пустой вектор даёт пустой результат
+func reduce(f func(values []float64) float64) function {
+	return function{args: 1, call: func(args []Result) (Result, error) {
+		if len(args[0].Samples) == 0 {
+			return Result{Samples: []Sample{}}, nil
+		}
+		values := make([]float64, len(args[0].Samples))
+		for i, s := range args[0].Samples {
+			values[i] = s.Value
+		}
+		return scalar(f(values)), nil
+	}}
+}
+
+var functions = map[string]function{
+	// обрабатывается парсером отдельно, здесь — только число аргументов
+	"rate": {args: 1},
+	"abs": {args: 1, call: func(args []Result) (Result, error) {
+		return args[0].apply(math.Abs), nil
+	}},
+	"clamp": {args: 3, call: func(args []Result) (Result, error) {
+		if !args[1].Scalar || !args[2].Scalar {
+			return Result{}, errors.New("clamp() bounds must be numbers")
+		}
+		lo, hi := args[1].Samples[0].Value, args[2].Samples[0].Value
+		if lo > hi {
+			return Result{}, fmt.Errorf("clamp() lower bound %v is greater than upper bound %v", lo, hi)
+		}
+		return args[0].apply(func(v float64) float64 { return math.Max(lo, math.Min(hi, v)) }), nil
+	}},
+	"sum": reduce(func(values []float64) float64 {
+		total := 0.0
+		for _, v := range values {
+			total += v
+		}
+		return total
+	}),
+	"avg": reduce(func(values []float64) float64 {
+		total := 0.0
+		for _, v := range values {
+			total += v
+		}
+		return total / float64(len(values))
+	}),
+	"min": reduce(func(values []float64) float64 {
+		res := values[0]
+		for _, v := range values[1:] {
+			res = math.Min(res, v)
+		}
+		return res
+	}),
+	"max": reduce(func(values []float64) float64 {
+		res := values[0]
+		for _, v := range values[1:] {
+			res = math.Max(res, v)
+		}
+		return res
+	}),
+	"count": reduce(func(values []float64) float64 {
+		return float64(len(values))
+	}),
+}
+
+type callNode struct {
+	fn   function
+	args []node
+}
+
+func (n *callNode) eval(src Source) (Result, error) {
+	args := make([]Result, len(n.args))
+	for i, arg := range n.args {
+		var err error
+		if args[i], err = arg.eval(src); err != nil {
+			return Result{}, err
+		}
+	}
+	return n.fn.call(args)
+}
@@ -0,0 +1,132 @@
+package expr
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/shatrunoff/yap_metrics/internal/model"
+)
+
+type source struct {
+	metrics map[string]model.Metrics
+	rates   map[string]model.Rate
+}
+
+func (s source) GetAll() map[string]model.Metrics { return s.metrics }
+func (s source) GetRates() map[string]model.Rate  { return s.rates }
+
+func testSource() source {
+	src := source{metrics: make(map[string]model.Metrics), rates: make(map[string]model.Rate)}
+	gauge := func(id string, v float64) {
+		src.metrics[id] = model.Metrics{ID: id, MType: model.Gauge, Value: &v}
+	}
+	gauge(`HeapInuse{host="a"}`, 50)
+	gauge(`HeapInuse{host="b"}`, 30)
+	gauge(`HeapSys{host="a"}`, 200)
+	gauge(`HeapSys{host="b"}`, 0)
+	gauge("Temperature", -12.5)
+	delta := int64(40)
+	src.metrics["PollCount"] = model.Metrics{ID: "PollCount", MType: model.Counter, Delta: &delta}
+	src.rates["PollCount"] = model.Rate{ID: "PollCount", Rate1m: 2, Rate5m: 1.5, Rate15m: 1}
+	return src
+}
+
+func vector(samples ...Sample) Result {
+	return Result{Samples: samples}
+}
+
+func host(h string, v float64) Sample {
+	return Sample{Labels: map[string]string{"host": h}, Value: v}
+}
+
+func TestEval(t *testing.T) {
+	tests := []struct {
+		name string
+		expr string
+		want Result
+	}{
+		{name: "Arithmetic precedence", expr: "2 + 3 * 4 - 10 / 5", want: scalar(12)},
+		{name: "Parentheses and unary minus", expr: "-(2 + 3) * 2", want: scalar(-10)},
+		{name: "Exponent", expr: "1.5e2", want: scalar(150)},
+		{name: "Vectors matched by labels", expr: "HeapInuse / HeapSys * 100", want: vector(host("a", 25))},
+		{name: "Label selector", expr: `HeapInuse{host="b"} + 1`, want: vector(host("b", 31))},
+		{name: "Counter value", expr: "PollCount", want: vector(Sample{Value: 40})},
+		{name: "Rate defaults to one minute", expr: "rate(PollCount)", want: vector(Sample{Value: 2})},
+		{name: "Rate window", expr: "rate(PollCount[15m]) * 60", want: vector(Sample{Value: 60})},
+		{name: "Abs", expr: "abs(Temperature)", want: vector(Sample{Value: 12.5})},
+		{name: "Clamp", expr: "clamp(HeapInuse, 35, 40)", want: vector(host("a", 40), host("b", 35))},
+		{name: "Aggregates", expr: "sum(HeapInuse) / count(HeapInuse) + max(HeapSys) - min(HeapSys) - avg(HeapInuse)", want: scalar(200)},
+		{name: "Unknown metric is empty", expr: "Missing * 2", want: vector()},
+		{name: "Aggregate of empty vector is empty", expr: "sum(Missing)", want: vector()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := Parse(tt.expr)
+			if err != nil {
+				t.Fatalf("Parse(%q) error = %v", tt.expr, err)
+			}
+			got, err := e.Eval(testSource())
+			if err != nil {
+				t.Fatalf("Eval(%q) error = %v", tt.expr, err)
+			}
+			if tt.want.Samples == nil {
+				tt.want.Samples = []Sample{}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Eval(%q) = %+v, want %+v", tt.expr, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		expr string
+	}{
+		{name: "Scalar division by zero", expr: "1 / 0"},
+		{name: "Clamp with vector bounds", expr: "clamp(HeapInuse, HeapSys, 100)"},
+		{name: "Clamp with inverted bounds", expr: "clamp(HeapInuse, 10, 1)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := Parse(tt.expr)
+			if err != nil {
+				t.Fatalf("Parse(%q) error = %v", tt.expr, err)
+			}
+			if _, err := e.Eval(testSource()); err == nil {
+				t.Errorf("Eval(%q) error = nil", tt.expr)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		expr    string
+		wantErr string
+	}{
+		{expr: "", wantErr: "unexpected end of expression"},
+		{expr: "1 +", wantErr: "unexpected end of expression"},
+		{expr: "(1 + 2", wantErr: `expected ")"`},
+		{expr: "1 2", wantErr: `unexpected "2" at position 2`},
+		{expr: "HeapAlloc % 2", wantErr: "unexpected character"},
+		{expr: "median(HeapAlloc)", wantErr: "unknown function"},
+		{expr: "abs(1, 2)", wantErr: "takes 1 arguments"},
+		{expr: `HeapAlloc{host=a}`, wantErr: "expected quoted label value"},
+		{expr: `HeapAlloc{host="a}`, wantErr: "unterminated string"},
+		{expr: "HeapAlloc[5m]", wantErr: "only allowed in rate()"},
+		{expr: "rate(PollCount[2m])", wantErr: "window must be 1m, 5m or 15m"},
+		{expr: "rate(PollCount * 2)", wantErr: "requires a metric selector"},
+		{expr: "abs(rate(PollCount)[5m])", wantErr: `expected ","`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			_, err := Parse(tt.expr)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Parse(%q) error = %v, want %q", tt.expr, err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,341 @@
+package expr
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+	"unicode"
+)
+
+// Язык выражений над метриками:
+//
+//	HeapInuse / HeapSys * 100
+//	sum(HeapAlloc{dc="eu"}) / count(HeapAlloc)
+//	clamp(rate(PollCount[5m]), 0, 100)
+//
+// Селектор — имя метрики и, при необходимости, точные значения меток; он
+// возвращает вектор серий. Операции между векторами сопоставляют серии
+// с одинаковыми метками, между вектором и числом — применяются к каждой серии.
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokNumber
+	tokDuration
+	tokIdent
+	tokString
+	tokPunct
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func (t token) String() string {
+	if t.kind == tokEOF {
+		return "end of expression"
+	}
+	return strconv.Quote(t.text)
+}
+
+func isIdentStart(r byte) bool {
+	return r == '_' || unicode.IsLetter(rune(r))
+}
+
+func isIdentPart(r byte) bool {
+	return isIdentStart(r) || unicode.IsDigit(rune(r)) || r == '.' || r == ':'
+}
+
+func isDigit(r byte) bool {
+	return r >= '0' && r <= '9'
+}
+
+// разбивает выражение на токены
+func tokenize(input string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(input); {
+		c := input[i]
+		start := i
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+			continue
+		case isDigit(c) || c == '.':
+			for i < len(input) && (isDigit(input[i]) || input[i] == '.') {
+				i++
+			}
+			// показатель степени: 1e3, 2.5E-2
+			if i < len(input) && (input[i] == 'e' || input[i] == 'E') {
+				j := i + 1
+				if j < len(input) && (input[j] == '+' || input[j] == '-') {
+					j++
+				}
+				if j < len(input) && isDigit(input[j]) {
+					for i = j; i < len(input) && isDigit(input[i]); i++ {
+					}
+				}
+			}
+			// число с единицами — длительность: 5m, 1h30m
+			if i < len(input) && unicode.IsLetter(rune(input[i])) {
+				for i < len(input) && (unicode.IsLetter(rune(input[i])) || isDigit(input[i])) {
+					i++
+				}
+				tokens = append(tokens, token{tokDuration, input[start:i], start})
+				continue
+			}
+			tokens = append(tokens, token{tokNumber, input[start:i], start})
+		case isIdentStart(c):
+			for i < len(input) && isIdentPart(input[i]) {
+				i++
+			}
+			tokens = append(tokens, token{tokIdent, input[start:i], start})
+		case c == '"':
+			quoted, err := strconv.QuotedPrefix(input[i:])
+			if err != nil {
+				return nil, fmt.Errorf("unterminated string at position %d", start)
+			}
+			value, _ := strconv.Unquote(quoted)
+			i += len(quoted)
+			tokens = append(tokens, token{tokString, value, start})
+		case c == '+' || c == '-' || c == '*' || c == '/' || c == '(' || c == ')' ||
+			c == '{' || c == '}' || c == '[' || c == ']' || c == ',' || c == '=':
+			i++
+			tokens = append(tokens, token{tokPunct, string(c), start})
+		default:
+			return nil, fmt.Errorf("unexpected character %q at position %d", c, start)
+		}
+	}
+	return append(tokens, token{kind: tokEOF, pos: len(input)}), nil
+}
+
+// Expr — разобранное выражение
+type Expr struct {
+	source string
+	root   node
+}
+
+// Parse разбирает выражение
+func Parse(input string) (*Expr, error) {
+	tokens, err := tokenize(input)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	root, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %s at position %d", t, t.pos)
+	}
+	return &Expr{source: input, root: root}, nil
+}
+
+func (e *Expr) String() string {
+	return e.source
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+	// разбираются аргументы rate(), где у селектора может быть окно
+	inRate bool
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+// пропускает знак, если он следующий
+func (p *parser) accept(punct string) bool {
+	if t := p.peek(); t.kind == tokPunct && t.text == punct {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(punct string) error {
+	if !p.accept(punct) {
+		t := p.peek()
+		return fmt.Errorf("expected %q, got %s at position %d", punct, t, t.pos)
+	}
+	return nil
+}
+
+// expr := term (('+' | '-') term)*
+func (p *parser) expr() (node, error) {
+	lhs, err := p.term()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		if t.kind != tokPunct || (t.text != "+" && t.text != "-") {
+			return lhs, nil
+		}
+		p.next()
+		rhs, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		lhs = &binaryNode{op: t.text[0], lhs: lhs, rhs: rhs}
+	}
+}
+
+// term := unary (('*' | '/') unary)*
+func (p *parser) term() (node, error) {
+	lhs, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		if t.kind != tokPunct || (t.text != "*" && t.text != "/") {
+			return lhs, nil
+		}
+		p.next()
+		rhs, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		lhs = &binaryNode{op: t.text[0], lhs: lhs, rhs: rhs}
+	}
+}
+
+// unary := '-' unary | primary
+func (p *parser) unary() (node, error) {
+	if p.accept("-") {
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &binaryNode{op: '*', lhs: numberNode(-1), rhs: operand}, nil
+	}
+	return p.primary()
+}
+
+// primary := number | '(' expr ')' | call | selector
+func (p *parser) primary() (node, error) {
+	t := p.next()
+	switch {
+	case t.kind == tokNumber:
+		value, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %s at position %d", t, t.pos)
+		}
+		return numberNode(value), nil
+	case t.kind == tokPunct && t.text == "(":
+		inner, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		return inner, p.expect(")")
+	case t.kind == tokIdent && p.accept("("):
+		return p.call(t)
+	case t.kind == tokIdent:
+		return p.selector(t)
+	default:
+		return nil, fmt.Errorf("unexpected %s at position %d", t, t.pos)
+	}
+}
+
+// selector := ident ['{' label '=' string (',' label '=' string)* '}'] ['[' duration ']']
+func (p *parser) selector(name token) (node, error) {
+	sel := &selectorNode{name: name.text}
+	if p.accept("{") {
+		sel.labels = make(map[string]string)
+		for !p.accept("}") {
+			if len(sel.labels) > 0 {
+				if err := p.expect(","); err != nil {
+					return nil, err
+				}
+			}
+			key := p.next()
+			if key.kind != tokIdent {
+				return nil, fmt.Errorf("expected label name, got %s at position %d", key, key.pos)
+			}
+			if err := p.expect("="); err != nil {
+				return nil, err
+			}
+			value := p.next()
+			if value.kind != tokString {
+				return nil, fmt.Errorf("expected quoted label value, got %s at position %d", value, value.pos)
+			}
+			sel.labels[key.text] = value.text
+		}
+	}
+
+	if t := p.peek(); t.kind == tokPunct && t.text == "[" && !p.inRate {
+		return nil, fmt.Errorf("range selector is only allowed in rate() at position %d", t.pos)
+	}
+	if p.accept("[") {
+		t := p.next()
+		window, err := time.ParseDuration(t.text)
+		if t.kind != tokDuration || err != nil {
+			return nil, fmt.Errorf("expected duration, got %s at position %d", t, t.pos)
+		}
+		if _, ok := rateWindows[window]; !ok {
+			return nil, fmt.Errorf("rate() window must be 1m, 5m or 15m, got %s at position %d", t, t.pos)
+		}
+		sel.window = window
+		if err := p.expect("]"); err != nil {
+			return nil, err
+		}
+	}
+	return sel, nil
+}
+
+// call := ident '(' [expr (',' expr)*] ')'
+func (p *parser) call(name token) (node, error) {
+	fn, ok := functions[name.text]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %s at position %d", name, name.pos)
+	}
+
+	inRate := p.inRate
+	p.inRate = name.text == "rate"
+	defer func() { p.inRate = inRate }()
+
+	var args []node
+	for !p.accept(")") {
+		if len(args) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		arg, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	if len(args) != fn.args {
+		return nil, fmt.Errorf("%s() takes %d arguments, got %d at position %d", name.text, fn.args, len(args), name.pos)
+	}
+
+	// rate() берёт скорость счётчиков из хранилища, поэтому нужен именно селектор
+	if name.text == "rate" {
+		sel, ok := args[0].(*selectorNode)
+		if !ok {
+			return nil, fmt.Errorf("rate() requires a metric selector at position %d", name.pos)
+		}
+		if sel.window == 0 {
+			sel.window = time.Minute
+		}
+		return &rateNode{sel: sel}, nil
+	}
+	return &callNode{fn: fn, args: args}, nil
+}
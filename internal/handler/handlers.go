@@ -1,17 +1,30 @@
 package handler
 
 import (
+	"crypto/rsa"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/netip"
 	"strconv"
 	"sync"
 	"text/template"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/shatrunoff/yap_metrics/internal/aggregate"
+	"github.com/shatrunoff/yap_metrics/internal/auth"
+	"github.com/shatrunoff/yap_metrics/internal/config"
+	"github.com/shatrunoff/yap_metrics/internal/expr"
 	"github.com/shatrunoff/yap_metrics/internal/middleware"
 	"github.com/shatrunoff/yap_metrics/internal/model"
+	"github.com/shatrunoff/yap_metrics/internal/prom"
+	"github.com/shatrunoff/yap_metrics/internal/ratelimit"
+	"github.com/shatrunoff/yap_metrics/internal/selfmetrics"
 	"github.com/shatrunoff/yap_metrics/internal/service"
+	"github.com/shatrunoff/yap_metrics/internal/storage"
+	"github.com/shatrunoff/yap_metrics/internal/tenant"
 	"go.uber.org/zap"
 )
 
@@ -44,25 +57,180 @@ func initTemplates() {
 }
 
 type Storage interface {
-	UpdateGauge(name string, value float64)
-	UpdateCounter(name string, delta int64)
+	Update(metrics []model.Metrics, limit int) error
 	GetMetric(metricType, name string) (model.Metrics, bool)
 	GetAll() map[string]model.Metrics
+	GetRate(name string) (model.Rate, bool)
+	GetRates() map[string]model.Rate
+	Delete(metricType, name string) bool
 }
 
 type Handler struct {
 	storage     Storage
+	tenants     *storage.TenantStorage
+	resolver    *tenant.Resolver
+	auth        *auth.Authenticator
+	cryptoKey   *rsa.PrivateKey
+	trusted     []netip.Prefix
+	trustRemote bool
+	limiter     *ratelimit.Limiter
+	limiterKey  func(r *http.Request) string
+	maxBody     int64
+	maxUnzipped int64
 	fileService *service.FileStorageService
-	syncSave    bool
+	metrics     *selfmetrics.Registry
+	aggregation *aggregate.Engine
+	config      func() *config.ServerConfig
 	logger      *zap.Logger
 	sugar       *zap.SugaredLogger
 }
 
+// дополнительная настройка хэндлера
+type Option func(h *Handler)
+
+// включает разделение метрик по арендаторам
+func WithTenants(tenants *storage.TenantStorage, resolver *tenant.Resolver) Option {
+	return func(h *Handler) {
+		h.tenants = tenants
+		h.resolver = resolver
+	}
+}
+
+// включает проверку токенов и ролей
+func WithAuth(authenticator *auth.Authenticator) Option {
+	return func(h *Handler) {
+		h.auth = authenticator
+	}
+}
+
+// включает расшифровку тел запросов закрытым ключом сервера
+func WithCryptoKey(key *rsa.PrivateKey) Option {
+	return func(h *Handler) {
+		h.cryptoKey = key
+	}
+}
+
+// разрешает обновление метрик только из доверенных подсетей;
+// useRemoteAddr — брать адрес соединения вместо X-Real-IP
+func WithTrustedSubnets(subnets []netip.Prefix, useRemoteAddr bool) Option {
+	return func(h *Handler) {
+		h.trusted = subnets
+		h.trustRemote = useRemoteAddr
+	}
+}
+
+// ограничивает частоту обновлений от каждого клиента
+func WithRateLimit(limiter *ratelimit.Limiter, key func(r *http.Request) string) Option {
+	return func(h *Handler) {
+		h.limiter = limiter
+		h.limiterKey = key
+	}
+}
+
+// ограничивает размер тела запроса до и после распаковки gzip
+func WithBodyLimits(maxBody, maxDecompressed int64) Option {
+	return func(h *Handler) {
+		h.maxBody = maxBody
+		h.maxUnzipped = maxDecompressed
+	}
+}
+
+// включает собственные метрики сервера: запросы, распаковка, операции с хранилищем
+func WithSelfMetrics(reg *selfmetrics.Registry) Option {
+	return func(h *Handler) {
+		h.metrics = reg
+	}
+}
+
+// включает выдачу правил агрегации и результатов их вычисления
+func WithAggregation(engine *aggregate.Engine) Option {
+	return func(h *Handler) {
+		h.aggregation = engine
+	}
+}
+
+// включает выдачу администратору действующей конфигурации сервера;
+// current вызывается на каждый запрос, чтобы учесть перечитывание по SIGHUP
+func WithConfig(current func() *config.ServerConfig) Option {
+	return func(h *Handler) {
+		h.config = current
+	}
+}
+
+// ответ на ошибку разбора JSON из тела запроса
+func (h *Handler) decodeError(w http.ResponseWriter, err error) {
+	if middleware.IsBodyTooLarge(err) {
+		http.Error(w, "ERROR: request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	h.logger.Error("Failed to decode JSON", zap.Error(err))
+	http.Error(w, "ERROR: invalid JSON", http.StatusBadRequest)
+}
+
+// сохранять ли метрики в файл при каждом обновлении
+func (h *Handler) syncSave() bool {
+	return h.fileService != nil && h.fileService.Synchronous()
+}
+
+// хранилище арендатора, от имени которого пришёл запрос
+func (h *Handler) storageFor(r *http.Request) Storage {
+	var st Storage = h.storage
+	if h.tenants != nil {
+		st = h.tenants.Tenant(tenant.FromContext(r.Context()))
+	}
+	if h.metrics != nil {
+		st = instrumentedStorage{Storage: st, reg: h.metrics}
+	}
+	return st
+}
+
+// метрики с префиксом сервера клиентам не изменить
+func (h *Handler) checkName(w http.ResponseWriter, name string) bool {
+	if selfmetrics.Reserved(name) {
+		http.Error(w, "ERROR: metric names starting with "+selfmetrics.Namespace+" are reserved", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// применяет проверенные обновления с учётом квот арендатора,
+// при превышении отвечает 429
+func (h *Handler) update(w http.ResponseWriter, r *http.Request, st Storage, metrics []model.Metrics) bool {
+	limit := 0
+	if h.tenants != nil {
+		id := tenant.FromContext(r.Context())
+		if err := h.tenants.CheckUpdate(id, len(metrics)); err != nil {
+			h.quotaError(w, err)
+			return false
+		}
+		limit = h.tenants.MaxMetrics(id)
+	}
+
+	if err := st.Update(metrics, limit); err != nil {
+		h.quotaError(w, err)
+		return false
+	}
+	return true
+}
+
+func (h *Handler) quotaError(w http.ResponseWriter, err error) {
+	if errors.Is(err, storage.ErrTooManyUpdates) {
+		middleware.TooManyRequests(w, "ERROR: "+err.Error(), time.Second)
+		return
+	}
+	if errors.Is(err, storage.ErrTooManyMetrics) {
+		http.Error(w, "ERROR: "+err.Error(), http.StatusTooManyRequests)
+		return
+	}
+	http.Error(w, "ERROR: "+err.Error(), http.StatusInternalServerError)
+}
+
 // хэндлер обновления метрики
 func (h *Handler) updateMetric(w http.ResponseWriter, r *http.Request) {
 	metricType := chi.URLParam(r, "type")
 	metricName := chi.URLParam(r, "name")
 	metricValue := chi.URLParam(r, "value")
+	metric := model.Metrics{ID: metricName, MType: metricType}
 
 	switch metricType {
 	case model.Gauge:
@@ -71,7 +239,7 @@ func (h *Handler) updateMetric(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "ERROR: invalid Gauge metric", http.StatusBadRequest)
 			return
 		}
-		h.storage.UpdateGauge(metricName, value)
+		metric.Value = &value
 
 	case model.Counter:
 		delta, err := strconv.ParseInt(metricValue, 10, 64)
@@ -79,15 +247,19 @@ func (h *Handler) updateMetric(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "ERROR: invalid Counter metric", http.StatusBadRequest)
 			return
 		}
-		h.storage.UpdateCounter(metricName, delta)
+		metric.Delta = &delta
 
 	default:
 		http.Error(w, "ERROR: unknown metric type", http.StatusBadRequest)
 		return
 	}
 
+	if !h.checkName(w, metricName) || !h.update(w, r, h.storageFor(r), []model.Metrics{metric}) {
+		return
+	}
+
 	// Синхронное сохранение
-	if h.syncSave {
+	if h.syncSave() {
 		if err := h.fileService.SaveSync(); err != nil {
 			h.logger.Error("Failed to save metrics synchronously", zap.Error(err))
 		} else {
@@ -103,7 +275,7 @@ func (h *Handler) getMetric(w http.ResponseWriter, r *http.Request) {
 	metricType := chi.URLParam(r, "type")
 	metricName := chi.URLParam(r, "name")
 
-	metric, ok := h.storage.GetMetric(metricType, metricName)
+	metric, ok := h.storageFor(r).GetMetric(metricType, metricName)
 	if !ok {
 		http.NotFound(w, r)
 		return
@@ -116,9 +288,23 @@ func (h *Handler) getMetric(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// все метрики вместе с производными gauge скорости счётчиков
+func allWithRates(st Storage) map[string]model.Metrics {
+	metrics := st.GetAll()
+	for _, rate := range st.GetRates() {
+		for _, gauge := range rate.Gauges() {
+			// не перетираем метрику, присланную агентом под тем же именем
+			if _, ok := metrics[gauge.ID]; !ok {
+				metrics[gauge.ID] = gauge
+			}
+		}
+	}
+	return metrics
+}
+
 // хэндлер получения всех метрик
 func (h *Handler) listMetrics(w http.ResponseWriter, r *http.Request) {
-	metrics := h.storage.GetAll()
+	metrics := allWithRates(h.storageFor(r))
 	initTemplates()
 
 	w.Header().Set("Content-Type", "text/html")
@@ -126,6 +312,121 @@ func (h *Handler) listMetrics(w http.ResponseWriter, r *http.Request) {
 	metricsTemplate.Execute(w, metrics)
 }
 
+// хэндлер получения скорости изменения счётчика
+func (h *Handler) getRate(w http.ResponseWriter, r *http.Request) {
+	metricName := chi.URLParam(r, "name")
+
+	rate, ok := h.storageFor(r).GetRate(metricName)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(rate); err != nil {
+		h.logger.Error("Failed to encode JSON response", zap.Error(err))
+		http.Error(w, "ERROR: failed to encode response", http.StatusInternalServerError)
+	}
+}
+
+// хэндлер списка правил агрегации с состоянием их последнего вычисления
+func (h *Handler) listAggregations(w http.ResponseWriter, r *http.Request) {
+	statuses := []aggregate.RuleStatus{}
+	if h.aggregation != nil {
+		statuses = h.aggregation.Statuses(tenant.FromContext(r.Context()))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(statuses); err != nil {
+		h.logger.Error("Failed to encode JSON response", zap.Error(err))
+		http.Error(w, "ERROR: failed to encode response", http.StatusInternalServerError)
+	}
+}
+
+// хэндлер разового вычисления выражения, например /query?expr=HeapInuse/HeapSys*100
+func (h *Handler) query(w http.ResponseWriter, r *http.Request) {
+	source := r.URL.Query().Get("expr")
+	if source == "" {
+		http.Error(w, "ERROR: expr parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	e, err := expr.Parse(source)
+	if err != nil {
+		http.Error(w, "ERROR: invalid expression: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	result, err := e.Eval(h.storageFor(r))
+	if err != nil {
+		http.Error(w, "ERROR: failed to evaluate expression: "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		h.logger.Error("Failed to encode JSON response", zap.Error(err))
+		http.Error(w, "ERROR: failed to encode response", http.StatusInternalServerError)
+	}
+}
+
+// хэндлер выгрузки метрик в формате Prometheus
+func (h *Handler) exportPrometheus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", prom.ContentType)
+	if err := prom.Write(w, allWithRates(h.storageFor(r))); err != nil {
+		h.logger.Error("Failed to write Prometheus response", zap.Error(err))
+	}
+}
+
+// хэндлер собственных метрик сервера в формате Prometheus
+func (h *Handler) exportSelfMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", prom.ContentType)
+	if err := prom.Write(w, h.metrics.Snapshot()); err != nil {
+		h.logger.Error("Failed to write Prometheus response", zap.Error(err))
+	}
+}
+
+// хэндлер удаления метрики
+func (h *Handler) deleteMetric(w http.ResponseWriter, r *http.Request) {
+	metricType := chi.URLParam(r, "type")
+	metricName := chi.URLParam(r, "name")
+
+	if !h.storageFor(r).Delete(metricType, metricName) {
+		http.NotFound(w, r)
+		return
+	}
+
+	h.logger.Info("Metric deleted",
+		zap.String("type", metricType),
+		zap.String("name", metricName),
+		zap.String("by", auth.NameFromContext(r.Context())),
+	)
+	w.WriteHeader(http.StatusOK)
+}
+
+// хэндлер внеочередного сохранения метрик в файл
+func (h *Handler) backup(w http.ResponseWriter, r *http.Request) {
+	if err := h.fileService.SaveSync(); err != nil {
+		h.logger.Error("Failed to save metrics on demand", zap.Error(err))
+		http.Error(w, "ERROR: failed to save metrics", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// хэндлер действующей конфигурации сервера, секреты скрыты
+func (h *Handler) showConfig(w http.ResponseWriter, r *http.Request) {
+	if h.config == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(h.config().Redacted()); err != nil {
+		h.logger.Error("Failed to encode JSON response", zap.Error(err))
+		http.Error(w, "ERROR: failed to encode response", http.StatusInternalServerError)
+	}
+}
+
 // хэндлер обновления метрики через JSON
 func (h *Handler) updateMetricJSON(w http.ResponseWriter, r *http.Request) {
 	var metric model.Metrics
@@ -137,8 +438,7 @@ func (h *Handler) updateMetricJSON(w http.ResponseWriter, r *http.Request) {
 
 	decoder := json.NewDecoder(r.Body)
 	if err := decoder.Decode(&metric); err != nil {
-		h.logger.Error("Failed to decode JSON", zap.Error(err))
-		http.Error(w, "ERROR: invalid JSON", http.StatusBadRequest)
+		h.decodeError(w, err)
 		return
 	}
 
@@ -153,22 +453,27 @@ func (h *Handler) updateMetricJSON(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "ERROR: value is required for gauge", http.StatusBadRequest)
 			return
 		}
-		h.storage.UpdateGauge(metric.ID, *metric.Value)
+		metric.Delta = nil
 
 	case model.Counter:
 		if metric.Delta == nil {
 			http.Error(w, "ERROR: delta is required for counter", http.StatusBadRequest)
 			return
 		}
-		h.storage.UpdateCounter(metric.ID, *metric.Delta)
+		metric.Value = nil
 
 	default:
 		http.Error(w, "ERROR: unknown metric type", http.StatusBadRequest)
 		return
 	}
 
+	st := h.storageFor(r)
+	if !h.checkName(w, metric.ID) || !h.update(w, r, st, []model.Metrics{metric}) {
+		return
+	}
+
 	// Синхронное сохранение
-	if h.syncSave {
+	if h.syncSave() {
 		if err := h.fileService.SaveSync(); err != nil {
 			h.logger.Error("Failed to save metrics synchronously", zap.Error(err))
 		} else {
@@ -177,7 +482,7 @@ func (h *Handler) updateMetricJSON(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Возвращаем обновленную метрику
-	updatedMetric, ok := h.storage.GetMetric(metric.MType, metric.ID)
+	updatedMetric, ok := st.GetMetric(metric.MType, metric.ID)
 	if !ok {
 		http.Error(w, "ERROR: failed to get updated metric", http.StatusInternalServerError)
 		return
@@ -191,6 +496,69 @@ func (h *Handler) updateMetricJSON(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// хэндлер обновления пачки метрик через JSON-массив; пачка применяется
+// целиком, только если все метрики корректны и проходят проверки
+func (h *Handler) updateMetricsBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Content-Type") != "application/json" {
+		http.Error(w, "ERROR: Content-Type must be application/json", http.StatusBadRequest)
+		return
+	}
+
+	var metrics []model.Metrics
+	if err := json.NewDecoder(r.Body).Decode(&metrics); err != nil {
+		h.decodeError(w, err)
+		return
+	}
+
+	for i, metric := range metrics {
+		switch {
+		case metric.ID == "":
+			http.Error(w, fmt.Sprintf("ERROR: metric %d: ID is required", i), http.StatusBadRequest)
+			return
+		case metric.MType == model.Gauge && metric.Value == nil:
+			http.Error(w, fmt.Sprintf("ERROR: metric %s: value is required for gauge", metric.ID), http.StatusBadRequest)
+			return
+		case metric.MType == model.Counter && metric.Delta == nil:
+			http.Error(w, fmt.Sprintf("ERROR: metric %s: delta is required for counter", metric.ID), http.StatusBadRequest)
+			return
+		case metric.MType != model.Gauge && metric.MType != model.Counter:
+			http.Error(w, fmt.Sprintf("ERROR: metric %s: unknown metric type", metric.ID), http.StatusBadRequest)
+			return
+		}
+		if !h.checkName(w, metric.ID) {
+			return
+		}
+	}
+
+	st := h.storageFor(r)
+	if !h.update(w, r, st, metrics) {
+		return
+	}
+
+	// Синхронное сохранение
+	if h.syncSave() {
+		if err := h.fileService.SaveSync(); err != nil {
+			h.logger.Error("Failed to save metrics synchronously", zap.Error(err))
+		} else {
+			h.logger.Info("Metrics saved synchronously")
+		}
+	}
+
+	// Возвращаем обновленные метрики
+	updated := make([]model.Metrics, 0, len(metrics))
+	for _, metric := range metrics {
+		if m, ok := st.GetMetric(metric.MType, metric.ID); ok {
+			updated = append(updated, m)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(updated); err != nil {
+		h.logger.Error("Failed to encode JSON response", zap.Error(err))
+	}
+}
+
 // хэндлер получения метрики через JSON
 func (h *Handler) getMetricJSON(w http.ResponseWriter, r *http.Request) {
 	if r.Header.Get("Content-Type") != "application/json" {
@@ -202,8 +570,7 @@ func (h *Handler) getMetricJSON(w http.ResponseWriter, r *http.Request) {
 
 	decoder := json.NewDecoder(r.Body)
 	if err := decoder.Decode(&metric); err != nil {
-		h.logger.Error("Failed to decode JSON", zap.Error(err))
-		http.Error(w, "ERROR: invalid JSON", http.StatusBadRequest)
+		h.decodeError(w, err)
 		return
 	}
 
@@ -212,7 +579,7 @@ func (h *Handler) getMetricJSON(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	foundMetric, ok := h.storage.GetMetric(metric.MType, metric.ID)
+	foundMetric, ok := h.storageFor(r).GetMetric(metric.MType, metric.ID)
 	if !ok {
 		http.NotFound(w, r)
 		return
@@ -226,7 +593,7 @@ func (h *Handler) getMetricJSON(w http.ResponseWriter, r *http.Request) {
 }
 
 // основной хэндлер
-func NewHandler(storage Storage, fileService *service.FileStorageService, syncSave bool) http.Handler {
+func NewHandler(storage Storage, fileService *service.FileStorageService, opts ...Option) http.Handler {
 	// инициализируем логгер
 	err := middleware.InitLogger()
 	if err != nil {
@@ -240,25 +607,63 @@ func NewHandler(storage Storage, fileService *service.FileStorageService, syncSa
 	handler := &Handler{
 		storage:     storage,
 		fileService: fileService,
-		syncSave:    syncSave,
+		maxBody:     middleware.DefaultMaxBodySize,
+		maxUnzipped: middleware.DefaultMaxDecompressedSize,
 		logger:      logger,
 		sugar:       sugar,
 	}
+	for _, opt := range opts {
+		opt(handler)
+	}
 
 	router := chi.NewRouter()
 
-	router.Use(middleware.GzipDecompressionMiddleware)
+	router.Use(middleware.InstrumentMiddleware(handler.metrics))
 	router.Use(middleware.LoggingMiddleware)
 	router.Use(middleware.GzipCompressionMiddleware)
+	if handler.resolver != nil {
+		router.Use(tenant.Middleware(handler.resolver, func(r *http.Request) bool {
+			return handler.auth.HasRole(r, auth.RoleAdmin)
+		}))
+	}
 
-	// Старые эндпоинты
-	router.Post("/update/{type}/{name}/{value}", handler.updateMetric)
-	router.Get("/value/{type}/{name}", handler.getMetric)
-	router.Get("/", handler.listMetrics)
+	// тело читается только после проверок доступа
+	decompress := middleware.GzipDecompressionWithLimits(handler.maxBody, handler.maxUnzipped, handler.metrics)
+
+	// Запись метрик; расшифровка дорогая, поэтому идёт после подсети, токена и лимита частоты
+	router.Group(func(r chi.Router) {
+		r.Use(middleware.TrustedSubnetMiddleware(handler.trusted, handler.trustRemote))
+		r.Use(handler.auth.Require(auth.RoleWriter))
+		r.Use(middleware.RateLimitMiddleware(handler.limiter, handler.limiterKey))
+		r.Use(middleware.DecryptionMiddleware(handler.cryptoKey, handler.maxBody))
+		r.Use(middleware.RequireEncryptionMiddleware(handler.cryptoKey))
+		r.Use(decompress)
+		r.Post("/update/{type}/{name}/{value}", handler.updateMetric)
+		r.Post("/update/", handler.updateMetricJSON)
+		r.Post("/updates/", handler.updateMetricsBatch)
+	})
 
-	// Новые JSON эндпоинты
-	router.Post("/update/", handler.updateMetricJSON)
-	router.Post("/value/", handler.getMetricJSON)
+	// Чтение метрик
+	router.Group(func(r chi.Router) {
+		r.Use(handler.auth.Require(auth.RoleReader))
+		r.Use(decompress)
+		r.Get("/value/{type}/{name}", handler.getMetric)
+		r.Get("/", handler.listMetrics)
+		r.Get("/rate/{name}", handler.getRate)
+		r.Get("/metrics", handler.exportPrometheus)
+		r.Get("/debug/metrics", handler.exportSelfMetrics)
+		r.Get("/aggregations", handler.listAggregations)
+		r.Get("/query", handler.query)
+		r.Post("/value/", handler.getMetricJSON)
+	})
+
+	// Администрирование
+	router.Group(func(r chi.Router) {
+		r.Use(handler.auth.Require(auth.RoleAdmin))
+		r.Delete("/value/{type}/{name}", handler.deleteMetric)
+		r.Post("/admin/backup", handler.backup)
+		r.Get("/admin/config", handler.showConfig)
+	})
 
 	return router
 }
@@ -0,0 +1,317 @@
+package handler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/shatrunoff/yap_metrics/internal/auth"
+	"github.com/shatrunoff/yap_metrics/internal/config"
+	"github.com/shatrunoff/yap_metrics/internal/envelope"
+	"github.com/shatrunoff/yap_metrics/internal/model"
+	"github.com/shatrunoff/yap_metrics/internal/ratelimit"
+	"github.com/shatrunoff/yap_metrics/internal/storage"
+	"github.com/shatrunoff/yap_metrics/internal/tenant"
+)
+
+// сервер с арендаторами small (не больше двух метрик), slow (одно обновление
+// в секунду) и batch (три обновления в секунду)
+func newQuotaHandler(t *testing.T) (http.Handler, *storage.TenantStorage) {
+	t.Helper()
+	cfg := &tenant.Config{Tenants: []tenant.Tenant{
+		{ID: "small", TokensSHA256: []string{auth.HashToken("small-token")}, Quota: &tenant.Quota{MaxMetrics: 2}},
+		{ID: "slow", TokensSHA256: []string{auth.HashToken("slow-token")}, Quota: &tenant.Quota{MaxUpdatesPerSec: 1}},
+		{ID: "batch", TokensSHA256: []string{auth.HashToken("batch-token")}, Quota: &tenant.Quota{MaxUpdatesPerSec: 3}},
+	}}
+	memStorage := storage.NewMemStorage()
+	tenants := storage.NewTenantStorage(memStorage, cfg.Quota)
+	return NewHandler(memStorage, nil, WithTenants(tenants, tenant.NewResolver(cfg))), tenants
+}
+
+func post(h http.Handler, token, url, contentType, body string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodPost, url, strings.NewReader(body))
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	if contentType != "" {
+		request.Header.Set("Content-Type", contentType)
+	}
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestUpdateQuotas(t *testing.T) {
+	t.Run("Update rate", func(t *testing.T) {
+		h, _ := newQuotaHandler(t)
+		if code := post(h, "slow-token", "/update/gauge/Alloc/1", "", "").Code; code != http.StatusOK {
+			t.Fatalf("first update status = %d, want %d", code, http.StatusOK)
+		}
+		recorder := post(h, "slow-token", "/update/gauge/Alloc/2", "", "")
+		if recorder.Code != http.StatusTooManyRequests {
+			t.Fatalf("second update status = %d, want %d", recorder.Code, http.StatusTooManyRequests)
+		}
+		if recorder.Header().Get("Retry-After") == "" {
+			t.Error("Retry-After header is not set")
+		}
+	})
+
+	t.Run("Batch larger than burst", func(t *testing.T) {
+		h, tenants := newQuotaHandler(t)
+		batch := `[{"id":"a","type":"gauge","value":1},{"id":"b","type":"gauge","value":1},{"id":"c","type":"gauge","value":1},` +
+			`{"id":"d","type":"gauge","value":1},{"id":"e","type":"gauge","value":1}]`
+		if code := post(h, "batch-token", "/updates/", "application/json", batch).Code; code != http.StatusOK {
+			t.Fatalf("batch status = %d, want %d", code, http.StatusOK)
+		}
+		if n := len(tenants.Tenant("batch").GetAll()); n != 5 {
+			t.Errorf("stored %d metrics, want 5", n)
+		}
+		// пачка израсходовала всё ведро
+		if code := post(h, "batch-token", "/update/gauge/a/2", "", "").Code; code != http.StatusTooManyRequests {
+			t.Errorf("update after batch status = %d, want %d", code, http.StatusTooManyRequests)
+		}
+	})
+
+	t.Run("Malformed requests do not consume quota", func(t *testing.T) {
+		h, _ := newQuotaHandler(t)
+		malformed := []struct{ url, contentType, body string }{
+			{url: "/update/gauge/Alloc/abc"},
+			{url: "/update/histogram/Alloc/1"},
+			{url: "/update/", contentType: "application/json", body: `{"id":"Alloc","type":"gauge"}`},
+			{url: "/updates/", contentType: "application/json", body: `[{"id":"Alloc","type":"gauge","value":1},{"id":"PollCount","type":"counter"}]`},
+		}
+		for _, m := range malformed {
+			if code := post(h, "slow-token", m.url, m.contentType, m.body).Code; code != http.StatusBadRequest {
+				t.Errorf("POST %s status = %d, want %d", m.url, code, http.StatusBadRequest)
+			}
+		}
+		if code := post(h, "slow-token", "/update/gauge/Alloc/1", "", "").Code; code != http.StatusOK {
+			t.Errorf("valid update status = %d, want %d", code, http.StatusOK)
+		}
+	})
+
+	t.Run("Metric count", func(t *testing.T) {
+		h, tenants := newQuotaHandler(t)
+		for _, url := range []string{"/update/gauge/a/1", "/update/counter/b/1"} {
+			if code := post(h, "small-token", url, "", "").Code; code != http.StatusOK {
+				t.Fatalf("POST %s status = %d, want %d", url, code, http.StatusOK)
+			}
+		}
+		if code := post(h, "small-token", "/update/gauge/c/1", "", "").Code; code != http.StatusTooManyRequests {
+			t.Errorf("new metric status = %d, want %d", code, http.StatusTooManyRequests)
+		}
+		if code := post(h, "small-token", "/update/gauge/a/2", "", "").Code; code != http.StatusOK {
+			t.Errorf("existing metric status = %d, want %d", code, http.StatusOK)
+		}
+
+		batch := `[{"id":"a","type":"gauge","value":3},{"id":"d","type":"gauge","value":1}]`
+		if code := post(h, "small-token", "/updates/", "application/json", batch).Code; code != http.StatusTooManyRequests {
+			t.Errorf("batch status = %d, want %d", code, http.StatusTooManyRequests)
+		}
+		if m, _ := tenants.Tenant("small").GetMetric(model.Gauge, "a"); *m.Value != 2 {
+			t.Errorf("a = %v after rejected batch, want 2", *m.Value)
+		}
+	})
+}
+
+func TestUpdateMetricsBatch(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		wantCode    int
+		wantBody    string
+	}{
+		{
+			name:        "Valid batch",
+			contentType: "application/json",
+			body:        `[{"id":"Alloc","type":"gauge","value":1.5},{"id":"PollCount","type":"counter","delta":2},{"id":"PollCount","type":"counter","delta":3}]`,
+			wantCode:    http.StatusOK,
+			wantBody:    `[{"id":"Alloc","type":"gauge","value":1.5},{"id":"PollCount","type":"counter","delta":5},{"id":"PollCount","type":"counter","delta":5}]`,
+		},
+		{name: "Empty batch", contentType: "application/json", body: `[]`, wantCode: http.StatusOK, wantBody: `[]`},
+		{name: "Wrong content type", contentType: "text/plain", body: `[]`, wantCode: http.StatusBadRequest},
+		{name: "Invalid JSON", contentType: "application/json", body: `[{"id":`, wantCode: http.StatusBadRequest},
+		{name: "Missing ID", contentType: "application/json", body: `[{"type":"gauge","value":1}]`, wantCode: http.StatusBadRequest},
+		{name: "Unknown type", contentType: "application/json", body: `[{"id":"Alloc","type":"histogram","value":1}]`, wantCode: http.StatusBadRequest},
+		{name: "Reserved name", contentType: "application/json", body: `[{"id":"_server_requests","type":"counter","delta":1}]`, wantCode: http.StatusBadRequest},
+		{
+			name:        "One invalid metric rejects the batch",
+			contentType: "application/json",
+			body:        `[{"id":"Alloc","type":"gauge","value":1},{"id":"PollCount","type":"counter"}]`,
+			wantCode:    http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := storage.NewMemStorage()
+			recorder := post(NewHandler(st, nil), "", "/updates/", tt.contentType, tt.body)
+
+			if recorder.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d, body: %s", recorder.Code, tt.wantCode, recorder.Body)
+			}
+			if tt.wantCode != http.StatusOK {
+				if n := len(st.GetAll()); n != 0 {
+					t.Errorf("rejected batch stored %d metrics", n)
+				}
+				return
+			}
+			if got := strings.TrimSpace(recorder.Body.String()); got != tt.wantBody {
+				t.Errorf("body = %s, want %s", got, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestShowConfig(t *testing.T) {
+	cfg := config.DefaultServerConfig()
+	cfg.CryptoKey = "/etc/metrics/private.pem"
+	authenticator := auth.NewAuthenticator(&auth.Config{Tokens: []auth.Token{
+		{Name: "ops", SHA256: auth.HashToken("admin-token"), Roles: []auth.Role{auth.RoleAdmin}},
+		{Name: "grafana", SHA256: auth.HashToken("reader-token"), Roles: []auth.Role{auth.RoleReader}},
+	}})
+	h := NewHandler(storage.NewMemStorage(), nil,
+		WithAuth(authenticator),
+		WithConfig(func() *config.ServerConfig { return cfg }))
+
+	serve := func(token string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, "/admin/config", nil)
+		request.Header.Set("Authorization", "Bearer "+token)
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	if code := serve("reader-token").Code; code != http.StatusForbidden {
+		t.Errorf("reader status = %d, want %d", code, http.StatusForbidden)
+	}
+
+	recorder := serve("admin-token")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("admin status = %d, want %d", recorder.Code, http.StatusOK)
+	}
+	var fields map[string]string
+	if err := json.NewDecoder(recorder.Body).Decode(&fields); err != nil {
+		t.Fatal(err)
+	}
+	if fields["address"] != cfg.ServerURL || fields["store_interval"] != "5m0s" {
+		t.Errorf("config = %v, want address %s and store_interval 5m0s", fields, cfg.ServerURL)
+	}
+	if strings.Contains(recorder.Body.String(), cfg.CryptoKey) || fields["crypto_key"] == "" {
+		t.Errorf("crypto_key = %q, want redacted", fields["crypto_key"])
+	}
+}
+
+func TestPlaintextWritesWithCryptoKey(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := storage.NewMemStorage()
+	st.UpdateGauge("Alloc", 1)
+	h := NewHandler(st, nil, WithCryptoKey(key))
+
+	tests := []struct {
+		name        string
+		url         string
+		contentType string
+		body        string
+		wantCode    int
+	}{
+		{name: "JSON update", url: "/update/", contentType: "application/json", body: `{"id":"Alloc","type":"gauge","value":2}`, wantCode: http.StatusBadRequest},
+		{name: "Batch update", url: "/updates/", contentType: "application/json", body: `[{"id":"Alloc","type":"gauge","value":2}]`, wantCode: http.StatusBadRequest},
+		{name: "URL update without body", url: "/update/gauge/Alloc/3", wantCode: http.StatusOK},
+		{name: "Read request", url: "/value/", contentType: "application/json", body: `{"id":"Alloc","type":"gauge"}`, wantCode: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code := post(h, "", tt.url, tt.contentType, tt.body).Code; code != tt.wantCode {
+				t.Errorf("POST %s status = %d, want %d", tt.url, code, tt.wantCode)
+			}
+		})
+	}
+}
+
+// тело запроса, запоминающее, читали ли его
+type trackedBody struct {
+	io.Reader
+	read bool
+}
+
+func (b *trackedBody) Read(p []byte) (int, error) {
+	b.read = true
+	return b.Reader.Read(p)
+}
+
+func TestDecryptionAfterAccessChecks(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// как у агента: JSON сжимается, затем шифруется
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	zw.Write([]byte(`{"id":"Alloc","type":"gauge","value":1.5}`))
+	zw.Close()
+	payload, err := envelope.Encrypt(&key.PublicKey, compressed.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	st := storage.NewMemStorage()
+	h := NewHandler(st, nil,
+		WithCryptoKey(key),
+		WithAuth(auth.NewAuthenticator(&auth.Config{Tokens: []auth.Token{
+			{Name: "agent", SHA256: auth.HashToken("writer-token"), Roles: []auth.Role{auth.RoleWriter}},
+		}})),
+		WithTrustedSubnets([]netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}, false),
+		WithRateLimit(ratelimit.NewLimiter(0.001, 1), func(r *http.Request) string { return "client" }))
+
+	tests := []struct {
+		name     string
+		token    string
+		realIP   string
+		wantCode int
+		wantRead bool
+	}{
+		{name: "Untrusted subnet", token: "writer-token", realIP: "192.0.2.1", wantCode: http.StatusForbidden},
+		{name: "No token", realIP: "10.0.0.1", wantCode: http.StatusUnauthorized},
+		{name: "Unknown token", token: "guess", realIP: "10.0.0.1", wantCode: http.StatusUnauthorized},
+		{name: "Authorized", token: "writer-token", realIP: "10.0.0.1", wantCode: http.StatusOK, wantRead: true},
+		{name: "Rate limited", token: "writer-token", realIP: "10.0.0.1", wantCode: http.StatusTooManyRequests},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := &trackedBody{Reader: bytes.NewReader(payload)}
+			request := httptest.NewRequest(http.MethodPost, "/update/", body)
+			request.Header.Set("Content-Type", "application/json")
+			request.Header.Set("Content-Encoding", "gzip")
+			request.Header.Set(envelope.Header, envelope.Scheme)
+			request.Header.Set("X-Real-IP", tt.realIP)
+			if tt.token != "" {
+				request.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			recorder := httptest.NewRecorder()
+			h.ServeHTTP(recorder, request)
+
+			if recorder.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d, body: %s", recorder.Code, tt.wantCode, recorder.Body)
+			}
+			if body.read != tt.wantRead {
+				t.Errorf("body read = %v, want %v", body.read, tt.wantRead)
+			}
+		})
+	}
+
+	if m, ok := st.GetMetric(model.Gauge, "Alloc"); !ok || *m.Value != 1.5 {
+		t.Errorf("Alloc = %+v, want 1.5", m)
+	}
+}
@@ -0,0 +1,41 @@
+package handler
+
+import (
+	"time"
+
+	"github.com/shatrunoff/yap_metrics/internal/model"
+	"github.com/shatrunoff/yap_metrics/internal/selfmetrics"
+)
+
+// длительность операций с хранилищем по имени операции
+const storageDurationMetric = "storage_operation_duration_seconds"
+
+// хранилище, замеряющее длительность операций
+type instrumentedStorage struct {
+	Storage
+	reg *selfmetrics.Registry
+}
+
+func (s instrumentedStorage) observe(op string, start time.Time) {
+	s.reg.Observe(storageDurationMetric, map[string]string{"op": op}, time.Since(start))
+}
+
+func (s instrumentedStorage) Update(metrics []model.Metrics, limit int) error {
+	defer s.observe("update", time.Now())
+	return s.Storage.Update(metrics, limit)
+}
+
+func (s instrumentedStorage) GetMetric(metricType, name string) (model.Metrics, bool) {
+	defer s.observe("get", time.Now())
+	return s.Storage.GetMetric(metricType, name)
+}
+
+func (s instrumentedStorage) GetAll() map[string]model.Metrics {
+	defer s.observe("get_all", time.Now())
+	return s.Storage.GetAll()
+}
+
+func (s instrumentedStorage) Delete(metricType, name string) bool {
+	defer s.observe("delete", time.Now())
+	return s.Storage.Delete(metricType, name)
+}
@@ -2,10 +2,13 @@ package middleware
 
 import (
 	"compress/gzip"
+	"errors"
 	"io"
 	"net/http"
 	"strings"
 	"sync"
+
+	"github.com/shatrunoff/yap_metrics/internal/selfmetrics"
 )
 
 var gzipWriterPool = sync.Pool{
@@ -35,21 +38,105 @@ func GzipCompressionMiddleware(h http.Handler) http.Handler {
 	})
 }
 
-// распаковывает входящие gzip данные
+// ограничения размера тела запроса по умолчанию
+const (
+	DefaultMaxBodySize         = 1 << 20
+	DefaultMaxDecompressedSize = 10 << 20
+)
+
+// распаковывает входящие gzip данные с ограничениями по умолчанию
 func GzipDecompressionMiddleware(h http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		contentEncoding := r.Header.Get("Content-Encoding")
-		if strings.Contains(contentEncoding, "gzip") {
-			gz, err := gzip.NewReader(r.Body)
-			if err != nil {
-				http.Error(w, "Invalid gzip data", http.StatusBadRequest)
-				return
+	return GzipDecompressionWithLimits(DefaultMaxBodySize, DefaultMaxDecompressedSize, nil)(h)
+}
+
+// распаковывает входящие gzip данные; maxBody ограничивает тело на входе,
+// maxDecompressed — после распаковки (защита от zip-бомб); испорченные
+// gzip-данные учитываются в reg
+func GzipDecompressionWithLimits(maxBody, maxDecompressed int64, reg *selfmetrics.Registry) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if maxBody > 0 {
+				if r.ContentLength > maxBody {
+					http.Error(w, "ERROR: request body too large", http.StatusRequestEntityTooLarge)
+					return
+				}
+				r.Body = http.MaxBytesReader(w, r.Body, maxBody)
 			}
-			defer gz.Close()
-			r.Body = gz
+
+			contentEncoding := r.Header.Get("Content-Encoding")
+			if strings.Contains(contentEncoding, "gzip") {
+				gz, err := gzip.NewReader(r.Body)
+				if err != nil {
+					if IsBodyTooLarge(err) {
+						http.Error(w, "ERROR: request body too large", http.StatusRequestEntityTooLarge)
+						return
+					}
+					reg.Add(DecompressErrorsMetric, nil, 1)
+					http.Error(w, "Invalid gzip data", http.StatusBadRequest)
+					return
+				}
+				defer gz.Close()
+				r.Body = &limitedReadCloser{
+					ReadCloser: gz,
+					remaining:  maxDecompressed,
+					limit:      maxDecompressed,
+					corrupted:  func() { reg.Add(DecompressErrorsMetric, nil, 1) },
+				}
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ошибка чтения тела, превысившего лимит
+func IsBodyTooLarge(err error) bool {
+	var maxErr *http.MaxBytesError
+	return errors.As(err, &maxErr)
+}
+
+// число запросов с испорченными gzip-данными
+const DecompressErrorsMetric = "http_decompress_errors_total"
+
+// обрывает чтение распакованных данных после limit байт; limit <= 0 — без ограничения
+type limitedReadCloser struct {
+	io.ReadCloser
+	remaining int64
+	limit     int64
+	// вызывается один раз при первой ошибке распаковки
+	corrupted func()
+}
+
+func (l *limitedReadCloser) Read(p []byte) (int, error) {
+	n, err := l.read(p)
+	if err != nil && err != io.EOF && !IsBodyTooLarge(err) && l.corrupted != nil {
+		l.corrupted()
+		l.corrupted = nil
+	}
+	return n, err
+}
+
+func (l *limitedReadCloser) read(p []byte) (int, error) {
+	if l.limit <= 0 {
+		return l.ReadCloser.Read(p)
+	}
+	if l.remaining <= 0 {
+		// проверяем, что данных действительно больше лимита; ошибка
+		// контрольной суммы или потока может проявиться только здесь
+		var probe [1]byte
+		if n, err := l.ReadCloser.Read(probe[:]); n == 0 {
+			if err != nil && err != io.EOF {
+				return 0, err
+			}
+			return 0, io.EOF
 		}
-		h.ServeHTTP(w, r)
-	})
+		return 0, &http.MaxBytesError{Limit: l.limit}
+	}
+	if int64(len(p)) > l.remaining {
+		p = p[:l.remaining]
+	}
+	n, err := l.ReadCloser.Read(p)
+	l.remaining -= int64(n)
+	return n, err
 }
 
 type gzipResponseWriter struct {
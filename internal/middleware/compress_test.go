@@ -0,0 +1,114 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/shatrunoff/yap_metrics/internal/selfmetrics"
+)
+
+func gzipped(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestGzipDecompressionWithLimits(t *testing.T) {
+	const maxBody, maxDecompressed = 1024, 4096
+
+	// читает тело целиком и отвечает так же, как хэндлеры сервера
+	echo := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, err := io.ReadAll(r.Body)
+		switch {
+		case IsBodyTooLarge(err):
+			http.Error(w, "ERROR: request body too large", http.StatusRequestEntityTooLarge)
+		case err != nil:
+			http.Error(w, "ERROR: "+err.Error(), http.StatusBadRequest)
+		default:
+			w.Write(data)
+		}
+	})
+
+	// плохо сжимаемые данные: меньше maxDecompressed, но после сжатия больше maxBody
+	noise := make([]byte, 2*maxBody)
+	rand.New(rand.NewSource(1)).Read(noise)
+
+	tests := []struct {
+		name     string
+		body     []byte
+		gzip     bool
+		chunked  bool
+		wantCode int
+	}{
+		{name: "Raw body within limit", body: bytes.Repeat([]byte("a"), maxBody), wantCode: http.StatusOK},
+		{name: "Raw body too large", body: bytes.Repeat([]byte("a"), maxBody+1), wantCode: http.StatusRequestEntityTooLarge},
+		{name: "Raw body too large without length", body: bytes.Repeat([]byte("a"), maxBody+1), chunked: true, wantCode: http.StatusRequestEntityTooLarge},
+		{name: "Gzip within limit", body: gzipped(t, bytes.Repeat([]byte("a"), maxDecompressed)), gzip: true, wantCode: http.StatusOK},
+		{name: "Zip bomb", body: gzipped(t, make([]byte, 1<<20)), gzip: true, wantCode: http.StatusRequestEntityTooLarge},
+		{name: "Compressed body too large", body: gzipped(t, noise), gzip: true, wantCode: http.StatusRequestEntityTooLarge},
+		{name: "Corrupted gzip", body: []byte("not gzip data"), gzip: true, wantCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg := selfmetrics.NewRegistry()
+			h := GzipDecompressionWithLimits(maxBody, maxDecompressed, reg)(echo)
+
+			request := httptest.NewRequest(http.MethodPost, "/update/", bytes.NewReader(tt.body))
+			if tt.chunked {
+				request.ContentLength = -1
+			}
+			if tt.gzip {
+				request.Header.Set("Content-Encoding", "gzip")
+			}
+			recorder := httptest.NewRecorder()
+			h.ServeHTTP(recorder, request)
+
+			if recorder.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d", recorder.Code, tt.wantCode)
+			}
+		})
+	}
+
+	// распакованные данные ровно в лимит, но с испорченной контрольной суммой;
+	// Flush отделяет данные от конца потока, и ошибка видна только после лимита
+	var trailer bytes.Buffer
+	zw := gzip.NewWriter(&trailer)
+	zw.Write(bytes.Repeat([]byte("a"), maxDecompressed))
+	zw.Flush()
+	zw.Close()
+	badTrailer := trailer.Bytes()
+	badTrailer[len(badTrailer)-8] ^= 0xff
+
+	for name, body := range map[string][]byte{
+		"Corrupted gzip is counted":                 []byte("not gzip data"),
+		"Corrupted trailer at the limit is counted": badTrailer,
+	} {
+		t.Run(name, func(t *testing.T) {
+			reg := selfmetrics.NewRegistry()
+			h := GzipDecompressionWithLimits(maxBody, maxDecompressed, reg)(echo)
+			request := httptest.NewRequest(http.MethodPost, "/update/", bytes.NewReader(body))
+			request.Header.Set("Content-Encoding", "gzip")
+			recorder := httptest.NewRecorder()
+			h.ServeHTTP(recorder, request)
+
+			if recorder.Code != http.StatusBadRequest {
+				t.Errorf("status = %d, want %d", recorder.Code, http.StatusBadRequest)
+			}
+			if m, ok := reg.Snapshot()[DecompressErrorsMetric]; !ok || *m.Delta != 1 {
+				t.Errorf("%s = %+v, want 1", DecompressErrorsMetric, m)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/rsa"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/shatrunoff/yap_metrics/internal/envelope"
+)
+
+// отметка в контексте, что тело запроса пришло зашифрованным
+type decryptedKey struct{}
+
+// расшифровывает тело запроса, зашифрованное агентом открытым ключом сервера;
+// должен стоять перед GzipDecompressionMiddleware; maxBody ограничивает
+// размер зашифрованного тела, 0 — без ограничения
+func DecryptionMiddleware(key *rsa.PrivateKey, maxBody int64) func(http.Handler) http.Handler {
+	var keyID string
+	if key != nil {
+		keyID = envelope.KeyID(&key.PublicKey)
+	}
+
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			scheme := r.Header.Get(envelope.Header)
+			if scheme == "" {
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			if key == nil {
+				http.Error(w, "ERROR: encrypted payload, but server has no crypto key", http.StatusBadRequest)
+				return
+			}
+			if scheme != envelope.Scheme {
+				http.Error(w, "ERROR: unsupported encryption scheme "+strconv.Quote(scheme), http.StatusBadRequest)
+				return
+			}
+			if id := r.Header.Get(envelope.KeyIDHeader); id != "" && id != keyID {
+				http.Error(w, "ERROR: payload encrypted for key "+id+", server key is "+keyID, http.StatusBadRequest)
+				return
+			}
+
+			body := r.Body
+			if maxBody > 0 {
+				// шифрование добавляет обёрнутый ключ, nonce и тег
+				body = http.MaxBytesReader(w, r.Body, maxBody+int64(key.Size())+64)
+			}
+			data, err := io.ReadAll(body)
+			if err != nil {
+				if IsBodyTooLarge(err) {
+					http.Error(w, "ERROR: request body too large", http.StatusRequestEntityTooLarge)
+					return
+				}
+				http.Error(w, "ERROR: failed to read body", http.StatusBadRequest)
+				return
+			}
+
+			plaintext, err := envelope.Decrypt(key, data)
+			if err != nil {
+				msg := "ERROR: failed to decrypt payload"
+				if errors.Is(err, envelope.ErrKeyMismatch) || errors.Is(err, envelope.ErrMalformed) {
+					msg += ": " + err.Error()
+				}
+				http.Error(w, msg, http.StatusBadRequest)
+				return
+			}
+
+			r.Body = io.NopCloser(bytes.NewReader(plaintext))
+			r.ContentLength = int64(len(plaintext))
+			r.Header.Del(envelope.Header)
+			r.Header.Del(envelope.KeyIDHeader)
+			h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), decryptedKey{}, true)))
+		})
+	}
+}
+
+// при загруженном ключе принимает только зашифрованные тела запросов,
+// запросы без тела (обновление через URL) пропускает; должен стоять
+// после DecryptionMiddleware
+func RequireEncryptionMiddleware(key *rsa.PrivateKey) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		if key == nil {
+			return h
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			decrypted, _ := r.Context().Value(decryptedKey{}).(bool)
+			if r.ContentLength != 0 && !decrypted {
+				http.Error(w, "ERROR: payload must be encrypted with the server key", http.StatusBadRequest)
+				return
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/shatrunoff/yap_metrics/internal/envelope"
+)
+
+func TestRequireEncryptionMiddleware(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := []byte(`{"id":"Alloc","type":"gauge","value":1}`)
+	encrypted, err := envelope.Encrypt(&key.PublicKey, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	echo := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		w.Write(data)
+	})
+
+	tests := []struct {
+		name      string
+		key       *rsa.PrivateKey
+		body      []byte
+		encrypted bool
+		wantCode  int
+		wantBody  []byte
+	}{
+		{name: "Encrypted body", key: key, body: encrypted, encrypted: true, wantCode: http.StatusOK, wantBody: body},
+		{name: "Plaintext body", key: key, body: body, wantCode: http.StatusBadRequest},
+		{name: "No body", key: key, wantCode: http.StatusOK},
+		{name: "No server key", body: body, wantCode: http.StatusOK, wantBody: body},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := DecryptionMiddleware(tt.key, 0)(RequireEncryptionMiddleware(tt.key)(echo))
+
+			request := httptest.NewRequest(http.MethodPost, "/update/", bytes.NewReader(tt.body))
+			if tt.encrypted {
+				request.Header.Set(envelope.Header, envelope.Scheme)
+			}
+			recorder := httptest.NewRecorder()
+			h.ServeHTTP(recorder, request)
+
+			if recorder.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d, body: %s", recorder.Code, tt.wantCode, recorder.Body)
+			}
+			if tt.wantCode == http.StatusOK && !bytes.Equal(recorder.Body.Bytes(), tt.wantBody) {
+				t.Errorf("body = %s, want %s", recorder.Body, tt.wantBody)
+			}
+		})
+	}
+}
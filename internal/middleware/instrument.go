@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/shatrunoff/yap_metrics/internal/selfmetrics"
+)
+
+// метрики запросов, собираемые InstrumentMiddleware
+const (
+	RequestsMetric        = "http_requests_total"
+	RequestDurationMetric = "http_request_duration_seconds"
+)
+
+// считает запросы и их длительность по маршруту chi, методу и статусу;
+// должен стоять первым, чтобы учитывать и отклонённые другими middleware запросы
+func InstrumentMiddleware(reg *selfmetrics.Registry) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		if reg == nil {
+			return h
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rw := &responseWriter{ResponseWriter: w, status: http.StatusOK}
+
+			h.ServeHTTP(rw, r)
+
+			// шаблон маршрута вместо пути, чтобы не плодить серии; запросы без маршрута
+			// или отклонённые до маршрутизации учитываются как unmatched
+			route := "unmatched"
+			if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+				route = rctx.RoutePattern()
+			}
+			reg.Add(RequestsMetric, map[string]string{
+				"route":  route,
+				"method": r.Method,
+				"status": strconv.Itoa(rw.status),
+			}, 1)
+			reg.Observe(RequestDurationMetric, map[string]string{"route": route}, time.Since(start))
+		})
+	}
+}
@@ -10,9 +10,15 @@ import (
 var Logger *zap.Logger
 var Sugar *zap.SugaredLogger
 
+// уровень логирования, общий для всех созданных логгеров
+var logLevel = zap.NewAtomicLevelAt(zap.InfoLevel)
+
 func InitLogger() error {
+	cfg := zap.NewProductionConfig()
+	cfg.Level = logLevel
+
 	var err error
-	Logger, err = zap.NewProduction()
+	Logger, err = cfg.Build()
 	if err != nil {
 		return err
 	}
@@ -20,6 +26,16 @@ func InitLogger() error {
 	return nil
 }
 
+// меняет уровень логирования на лету
+func SetLogLevel(level string) error {
+	return logLevel.UnmarshalText([]byte(level))
+}
+
+// текущий уровень логирования
+func LogLevel() string {
+	return logLevel.String()
+}
+
 func GetLogger() *zap.Logger {
 	return Logger
 }
@@ -0,0 +1,28 @@
+package middleware
+
+import "testing"
+
+func TestSetLogLevel(t *testing.T) {
+	t.Cleanup(func() { SetLogLevel("info") })
+
+	tests := []struct {
+		level   string
+		want    string
+		wantErr bool
+	}{
+		{level: "debug", want: "debug"},
+		{level: "error", want: "error"},
+		{level: "loud", want: "error", wantErr: true},
+		{level: "info", want: "info"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.level, func(t *testing.T) {
+			if err := SetLogLevel(tt.level); (err != nil) != tt.wantErr {
+				t.Errorf("SetLogLevel(%q) error = %v, wantErr %v", tt.level, err, tt.wantErr)
+			}
+			if got := LogLevel(); got != tt.want {
+				t.Errorf("LogLevel() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/shatrunoff/yap_metrics/internal/auth"
+	"github.com/shatrunoff/yap_metrics/internal/ratelimit"
+	"github.com/shatrunoff/yap_metrics/internal/tenant"
+)
+
+// чем идентифицируется клиент при ограничении частоты запросов
+const (
+	RateKeyIP     = "ip"
+	RateKeyToken  = "token"
+	RateKeyTenant = "tenant"
+)
+
+// функция, выделяющая ключ клиента из запроса
+func RateKeyFunc(mode string) (func(r *http.Request) string, error) {
+	switch mode {
+	case RateKeyIP, "":
+		return func(r *http.Request) string {
+			if addr, ok := clientIP(r, true); ok {
+				return addr.String()
+			}
+			return r.RemoteAddr
+		}, nil
+	case RateKeyToken:
+		return func(r *http.Request) string {
+			// храним хэш, чтобы не держать токены в памяти
+			return auth.HashToken(auth.BearerToken(r))
+		}, nil
+	case RateKeyTenant:
+		return func(r *http.Request) string {
+			return tenant.FromContext(r.Context())
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown rate limit key %q", mode)
+	}
+}
+
+// выставляет Retry-After и отвечает 429
+func TooManyRequests(w http.ResponseWriter, msg string, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	http.Error(w, msg, http.StatusTooManyRequests)
+}
+
+// ограничивает частоту запросов каждого клиента; nil limiter отключает проверку
+func RateLimitMiddleware(limiter *ratelimit.Limiter, key func(r *http.Request) string) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		if limiter == nil {
+			return h
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if ok, wait := limiter.Take(key(r)); !ok {
+				TooManyRequests(w, "ERROR: rate limit exceeded", wait)
+				return
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
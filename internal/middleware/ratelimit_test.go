@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/shatrunoff/yap_metrics/internal/ratelimit"
+)
+
+func TestRateLimitMiddleware(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	key := func(r *http.Request) string { return r.Header.Get("X-Client") }
+
+	serve := func(h http.Handler, client string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodPost, "/update/", nil)
+		request.Header.Set("X-Client", client)
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	t.Run("Over limit", func(t *testing.T) {
+		h := RateLimitMiddleware(ratelimit.NewLimiter(0.5, 1), key)(ok)
+		if code := serve(h, "a").Code; code != http.StatusOK {
+			t.Fatalf("first request status = %d, want %d", code, http.StatusOK)
+		}
+		recorder := serve(h, "a")
+		if recorder.Code != http.StatusTooManyRequests {
+			t.Fatalf("second request status = %d, want %d", recorder.Code, http.StatusTooManyRequests)
+		}
+		if got := recorder.Header().Get("Retry-After"); got != "2" {
+			t.Errorf("Retry-After = %q, want %q", got, "2")
+		}
+		// у другого клиента своё ведро
+		if code := serve(h, "b").Code; code != http.StatusOK {
+			t.Errorf("other client status = %d, want %d", code, http.StatusOK)
+		}
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		for _, limiter := range []*ratelimit.Limiter{nil, ratelimit.NewLimiter(0, 1)} {
+			h := RateLimitMiddleware(limiter, key)(ok)
+			for i := 0; i < 5; i++ {
+				if code := serve(h, "a").Code; code != http.StatusOK {
+					t.Fatalf("request %d status = %d, want %d", i+1, code, http.StatusOK)
+				}
+			}
+		}
+	})
+}
+
+func TestRateKeyFunc(t *testing.T) {
+	if _, err := RateKeyFunc("cookie"); err == nil {
+		t.Error("RateKeyFunc(cookie) error = nil")
+	}
+
+	key, err := RateKeyFunc(RateKeyIP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// клиент не может сменить ключ подделкой X-Real-IP
+	request := httptest.NewRequest(http.MethodPost, "/update/", nil)
+	request.RemoteAddr = "192.0.2.1:1234"
+	request.Header.Set("X-Real-IP", "10.0.0.7")
+	if got := key(request); got != "192.0.2.1" {
+		t.Errorf("ip key = %q, want %q", got, "192.0.2.1")
+	}
+}
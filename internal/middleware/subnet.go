@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// разбирает список подсетей CIDR через запятую
+func ParseSubnets(list string) ([]netip.Prefix, error) {
+	var subnets []netip.Prefix
+	for _, part := range strings.Split(list, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted subnet %q: %w", part, err)
+		}
+		subnets = append(subnets, prefix.Masked())
+	}
+	return subnets, nil
+}
+
+// адрес клиента: из X-Real-IP или, если useRemoteAddr, из адреса соединения
+func clientIP(r *http.Request, useRemoteAddr bool) (netip.Addr, bool) {
+	raw := r.Header.Get("X-Real-IP")
+	if useRemoteAddr {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		raw = host
+	}
+
+	addr, err := netip.ParseAddr(strings.TrimSpace(raw))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
+
+// пропускает только запросы из доверенных подсетей, остальным отвечает 403;
+// пустой список подсетей отключает проверку
+func TrustedSubnetMiddleware(subnets []netip.Prefix, useRemoteAddr bool) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		if len(subnets) == 0 {
+			return h
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			addr, ok := clientIP(r, useRemoteAddr)
+			if !ok {
+				http.Error(w, "ERROR: client address is missing or invalid", http.StatusForbidden)
+				return
+			}
+
+			for _, subnet := range subnets {
+				if subnet.Contains(addr) {
+					h.ServeHTTP(w, r)
+					return
+				}
+			}
+			http.Error(w, "ERROR: "+addr.String()+" is not in trusted subnet", http.StatusForbidden)
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+func TestParseSubnets(t *testing.T) {
+	tests := []struct {
+		name    string
+		list    string
+		want    []netip.Prefix
+		wantErr bool
+	}{
+		{name: "Empty", list: ""},
+		{name: "Single", list: "192.168.1.0/24", want: []netip.Prefix{netip.MustParsePrefix("192.168.1.0/24")}},
+		{
+			name: "Multiple with spaces",
+			list: " 10.0.0.0/8, ,fd00::/8 ",
+			want: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("fd00::/8")},
+		},
+		{name: "Host bits are masked", list: "192.168.1.17/24", want: []netip.Prefix{netip.MustParsePrefix("192.168.1.0/24")}},
+		{name: "Address without mask", list: "192.168.1.1", wantErr: true},
+		{name: "Garbage", list: "10.0.0.0/8,trusted", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseSubnets(tt.list)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseSubnets(%q) error = %v, wantErr %v", tt.list, err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseSubnets(%q) = %v, want %v", tt.list, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrustedSubnetMiddleware(t *testing.T) {
+	subnets, err := ParseSubnets("192.168.1.0/24,10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		name          string
+		subnets       []netip.Prefix
+		realIP        string
+		remoteAddr    string
+		useRemoteAddr bool
+		wantCode      int
+	}{
+		{name: "Allowed", subnets: subnets, realIP: "192.168.1.10", wantCode: http.StatusOK},
+		{name: "Allowed by second subnet", subnets: subnets, realIP: "10.20.30.40", wantCode: http.StatusOK},
+		{name: "IPv4-mapped IPv6", subnets: subnets, realIP: "::ffff:10.0.0.1", wantCode: http.StatusOK},
+		{name: "Denied", subnets: subnets, realIP: "172.16.0.1", wantCode: http.StatusForbidden},
+		{name: "Missing header", subnets: subnets, wantCode: http.StatusForbidden},
+		{name: "Garbage header", subnets: subnets, realIP: "192.168.1.10, 10.0.0.1", wantCode: http.StatusForbidden},
+		{name: "Remote address allowed", subnets: subnets, remoteAddr: "10.0.0.5:5000", useRemoteAddr: true, wantCode: http.StatusOK},
+		{name: "Remote address ignores header", subnets: subnets, realIP: "10.0.0.5", remoteAddr: "172.16.0.1:5000", useRemoteAddr: true, wantCode: http.StatusForbidden},
+		{name: "Check disabled", realIP: "172.16.0.1", wantCode: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := TrustedSubnetMiddleware(tt.subnets, tt.useRemoteAddr)(ok)
+
+			request := httptest.NewRequest(http.MethodPost, "/update/", nil)
+			if tt.realIP != "" {
+				request.Header.Set("X-Real-IP", tt.realIP)
+			}
+			if tt.remoteAddr != "" {
+				request.RemoteAddr = tt.remoteAddr
+			}
+			recorder := httptest.NewRecorder()
+			h.ServeHTTP(recorder, request)
+
+			if recorder.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d", recorder.Code, tt.wantCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package model
+
+import (
+	"maps"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Метки кодируются прямо в ID метрики в стиле Prometheus:
+// HeapAlloc{host="web-1",dc="eu"}, ключи отсортированы.
+// Так модель остаётся плоской, а хранилище — индексом по ID.
+
+// собирает ID из имени и меток
+func LabeledID(name string, labels map[string]string) string {
+	if len(labels) == 0 {
+		return name
+	}
+
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString(name)
+	b.WriteByte('{')
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(strconv.Quote(labels[k]))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// разбирает ID на имя и метки; ID без меток или с некорректными
+// метками целиком считается именем
+func ParseID(id string) (string, map[string]string) {
+	open := strings.IndexByte(id, '{')
+	if open <= 0 || !strings.HasSuffix(id, "}") {
+		return id, nil
+	}
+
+	name, rest := id[:open], id[open+1:len(id)-1]
+	labels := make(map[string]string)
+	for rest != "" {
+		eq := strings.IndexByte(rest, '=')
+		if eq <= 0 {
+			return id, nil
+		}
+		key := rest[:eq]
+
+		quoted, err := strconv.QuotedPrefix(rest[eq+1:])
+		if err != nil {
+			return id, nil
+		}
+		value, _ := strconv.Unquote(quoted)
+		labels[key] = value
+
+		rest = rest[eq+1+len(quoted):]
+		if rest != "" {
+			if rest[0] != ',' {
+				return id, nil
+			}
+			rest = rest[1:]
+		}
+	}
+	return name, labels
+}
+
+// добавляет к метрике метки; собственные метки метрики приоритетнее
+func (m Metrics) WithLabels(labels map[string]string) Metrics {
+	if len(labels) == 0 {
+		return m
+	}
+
+	name, own := ParseID(m.ID)
+	merged := maps.Clone(labels)
+	maps.Copy(merged, own)
+
+	m.ID = LabeledID(name, merged)
+	return m
+}
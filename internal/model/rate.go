@@ -0,0 +1,43 @@
+package model
+
+// суффиксы производных gauge-метрик скорости счётчика
+const (
+	Rate1mSuffix  = "_rate1m"
+	Rate5mSuffix  = "_rate5m"
+	Rate15mSuffix = "_rate15m"
+)
+
+// скорость изменения счётчика (единиц в секунду),
+// усреднённая по скользящим окнам 1/5/15 минут
+type Rate struct {
+	ID      string  `json:"id"`
+	Rate1m  float64 `json:"rate_1m"`
+	Rate5m  float64 `json:"rate_5m"`
+	Rate15m float64 `json:"rate_15m"`
+}
+
+// представляет скорость в виде производных gauge-метрик
+func (r Rate) Gauges() []Metrics {
+	values := []struct {
+		suffix string
+		value  float64
+	}{
+		{Rate1mSuffix, r.Rate1m},
+		{Rate5mSuffix, r.Rate5m},
+		{Rate15mSuffix, r.Rate15m},
+	}
+
+	// суффикс добавляется к имени, а не к меткам
+	name, labels := ParseID(r.ID)
+
+	res := make([]Metrics, 0, len(values))
+	for _, v := range values {
+		value := v.value
+		res = append(res, Metrics{
+			ID:    LabeledID(name+v.suffix, labels),
+			MType: Gauge,
+			Value: &value,
+		})
+	}
+	return res
+}
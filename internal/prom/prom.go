@@ -0,0 +1,104 @@
+package prom
+
+import (
+	"bufio"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/shatrunoff/yap_metrics/internal/model"
+)
+
+// Content-Type текстового формата Prometheus
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// приводит имя метрики к допустимому в Prometheus: [a-zA-Z_:][a-zA-Z0-9_:]*
+func SanitizeName(name string) string {
+	var b strings.Builder
+	b.Grow(len(name))
+	for i, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r == '_', r == ':':
+			b.WriteRune(r)
+		case r >= '0' && r <= '9':
+			if i == 0 {
+				b.WriteByte('_')
+			}
+			b.WriteRune(r)
+		default:
+			b.WriteByte('_')
+		}
+	}
+	if b.Len() == 0 {
+		return "_"
+	}
+	return b.String()
+}
+
+// метки в синтаксисе Prometheus: {k="v",...}
+func formatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	sanitized := make(map[string]string, len(labels))
+	for k, v := range labels {
+		sanitized[SanitizeName(k)] = v
+	}
+	return model.LabeledID("", sanitized)
+}
+
+// записывает метрики в текстовом формате Prometheus, отсортировав по имени
+func Write(w io.Writer, metrics map[string]model.Metrics) error {
+	type entry struct {
+		name   string
+		labels string
+		metric model.Metrics
+	}
+
+	entries := make([]entry, 0, len(metrics))
+	for _, metric := range metrics {
+		name, labels := model.ParseID(metric.ID)
+		entries = append(entries, entry{
+			name:   SanitizeName(name),
+			labels: formatLabels(labels),
+			metric: metric,
+		})
+	}
+	// серии одной метрики должны идти подряд под одной строкой TYPE
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].name != entries[j].name {
+			return entries[i].name < entries[j].name
+		}
+		return entries[i].labels < entries[j].labels
+	})
+
+	bw := bufio.NewWriter(w)
+	var lastName string
+	for _, e := range entries {
+		metric := e.metric
+
+		var value string
+		switch metric.MType {
+		case model.Gauge:
+			if metric.Value == nil {
+				continue
+			}
+			value = strconv.FormatFloat(*metric.Value, 'g', -1, 64)
+		case model.Counter:
+			if metric.Delta == nil {
+				continue
+			}
+			value = strconv.FormatInt(*metric.Delta, 10)
+		default:
+			continue
+		}
+
+		if e.name != lastName {
+			bw.WriteString("# TYPE " + e.name + " " + metric.MType + "\n")
+			lastName = e.name
+		}
+		bw.WriteString(e.name + e.labels + " " + value + "\n")
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,82 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// token bucket: rate токенов в секунду, не более burst накопленных
+type Bucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+	mu     sync.Mutex
+}
+
+func NewBucket(rate float64, burst int) *Bucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &Bucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		now:    time.Now,
+	}
+}
+
+// пополняет ведро по прошедшему времени
+func (b *Bucket) refill(now time.Time) {
+	if !b.last.IsZero() {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	b.last = now
+}
+
+// забирает один токен, если он есть
+func (b *Bucket) Allow() bool {
+	return b.AllowN(1)
+}
+
+// забирает n токенов разом или ни одного
+func (b *Bucket) AllowN(n int) bool {
+	ok, _ := b.TakeN(n)
+	return ok
+}
+
+// забирает один токен; если его нет, возвращает время до появления следующего
+func (b *Bucket) Take() (bool, time.Duration) {
+	return b.TakeN(1)
+}
+
+// забирает n токенов разом или ни одного и тогда возвращает время ожидания;
+// n больше burst урезается до burst, иначе такой запрос не прошёл бы никогда
+func (b *Bucket) TakeN(n int) (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill(b.now())
+	need := min(float64(n), b.burst)
+	if b.tokens >= need {
+		b.tokens -= need
+		return true, 0
+	}
+	if b.rate <= 0 {
+		return false, time.Second
+	}
+	return false, time.Duration((need - b.tokens) / b.rate * float64(time.Second))
+}
+
+// ведро полное, т.е. давно не использовалось
+func (b *Bucket) idle() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill(b.now())
+	return b.tokens >= b.burst
+}
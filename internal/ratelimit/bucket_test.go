@@ -0,0 +1,69 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBucketRefill(t *testing.T) {
+	now := time.Unix(0, 0)
+	b := NewBucket(2, 3)
+	b.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if !b.Allow() {
+			t.Fatalf("request %d within burst rejected", i+1)
+		}
+	}
+	if ok, wait := b.Take(); ok || wait != 500*time.Millisecond {
+		t.Fatalf("Take() on empty bucket = %v, %v, want false, 500ms", ok, wait)
+	}
+
+	tests := []struct {
+		name    string
+		elapsed time.Duration
+		allowed int
+	}{
+		{name: "Partial token", elapsed: 250 * time.Millisecond, allowed: 0},
+		{name: "One token", elapsed: 250 * time.Millisecond, allowed: 1},
+		{name: "Refill is capped by burst", elapsed: time.Hour, allowed: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now = now.Add(tt.elapsed)
+			allowed := 0
+			for b.Allow() {
+				allowed++
+			}
+			if allowed != tt.allowed {
+				t.Errorf("allowed %d requests, want %d", allowed, tt.allowed)
+			}
+		})
+	}
+}
+
+func TestBucketTakeN(t *testing.T) {
+	now := time.Unix(0, 0)
+	b := NewBucket(1, 3)
+	b.now = func() time.Time { return now }
+
+	if !b.AllowN(2) {
+		t.Fatal("AllowN(2) rejected with 3 tokens")
+	}
+	// не хватает токенов — не списывается ничего
+	if ok, wait := b.TakeN(2); ok || wait != time.Second {
+		t.Errorf("TakeN(2) with 1 token = %v, %v, want false, 1s", ok, wait)
+	}
+	if !b.Allow() {
+		t.Error("rejected TakeN(2) spent the remaining token")
+	}
+
+	// пачка больше burst списывает всё ведро, но проходит
+	now = now.Add(time.Hour)
+	if !b.AllowN(10) {
+		t.Error("AllowN(10) over burst rejected on a full bucket")
+	}
+	if b.Allow() {
+		t.Error("AllowN(10) over burst left tokens in the bucket")
+	}
+}
@@ -0,0 +1,71 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// как часто выбрасывать вёдра неактивных клиентов
+const sweepInterval = time.Minute
+
+// набор token bucket по ключу клиента; rate <= 0 отключает ограничение
+type Limiter struct {
+	rate      float64
+	burst     int
+	buckets   map[string]*Bucket
+	lastSweep time.Time
+	now       func() time.Time
+	mu        sync.Mutex
+}
+
+func NewLimiter(rate float64, burst int) *Limiter {
+	return &Limiter{
+		rate:    rate,
+		burst:   burst,
+		buckets: make(map[string]*Bucket),
+		now:     time.Now,
+	}
+}
+
+// меняет лимит на лету; накопленные вёдра сбрасываются
+func (l *Limiter) SetRate(rate float64, burst int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.rate = rate
+	l.burst = burst
+	l.buckets = make(map[string]*Bucket)
+}
+
+// забирает токен клиента key; если лимит исчерпан, возвращает время ожидания
+func (l *Limiter) Take(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	if l.rate <= 0 {
+		l.mu.Unlock()
+		return true, 0
+	}
+	now := l.now()
+	if now.Sub(l.lastSweep) > sweepInterval {
+		l.sweep()
+		l.lastSweep = now
+	}
+
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = NewBucket(l.rate, l.burst)
+		bucket.now = l.now
+		l.buckets[key] = bucket
+	}
+	l.mu.Unlock()
+
+	return bucket.Take()
+}
+
+// удаляет вёдра, успевшие наполниться: их состояние равно начальному
+func (l *Limiter) sweep() {
+	for key, bucket := range l.buckets {
+		if bucket.idle() {
+			delete(l.buckets, key)
+		}
+	}
+}
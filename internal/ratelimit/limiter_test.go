@@ -0,0 +1,39 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiterSetRate(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := NewLimiter(0, 1)
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 5; i++ {
+		if ok, _ := l.Take("client"); !ok {
+			t.Fatal("disabled limiter rejected a request")
+		}
+	}
+
+	l.SetRate(1, 2)
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.Take("client"); !ok {
+			t.Fatalf("request %d within burst rejected", i+1)
+		}
+	}
+	if ok, wait := l.Take("client"); ok || wait != time.Second {
+		t.Errorf("Take() over burst = %v, %v, want false, 1s", ok, wait)
+	}
+
+	// смена лимита сбрасывает накопленные вёдра
+	l.SetRate(1, 1)
+	if ok, _ := l.Take("client"); !ok {
+		t.Error("bucket not reset by SetRate")
+	}
+
+	l.SetRate(0, 1)
+	if ok, _ := l.Take("client"); !ok {
+		t.Error("limiter not disabled by SetRate(0)")
+	}
+}
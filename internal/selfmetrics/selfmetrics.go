@@ -0,0 +1,203 @@
+package selfmetrics
+
+import (
+	"context"
+	"maps"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shatrunoff/yap_metrics/internal/model"
+)
+
+// Namespace — префикс собственных метрик сервера в основном хранилище;
+// обновлять метрики с этим префиксом клиентам запрещено
+const Namespace = "_server_"
+
+// Reserved сообщает, принадлежит ли имя метрики пространству сервера
+func Reserved(id string) bool {
+	return strings.HasPrefix(id, Namespace)
+}
+
+// верхние границы корзин гистограмм длительности, секунды
+var DurationBuckets = []float64{0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5}
+
+// накопленные наблюдения одной серии гистограммы
+type histogram struct {
+	counts []int64
+	count  int64
+	sum    float64
+}
+
+// Registry хранит собственные метрики сервера. Все методы допускают nil-получатель,
+// поэтому без реестра инструментирование просто выключено.
+type Registry struct {
+	mu         sync.Mutex
+	counters   map[string]int64
+	gauges     map[string]float64
+	histograms map[string]*histogram
+	collectors []func(*Registry)
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		counters:   make(map[string]int64),
+		gauges:     make(map[string]float64),
+		histograms: make(map[string]*histogram),
+	}
+}
+
+// увеличивает счётчик
+func (r *Registry) Add(name string, labels map[string]string, delta int64) {
+	if r == nil {
+		return
+	}
+	id := model.LabeledID(name, labels)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.counters[id] += delta
+}
+
+// задаёт значение gauge
+func (r *Registry) Set(name string, labels map[string]string, value float64) {
+	if r == nil {
+		return
+	}
+	id := model.LabeledID(name, labels)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.gauges[id] = value
+}
+
+// добавляет наблюдение длительности в гистограмму
+func (r *Registry) Observe(name string, labels map[string]string, d time.Duration) {
+	if r == nil {
+		return
+	}
+	id := model.LabeledID(name, labels)
+	seconds := d.Seconds()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	h, ok := r.histograms[id]
+	if !ok {
+		h = &histogram{counts: make([]int64, len(DurationBuckets))}
+		r.histograms[id] = h
+	}
+	for i, le := range DurationBuckets {
+		if seconds <= le {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+// регистрирует функцию, обновляющую значения перед каждым снимком
+func (r *Registry) Collect(fn func(*Registry)) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, fn)
+}
+
+// Snapshot возвращает все метрики с накопленными значениями счётчиков.
+// Гистограмма раскладывается на counter name_bucket{le}, name_count и gauge name_sum.
+func (r *Registry) Snapshot() map[string]model.Metrics {
+	if r == nil {
+		return map[string]model.Metrics{}
+	}
+
+	r.mu.Lock()
+	collectors := r.collectors
+	r.mu.Unlock()
+	for _, fn := range collectors {
+		fn(r)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	res := make(map[string]model.Metrics, len(r.counters)+len(r.gauges)+len(r.histograms)*(len(DurationBuckets)+3))
+	counter := func(id string, delta int64) {
+		res[id] = model.Metrics{ID: id, MType: model.Counter, Delta: &delta}
+	}
+	gauge := func(id string, value float64) {
+		res[id] = model.Metrics{ID: id, MType: model.Gauge, Value: &value}
+	}
+
+	for id, delta := range r.counters {
+		counter(id, delta)
+	}
+	for id, value := range r.gauges {
+		gauge(id, value)
+	}
+	for id, h := range r.histograms {
+		name, labels := model.ParseID(id)
+		bucket := maps.Clone(labels)
+		if bucket == nil {
+			bucket = make(map[string]string, 1)
+		}
+		for i, le := range DurationBuckets {
+			bucket["le"] = strconv.FormatFloat(le, 'g', -1, 64)
+			counter(model.LabeledID(name+"_bucket", bucket), h.counts[i])
+		}
+		bucket["le"] = "+Inf"
+		counter(model.LabeledID(name+"_bucket", bucket), h.count)
+		counter(model.LabeledID(name+"_count", labels), h.count)
+		gauge(model.LabeledID(name+"_sum", labels), h.sum)
+	}
+	return res
+}
+
+// хранилище, в которое выгружаются метрики
+type Updater interface {
+	UpdateGauge(name string, value float64)
+	UpdateCounter(name string, delta int64)
+}
+
+// Exporter переносит снимки реестра в хранилище под префиксом Namespace;
+// счётчики передаются приращениями с прошлой выгрузки
+type Exporter struct {
+	registry *Registry
+	storage  Updater
+	sent     map[string]int64
+}
+
+func NewExporter(registry *Registry, storage Updater) *Exporter {
+	return &Exporter{registry: registry, storage: storage, sent: make(map[string]int64)}
+}
+
+// выгружает текущий снимок
+func (e *Exporter) Export() {
+	for id, metric := range e.registry.Snapshot() {
+		switch metric.MType {
+		case model.Gauge:
+			e.storage.UpdateGauge(Namespace+id, *metric.Value)
+		case model.Counter:
+			prev, ok := e.sent[id]
+			if delta := *metric.Delta - prev; delta != 0 || !ok {
+				e.storage.UpdateCounter(Namespace+id, delta)
+			}
+			e.sent[id] = *metric.Delta
+		}
+	}
+}
+
+// выгружает снимки с заданным интервалом до отмены ctx
+func (e *Exporter) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			e.Export()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
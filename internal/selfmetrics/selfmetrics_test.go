@@ -0,0 +1,74 @@
+package selfmetrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shatrunoff/yap_metrics/internal/model"
+	"github.com/shatrunoff/yap_metrics/internal/storage"
+)
+
+func TestRegistrySnapshot(t *testing.T) {
+	reg := NewRegistry()
+	route := map[string]string{"route": "/update/"}
+	reg.Observe("latency", route, 2*time.Millisecond)
+	reg.Observe("latency", route, 2*time.Second)
+	reg.Add("requests", route, 3)
+	reg.Collect(func(r *Registry) { r.Set("stored", nil, 42) })
+
+	snapshot := reg.Snapshot()
+	counters := map[string]int64{
+		`requests{route="/update/"}`:                  3,
+		`latency_bucket{le="0.001",route="/update/"}`: 0,
+		`latency_bucket{le="0.005",route="/update/"}`: 1,
+		`latency_bucket{le="5",route="/update/"}`:     2,
+		`latency_bucket{le="+Inf",route="/update/"}`:  2,
+		`latency_count{route="/update/"}`:             2,
+	}
+	for id, want := range counters {
+		if m, ok := snapshot[id]; !ok || *m.Delta != want {
+			t.Errorf("%s = %v, want %d", id, m.Delta, want)
+		}
+	}
+	if m := snapshot[`latency_sum{route="/update/"}`]; m.Value == nil || *m.Value != 2.002 {
+		t.Errorf("latency_sum = %v, want 2.002", m.Value)
+	}
+	if m := snapshot["stored"]; m.Value == nil || *m.Value != 42 {
+		t.Errorf("stored = %v, want 42", m.Value)
+	}
+
+	var nilReg *Registry
+	nilReg.Add("requests", nil, 1)
+	if got := nilReg.Snapshot(); len(got) != 0 {
+		t.Errorf("nil registry snapshot = %v, want empty", got)
+	}
+}
+
+func TestExporter(t *testing.T) {
+	reg := NewRegistry()
+	st := storage.NewMemStorage()
+	exporter := NewExporter(reg, st)
+
+	steps := []struct {
+		name string
+		add  int64
+		want int64
+	}{
+		{name: "First export", add: 5, want: 5},
+		{name: "Only increment is added", add: 2, want: 7},
+		{name: "Nothing new", add: 0, want: 7},
+	}
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			reg.Add("requests", nil, step.add)
+			exporter.Export()
+			m, ok := st.GetMetric(model.Counter, Namespace+"requests")
+			if !ok || *m.Delta != step.want {
+				t.Errorf("stored counter = %v, want %d", m.Delta, step.want)
+			}
+		})
+	}
+	if !Reserved(Namespace+"requests") || Reserved("requests") {
+		t.Error("Reserved() does not match the namespace")
+	}
+}
@@ -0,0 +1,143 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/shatrunoff/yap_metrics/internal/model"
+	"github.com/shatrunoff/yap_metrics/internal/prom"
+)
+
+// AgentStatus — состояние отправки метрик агентом по получателям
+type AgentStatus struct {
+	Destinations []DestinationStatus `json:"destinations"`
+}
+
+// состояние отправки одному получателю
+type DestinationStatus struct {
+	Name        string     `json:"name"`
+	Address     string     `json:"address"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+	// пачки, ожидающие отправки в очереди на диске
+	QueueDepth int   `json:"queue_depth"`
+	QueueBytes int64 `json:"queue_bytes"`
+}
+
+// результаты последних отправок
+type sendStatus struct {
+	mu          sync.Mutex
+	lastSuccess time.Time
+	lastError   error
+	lastErrorAt time.Time
+}
+
+func (s *sendStatus) record(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err != nil {
+		s.lastError, s.lastErrorAt = err, time.Now()
+		return
+	}
+	s.lastSuccess = time.Now()
+}
+
+// Status возвращает состояние отправки и очередей
+func (as *AgentService) Status() AgentStatus {
+	status := AgentStatus{Destinations: make([]DestinationStatus, 0, len(as.destinations))}
+	for _, d := range as.destinations {
+		status.Destinations = append(status.Destinations, d.Status())
+	}
+	return status
+}
+
+// состояние отправки и очереди получателя
+func (d *destination) Status() DestinationStatus {
+	status := DestinationStatus{Name: d.name, Address: d.address}
+
+	d.status.mu.Lock()
+	if !d.status.lastSuccess.IsZero() {
+		t := d.status.lastSuccess
+		status.LastSuccess = &t
+	}
+	if d.status.lastError != nil {
+		t := d.status.lastErrorAt
+		status.LastError, status.LastErrorAt = d.status.lastError.Error(), &t
+	}
+	d.status.mu.Unlock()
+
+	if d.outbox != nil {
+		stats := d.outbox.Stats()
+		status.QueueDepth, status.QueueBytes = stats.Depth, stats.Bytes
+	}
+	return status
+}
+
+// Handler — локальные эндпоинты агента: снимок метрик, которые уйдут
+// на сервер, в JSON и формате Prometheus, и состояние отправки
+func (as *AgentService) Handler() http.Handler {
+	r := chi.NewRouter()
+	r.Get("/metrics", as.exportPrometheus)
+	r.Get("/metrics.json", as.exportJSON)
+	r.Get("/status", as.exportStatus)
+	return r
+}
+
+func (as *AgentService) exportPrometheus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", prom.ContentType)
+	if err := prom.Write(w, as.snapshot()); err != nil {
+		log.Printf("ERROR: failed to write Prometheus response: %v", err)
+	}
+}
+
+func (as *AgentService) exportJSON(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, as.snapshot())
+}
+
+func (as *AgentService) exportStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, as.Status())
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("ERROR: failed to encode JSON response: %v", err)
+		http.Error(w, "ERROR: failed to encode response", http.StatusInternalServerError)
+	}
+}
+
+// запускает локальный HTTP-сервер до закрытия doneChan
+func (as *AgentService) startListener() {
+	server := &http.Server{
+		Addr:              as.config.Listen,
+		Handler:           as.Handler(),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	go func() {
+		<-as.doneChan
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(ctx)
+	}()
+
+	log.Printf("Agent endpoint listening on %s", server.Addr)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Printf("ERROR: agent endpoint: %v", err)
+	}
+}
+
+// метрики в том виде, в каком они уйдут на сервер
+func (as *AgentService) snapshot() map[string]model.Metrics {
+	metrics := as.collector.GetMetrics()
+	as.addOutboxMetrics(metrics)
+	return as.labeled(metrics)
+}
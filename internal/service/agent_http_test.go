@@ -0,0 +1,102 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/shatrunoff/yap_metrics/internal/agent"
+	"github.com/shatrunoff/yap_metrics/internal/config"
+	"github.com/shatrunoff/yap_metrics/internal/model"
+	"github.com/shatrunoff/yap_metrics/internal/prom"
+)
+
+func get(t *testing.T, h http.Handler, url string) *httptest.ResponseRecorder {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, url, nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("GET %s status = %d, want %d", url, recorder.Code, http.StatusOK)
+	}
+	return recorder
+}
+
+func TestAgentHandlerSnapshot(t *testing.T) {
+	as := newTestAgent(t)
+	as.config.Labels = map[string]string{"host": "web1"}
+	value, delta := 1.5, int64(3)
+	as.collector.Record(
+		model.Metrics{ID: "Alloc", MType: model.Gauge, Value: &value},
+		model.Metrics{ID: "PollCount", MType: model.Counter, Delta: &delta},
+	)
+	h := as.Handler()
+
+	t.Run("JSON", func(t *testing.T) {
+		recorder := get(t, h, "/metrics.json")
+		if ct := recorder.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Content-Type = %q, want application/json", ct)
+		}
+		var metrics map[string]model.Metrics
+		if err := json.NewDecoder(recorder.Body).Decode(&metrics); err != nil {
+			t.Fatal(err)
+		}
+		if m, ok := metrics[`Alloc{host="web1"}`]; !ok || *m.Value != 1.5 {
+			t.Errorf(`Alloc{host="web1"} = %+v, want 1.5`, m)
+		}
+		if m, ok := metrics[`PollCount{host="web1"}`]; !ok || *m.Delta != 3 {
+			t.Errorf(`PollCount{host="web1"} = %+v, want 3`, m)
+		}
+	})
+
+	t.Run("Prometheus", func(t *testing.T) {
+		recorder := get(t, h, "/metrics")
+		if ct := recorder.Header().Get("Content-Type"); ct != prom.ContentType {
+			t.Errorf("Content-Type = %q, want %q", ct, prom.ContentType)
+		}
+		body := recorder.Body.String()
+		for _, line := range []string{`Alloc{host="web1"} 1.5`, `PollCount{host="web1"} 3`} {
+			if !strings.Contains(body, line+"\n") {
+				t.Errorf("body has no line %q:\n%s", line, body)
+			}
+		}
+	})
+}
+
+func TestAgentHandlerStatus(t *testing.T) {
+	as := newTestAgent(t,
+		config.DestinationConfig{Name: "primary", Address: "127.0.0.1:8080"},
+		config.DestinationConfig{Name: "backup", Address: "127.0.0.1:8081"},
+	)
+	outbox, err := agent.OpenOutbox(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	primary, backup := as.destinations[0], as.destinations[1]
+	backup.outbox = outbox
+	if err := outbox.Push(testMetrics()); err != nil {
+		t.Fatal(err)
+	}
+	primary.status.record(nil)
+	backup.status.record(errors.New("connection refused"))
+
+	var status map[string][]map[string]any
+	if err := json.NewDecoder(get(t, as.Handler(), "/status").Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+	dests := status["destinations"]
+	if len(dests) != 2 {
+		t.Fatalf("got %d destinations, want 2", len(dests))
+	}
+
+	if d := dests[0]; d["name"] != "primary" || d["address"] != "127.0.0.1:8080" || d["last_success"] == nil ||
+		d["last_error"] != nil || d["queue_depth"] != float64(0) {
+		t.Errorf("primary status = %v", d)
+	}
+	if d := dests[1]; d["name"] != "backup" || d["last_success"] != nil || d["last_error"] != "connection refused" ||
+		d["last_error_at"] == nil || d["queue_depth"] != float64(1) || d["queue_bytes"] == float64(0) {
+		t.Errorf("backup status = %v", d)
+	}
+}
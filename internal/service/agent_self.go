@@ -0,0 +1,76 @@
+package service
+
+import (
+	"maps"
+	"strconv"
+	"time"
+
+	"github.com/shatrunoff/yap_metrics/internal/model"
+)
+
+// верхние границы корзин гистограммы задержки отправки пачки, мс
+var sendLatencyBuckets = []int64{10, 50, 100, 250, 500, 1000, 2500, 5000}
+
+func selfCounter(id string, delta int64) model.Metrics {
+	return model.Metrics{ID: id, MType: model.Counter, Delta: &delta}
+}
+
+// отправляет пачку получателю и учитывает её в self-метриках с меткой получателя
+func (as *AgentService) send(d *destination, metrics map[string]model.Metrics) error {
+	stats := &d.sender.Stats
+	raw, compressed, dropped := stats.RawBytes.Load(), stats.CompressedBytes.Load(), stats.Dropped.Load()
+
+	start := time.Now()
+	err := d.send(metrics)
+	elapsed := time.Since(start)
+
+	p := as.config.SelfMetricsPrefix
+	if p == "" {
+		return err
+	}
+
+	result := "SendSuccesses"
+	if err != nil {
+		result = "SendFailures"
+	}
+	dest := map[string]string{"destination": d.name}
+	self := []model.Metrics{
+		selfCounter(model.LabeledID(p+"SendAttempts", dest), 1),
+		selfCounter(model.LabeledID(p+result, dest), 1),
+		selfCounter(model.LabeledID(p+"SentBytesRaw", dest), stats.RawBytes.Load()-raw),
+		selfCounter(model.LabeledID(p+"SentBytesCompressed", dest), stats.CompressedBytes.Load()-compressed),
+		selfCounter(model.LabeledID(p+"DroppedMetrics", map[string]string{"destination": d.name, "reason": "encode"}), stats.Dropped.Load()-dropped),
+	}
+	self = append(self, latencyHistogram(p+"SendLatencyMs", dest, elapsed)...)
+	as.collector.Record(self...)
+	return err
+}
+
+// наблюдение гистограммы в стиле Prometheus: counter-корзины _bucket{le}, _count и _sum
+func latencyHistogram(name string, labels map[string]string, d time.Duration) []model.Metrics {
+	ms := d.Milliseconds()
+	bucket := maps.Clone(labels)
+	res := make([]model.Metrics, 0, len(sendLatencyBuckets)+3)
+	for _, le := range sendLatencyBuckets {
+		var hit int64
+		if ms <= le {
+			hit = 1
+		}
+		bucket["le"] = strconv.FormatInt(le, 10)
+		res = append(res, selfCounter(model.LabeledID(name+"_bucket", bucket), hit))
+	}
+	bucket["le"] = "+Inf"
+	return append(res,
+		selfCounter(model.LabeledID(name+"_bucket", bucket), 1),
+		selfCounter(model.LabeledID(name+"_count", labels), 1),
+		selfCounter(model.LabeledID(name+"_sum", labels), ms),
+	)
+}
+
+// учитывает метрики, потерянные без отправки
+func (as *AgentService) recordDropped(d *destination, reason string, n int) {
+	if p := as.config.SelfMetricsPrefix; p != "" {
+		id := model.LabeledID(p+"DroppedMetrics", map[string]string{"destination": d.name, "reason": reason})
+		as.collector.Record(selfCounter(id, int64(n)))
+	}
+}
@@ -1,44 +1,110 @@
 package service
 
 import (
+	"context"
+	"fmt"
 	"log"
+	"regexp"
 	"sync"
 	"time"
 
 	"github.com/shatrunoff/yap_metrics/internal/agent"
 	"github.com/shatrunoff/yap_metrics/internal/config"
+	"github.com/shatrunoff/yap_metrics/internal/model"
 )
 
 type AgentService struct {
-	collector *agent.MetricsCollector
-	sender    *agent.Sender
-	config    *config.AgentConfig
-	doneChan  chan struct{}
-	wg        sync.WaitGroup
+	collector    *agent.MetricsCollector
+	destinations []*destination
+	config       *config.AgentConfig
+	doneChan     chan struct{}
+	wg           sync.WaitGroup
 }
 
-func NewAgent(cfg *config.AgentConfig) *AgentService {
-	return &AgentService{
-		collector: agent.NewMetricsCollector(),
-		sender:    agent.NewSender(cfg.ServerURL),
-		config:    cfg,
-		doneChan:  make(chan struct{}),
+func NewAgent(cfg *config.AgentConfig) (*AgentService, error) {
+	destinations, err := newDestinations(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	collector := agent.NewMetricsCollector(agent.WithSelfMetrics(cfg.SelfMetricsPrefix))
+	for _, name := range config.KnownCollectors {
+		if !cfg.CollectorEnabled(name) {
+			continue
+		}
+		c, err := collectorFactories[name](cfg, cfg.Collectors[name])
+		if err != nil {
+			return nil, fmt.Errorf("failed to create collector %s: %w", name, err)
+		}
+		interval := cfg.CollectorInterval(name, c.Interval())
+		collector.Add(c, interval)
+		log.Printf("Collector %s enabled, interval %v", name, interval)
 	}
+
+	return &AgentService{
+		collector:    collector,
+		destinations: destinations,
+		config:       cfg,
+		doneChan:     make(chan struct{}),
+	}, nil
+}
+
+// конструкторы сборщиков по имени; имена совпадают с config.KnownCollectors
+var collectorFactories = map[string]func(cfg *config.AgentConfig, cc config.CollectorConfig) (agent.Collector, error){
+	"runtime": func(*config.AgentConfig, config.CollectorConfig) (agent.Collector, error) {
+		return agent.NewRuntimeCollector(), nil
+	},
+	"gometrics": func(_ *config.AgentConfig, cc config.CollectorConfig) (agent.Collector, error) {
+		return agent.NewGoMetricsCollector(cc.Include, cc.Exclude), nil
+	},
+	"exec": func(_ *config.AgentConfig, cc config.CollectorConfig) (agent.Collector, error) {
+		commands := make([]agent.ExecCommand, 0, len(cc.Commands))
+		for _, cmd := range cc.Commands {
+			commands = append(commands, agent.ExecCommand{
+				Name:    cmd.Name,
+				Args:    cmd.Command,
+				Timeout: time.Duration(cmd.Timeout),
+			})
+		}
+		return agent.NewExecCollector(commands), nil
+	},
+	"process": func(_ *config.AgentConfig, cc config.CollectorConfig) (agent.Collector, error) {
+		return agent.NewProcessCollector(cc.Root, cc.Processes, cc.Pidfiles), nil
+	},
+	"disk": func(_ *config.AgentConfig, cc config.CollectorConfig) (agent.Collector, error) {
+		return agent.NewDiskCollector(cc.Root, cc.Include, cc.Exclude), nil
+	},
+	"net": func(_ *config.AgentConfig, cc config.CollectorConfig) (agent.Collector, error) {
+		return agent.NewNetCollector(cc.Root, cc.Include, cc.Exclude), nil
+	},
+	"filesystem": func(_ *config.AgentConfig, cc config.CollectorConfig) (agent.Collector, error) {
+		return agent.NewFilesystemCollector(cc.Root, cc.Include, cc.Exclude), nil
+	},
+	"cgroup": func(_ *config.AgentConfig, cc config.CollectorConfig) (agent.Collector, error) {
+		return agent.NewCgroupCollector("", cc.Root), nil
+	},
+	"logtail": func(_ *config.AgentConfig, cc config.CollectorConfig) (agent.Collector, error) {
+		rules := make([]agent.LogRule, 0, len(cc.Rules))
+		for _, rule := range cc.Rules {
+			pattern, err := regexp.Compile(rule.Pattern)
+			if err != nil {
+				return nil, err
+			}
+			rules = append(rules, agent.LogRule{Name: rule.Name, Type: rule.Type, Pattern: pattern})
+		}
+		return agent.NewLogTailCollector(cc.Files, rules, cc.StateFile)
+	},
 }
 
 // собирает метрики
 func (as *AgentService) startCollector() {
-	ticker := time.NewTicker(as.config.PollInterval)
-	defer ticker.Stop()
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-as.doneChan
+		cancel()
+	}()
 
-	for {
-		select {
-		case <-ticker.C:
-			as.collector.Collect()
-		case <-as.doneChan:
-			return
-		}
-	}
+	as.collector.Run(ctx)
 }
 
 // отправка метрик через JSON
@@ -49,18 +115,59 @@ func (as *AgentService) startSender() {
 	for {
 		select {
 		case <-ticker.C:
-			metrics := as.collector.GetMetrics()
-			if err := as.sender.SendJSON(metrics); err != nil {
-				log.Printf("FAIL to send metrics: %v", err)
-			} else {
-				log.Printf("Successfully sent %d metrics with gzip compression", len(metrics))
-			}
+			as.report()
 		case <-as.doneChan:
 			return
 		}
 	}
 }
 
+// передаёт текущие метрики всем получателям
+func (as *AgentService) report() {
+	metrics := as.snapshot()
+	for _, d := range as.destinations {
+		d.submit(metrics)
+	}
+}
+
+// добавляет к метрикам метки агента из конфигурации
+func (as *AgentService) labeled(metrics map[string]model.Metrics) map[string]model.Metrics {
+	if len(as.config.Labels) == 0 {
+		return metrics
+	}
+
+	res := make(map[string]model.Metrics, len(metrics))
+	for _, metric := range metrics {
+		metric = metric.WithLabels(as.config.Labels)
+		res[metric.ID] = metric
+	}
+	return res
+}
+
+// self-метрики очередей получателей
+func (as *AgentService) addOutboxMetrics(metrics map[string]model.Metrics) {
+	p := as.config.SelfMetricsPrefix
+	if p == "" {
+		return
+	}
+	for _, d := range as.destinations {
+		if d.outbox == nil {
+			continue
+		}
+		stats := d.outbox.Stats()
+		gauges := map[string]float64{
+			p + "OutboxDepth":   float64(stats.Depth),
+			p + "OutboxBytes":   float64(stats.Bytes),
+			p + "OutboxEvicted": float64(stats.Evicted),
+		}
+		for name, value := range gauges {
+			v := value
+			id := model.LabeledID(name, map[string]string{"destination": d.name})
+			metrics[id] = model.Metrics{ID: id, MType: model.Gauge, Value: &v}
+		}
+	}
+}
+
 func (as *AgentService) Stop() {
 	close(as.doneChan)
 	as.wg.Wait()
@@ -82,5 +189,23 @@ func (as *AgentService) Run() {
 		as.startSender()
 	}()
 
+	// у каждого получателя своя горутина отправки
+	for _, d := range as.destinations {
+		as.wg.Add(1)
+		go func() {
+			defer as.wg.Done()
+			as.runDestination(d)
+		}()
+	}
+
+	// локальный эндпоинт для просмотра и сбора метрик по запросу
+	if as.config.Listen != "" {
+		as.wg.Add(1)
+		go func() {
+			defer as.wg.Done()
+			as.startListener()
+		}()
+	}
+
 	<-as.doneChan
 }
@@ -0,0 +1,253 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/shatrunoff/yap_metrics/internal/agent"
+	"github.com/shatrunoff/yap_metrics/internal/config"
+	"github.com/shatrunoff/yap_metrics/internal/envelope"
+	"github.com/shatrunoff/yap_metrics/internal/model"
+	"github.com/shatrunoff/yap_metrics/internal/tlsutil"
+)
+
+// получатель метрик: свой отправитель, очередь, политика повторов и
+// горутина отправки, чтобы недоступный сервер не задерживал остальные
+type destination struct {
+	name      string
+	address   string
+	transport string
+	sender    *agent.Sender
+	outbox    *agent.Outbox
+	retry     config.RetryConfig
+	status    sendStatus
+	// принятые сервером (или сохранённые в очередь) части счётчиков
+	tracker *agent.DeltaTracker
+
+	// снимок, ещё не взятый в отправку, и сигнал о его появлении
+	mu      sync.Mutex
+	pending map[string]model.Metrics
+	ready   chan struct{}
+}
+
+// создаёт получателя; outboxDir — каталог его очереди, пустой — без очереди
+func newDestination(dc config.DestinationConfig, outboxDir string, outboxMaxBytes int64) (*destination, error) {
+	sender := agent.NewSender(dc.Address)
+	sender.Token = dc.Token
+
+	// адрес для проверки доверенной подсети на сервере
+	if realIP, err := agent.OutboundIP(dc.Address); err != nil {
+		log.Printf("WARNING: destination %s: failed to detect outbound IP: %v", dc.Name, err)
+	} else {
+		sender.RealIP = realIP
+	}
+
+	if dc.TLSCA != "" || dc.TLSCert != "" || dc.TLSKey != "" {
+		tlsConfig, err := tlsutil.ClientConfig(dc.TLSCA, dc.TLSCert, dc.TLSKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to configure TLS: %w", err)
+		}
+		sender.UseTLS(tlsConfig)
+	}
+
+	if dc.CryptoKey != "" {
+		key, err := envelope.LoadPublicKey(dc.CryptoKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load crypto key: %w", err)
+		}
+		sender.PublicKey = key
+	}
+
+	// очередь неотправленных пачек на диске
+	var outbox *agent.Outbox
+	if outboxDir != "" {
+		var err error
+		if outbox, err = agent.OpenOutbox(outboxDir, outboxMaxBytes); err != nil {
+			return nil, fmt.Errorf("failed to open outbox: %w", err)
+		}
+		if stats := outbox.Stats(); stats.Depth > 0 {
+			log.Printf("Destination %s: outbox has %d pending batches (%d bytes)", dc.Name, stats.Depth, stats.Bytes)
+		}
+	}
+
+	transport := dc.Transport
+	if transport == "" {
+		transport = config.TransportJSON
+	}
+	return &destination{
+		name:      dc.Name,
+		address:   dc.Address,
+		transport: transport,
+		sender:    sender,
+		outbox:    outbox,
+		retry:     dc.Retry,
+		tracker:   agent.NewDeltaTracker(),
+		ready:     make(chan struct{}, 1),
+	}, nil
+}
+
+// получатели из конфигурации; у единственного получателя по умолчанию
+// очередь лежит прямо в OutboxDir, у остальных — в подкаталоге с их именем
+func newDestinations(cfg *config.AgentConfig) ([]*destination, error) {
+	var dests []*destination
+	for _, dc := range cfg.DestinationList() {
+		dir := cfg.OutboxDir
+		if dir != "" && len(cfg.Destinations) > 0 {
+			dir = filepath.Join(dir, dc.Name)
+		}
+		dest, err := newDestination(dc, dir, cfg.OutboxMaxBytes)
+		if err != nil {
+			return nil, fmt.Errorf("destination %s: %w", dc.Name, err)
+		}
+		dests = append(dests, dest)
+	}
+	return dests, nil
+}
+
+// передаёт снимок в отправку, не дожидаясь её; счётчики в снимке
+// накопленные, поэтому снимок новее полностью заменяет ещё не взятый в отправку
+func (d *destination) submit(metrics map[string]model.Metrics) {
+	d.mu.Lock()
+	d.pending = metrics
+	d.mu.Unlock()
+
+	select {
+	case d.ready <- struct{}{}:
+	default:
+	}
+}
+
+// забирает снимок для отправки
+func (d *destination) take() map[string]model.Metrics {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	metrics := d.pending
+	d.pending = nil
+	return metrics
+}
+
+// отправляет пачку выбранным способом
+func (d *destination) send(metrics map[string]model.Metrics) error {
+	switch d.transport {
+	case config.TransportPlain:
+		return d.sender.Send(metrics)
+	case config.TransportBatch:
+		return d.sender.SendBatch(metrics)
+	default:
+		return d.sender.SendJSON(metrics)
+	}
+}
+
+// горутина отправки одного получателя
+func (as *AgentService) runDestination(d *destination) {
+	for {
+		select {
+		case <-d.ready:
+			if metrics := d.take(); metrics != nil {
+				as.deliver(d, metrics)
+			}
+		case <-as.doneChan:
+			return
+		}
+	}
+}
+
+// отправляет накопленную очередь и приращения снимка
+func (as *AgentService) deliver(d *destination, snapshot map[string]model.Metrics) {
+	batch := d.tracker.Pending(snapshot)
+
+	// пока очередь не пуста, новые пачки встают в её конец, чтобы сохранить порядок
+	if d.outbox != nil {
+		if err := as.drainOutbox(d); err != nil {
+			d.status.record(err)
+			log.Printf("FAIL to replay outbox of %s: %v", d.name, err)
+			as.enqueue(d, batch)
+			return
+		}
+	}
+
+	unsent, err := as.sendWithRetry(d, batch)
+	d.tracker.Ack(batch, unsent)
+	d.status.record(err)
+	if err != nil {
+		log.Printf("FAIL to send metrics to %s: %v", d.name, err)
+		if d.outbox != nil {
+			as.enqueue(d, unsent)
+		}
+		return
+	}
+	log.Printf("Successfully sent %d metrics to %s", len(batch), d.name)
+}
+
+// отправка с повторами по политике получателя; повторяются только метрики,
+// которые сервер ещё не принял. Возвращает их вместе с последней ошибкой;
+// повторы прерываются остановкой агента.
+func (as *AgentService) sendWithRetry(d *destination, metrics map[string]model.Metrics) (map[string]model.Metrics, error) {
+	attempts := max(d.retry.Attempts, 1)
+	backoff := time.Duration(d.retry.Backoff)
+
+	for attempt := 1; ; attempt++ {
+		err := as.send(d, metrics)
+		if metrics = agent.Unsent(metrics, err); err == nil || attempt >= attempts {
+			return metrics, err
+		}
+		log.Printf("WARNING: send to %s failed (attempt %d of %d), retrying in %v: %v", d.name, attempt, attempts, backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-as.doneChan:
+			return metrics, err
+		}
+		backoff *= 2
+		if limit := time.Duration(d.retry.MaxBackoff); limit > 0 && backoff > limit {
+			backoff = limit
+		}
+	}
+}
+
+// отправляет пачки из очереди по порядку, останавливаясь на первой ошибке;
+// принятая сервером часть пачки из очереди убирается
+func (as *AgentService) drainOutbox(d *destination) error {
+	for {
+		batch, ok, err := d.outbox.Peek()
+		if err != nil {
+			// испорченный сегмент не должен блокировать очередь
+			log.Printf("WARNING: dropping unreadable outbox batch of %s: %v", d.name, err)
+			if err := d.outbox.Pop(); err != nil {
+				return err
+			}
+			continue
+		}
+		if !ok {
+			return nil
+		}
+
+		if unsent, err := as.sendWithRetry(d, batch); err != nil {
+			if len(unsent) < len(batch) {
+				if err := d.outbox.ReplaceHead(unsent); err != nil {
+					log.Printf("ERROR: failed to update outbox of %s, accepted metrics may be sent twice: %v", d.name, err)
+				}
+			}
+			return err
+		}
+		if err := d.outbox.Pop(); err != nil {
+			return err
+		}
+		log.Printf("Replayed outbox batch of %d metrics to %s", len(batch), d.name)
+	}
+}
+
+// сохраняет неотправленную пачку в очередь; приращения из очереди
+// считаются переданными, иначе они остаются до следующей отправки
+func (as *AgentService) enqueue(d *destination, metrics map[string]model.Metrics) {
+	if err := d.outbox.Push(metrics); err != nil {
+		log.Printf("ERROR: failed to queue metrics for %s, counters are kept for the next report: %v", d.name, err)
+		as.recordDropped(d, "queue", len(metrics))
+		return
+	}
+	d.tracker.Ack(metrics, nil)
+	stats := d.outbox.Stats()
+	log.Printf("Queued %d metrics for %s, outbox depth %d (%d bytes)", len(metrics), d.name, stats.Depth, stats.Bytes)
+}
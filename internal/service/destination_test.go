@@ -0,0 +1,154 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shatrunoff/yap_metrics/internal/agent"
+	"github.com/shatrunoff/yap_metrics/internal/config"
+	"github.com/shatrunoff/yap_metrics/internal/model"
+)
+
+func newTestAgent(t *testing.T, dcs ...config.DestinationConfig) *AgentService {
+	t.Helper()
+	as := &AgentService{
+		collector: agent.NewMetricsCollector(),
+		config:    &config.AgentConfig{},
+		doneChan:  make(chan struct{}),
+	}
+	for _, dc := range dcs {
+		d, err := newDestination(dc, "", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		as.destinations = append(as.destinations, d)
+	}
+	return as
+}
+
+func testMetrics() map[string]model.Metrics {
+	value := 1.5
+	return map[string]model.Metrics{"Alloc": {ID: "Alloc", MType: model.Gauge, Value: &value}}
+}
+
+func TestFailingDestinationDoesNotBlockOthers(t *testing.T) {
+	// недоступный сервер, не отвечающий до конца теста
+	release := make(chan struct{})
+	stuck := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer stuck.Close()
+	defer close(release)
+
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer healthy.Close()
+
+	as := newTestAgent(t,
+		config.DestinationConfig{Name: "stuck", Address: stuck.URL},
+		config.DestinationConfig{Name: "healthy", Address: healthy.URL},
+	)
+	defer as.Stop()
+	for _, d := range as.destinations {
+		go as.runDestination(d)
+		d.submit(testMetrics())
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for as.destinations[1].Status().LastSuccess == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("healthy destination did not receive metrics while the other one hangs")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if status := as.destinations[0].Status(); status.LastSuccess != nil || status.LastError != "" {
+		t.Errorf("stuck destination status = %+v, want no result yet", status)
+	}
+}
+
+func TestSendWithRetry(t *testing.T) {
+	// сервер отвечает ошибкой на первые failures запросов
+	newServer := func(failures int) (*httptest.Server, func() []time.Time) {
+		var mu sync.Mutex
+		var requests []time.Time
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+			requests = append(requests, time.Now())
+			if len(requests) <= failures {
+				http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			}
+		}))
+		return server, func() []time.Time {
+			mu.Lock()
+			defer mu.Unlock()
+			return requests
+		}
+	}
+
+	t.Run("Retries with growing backoff", func(t *testing.T) {
+		server, requests := newServer(2)
+		defer server.Close()
+		retry := config.RetryConfig{
+			Attempts:   3,
+			Backoff:    config.Duration(50 * time.Millisecond),
+			MaxBackoff: config.Duration(80 * time.Millisecond),
+		}
+		as := newTestAgent(t, config.DestinationConfig{Name: "default", Address: server.URL, Retry: retry})
+
+		unsent, err := as.sendWithRetry(as.destinations[0], testMetrics())
+		if err != nil || len(unsent) != 0 {
+			t.Fatalf("sendWithRetry() = %v, %v, want all sent", unsent, err)
+		}
+
+		times := requests()
+		if len(times) != 3 {
+			t.Fatalf("got %d requests, want 3", len(times))
+		}
+		// пауза удваивается, но не больше MaxBackoff
+		for i, want := range []time.Duration{50 * time.Millisecond, 80 * time.Millisecond} {
+			if gap := times[i+1].Sub(times[i]); gap < want {
+				t.Errorf("pause before retry %d = %v, want at least %v", i+1, gap, want)
+			}
+		}
+	})
+
+	t.Run("Gives up after attempts", func(t *testing.T) {
+		server, requests := newServer(10)
+		defer server.Close()
+		retry := config.RetryConfig{Attempts: 2, Backoff: config.Duration(time.Millisecond)}
+		as := newTestAgent(t, config.DestinationConfig{Name: "default", Address: server.URL, Retry: retry})
+
+		unsent, err := as.sendWithRetry(as.destinations[0], testMetrics())
+		if err == nil || len(unsent) != 1 {
+			t.Errorf("sendWithRetry() = %v, %v, want error and unsent metric", unsent, err)
+		}
+		if got := len(requests()); got != 2 {
+			t.Errorf("got %d requests, want 2", got)
+		}
+	})
+
+	t.Run("Stop interrupts backoff", func(t *testing.T) {
+		server, _ := newServer(10)
+		defer server.Close()
+		retry := config.RetryConfig{Attempts: 5, Backoff: config.Duration(time.Hour)}
+		as := newTestAgent(t, config.DestinationConfig{Name: "default", Address: server.URL, Retry: retry})
+
+		time.AfterFunc(50*time.Millisecond, as.Stop)
+		done := make(chan error, 1)
+		go func() {
+			_, err := as.sendWithRetry(as.destinations[0], testMetrics())
+			done <- err
+		}()
+		select {
+		case err := <-done:
+			if err == nil {
+				t.Error("sendWithRetry() error = nil after stop")
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("sendWithRetry() did not return after stop")
+		}
+	})
+}
@@ -4,7 +4,11 @@ import (
 	"context"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/shatrunoff/yap_metrics/internal/selfmetrics"
+	"github.com/shatrunoff/yap_metrics/internal/storage"
 )
 
 // сохранение метрик в файл
@@ -16,13 +20,28 @@ type Saver interface {
 type FileStorageService struct {
 	storage       Saver
 	filePath      string
-	storeInterval time.Duration
+	storeInterval atomic.Int64
+
+	// сигнал о смене интервала для фоновой горутины
+	intervalCh chan struct{}
 
 	ctx    context.Context
 	cancel context.CancelFunc
 
 	wg    sync.WaitGroup
 	errCh chan error
+
+	metrics *selfmetrics.Registry
+}
+
+// дополнительная настройка сервиса сохранения
+type FileStorageOption func(fss *FileStorageService)
+
+// включает метрики сохранения снимков: длительность, размер, ошибки
+func WithSaveMetrics(reg *selfmetrics.Registry) FileStorageOption {
+	return func(fss *FileStorageService) {
+		fss.metrics = reg
+	}
 }
 
 // создаёт сервис работы с файлами
@@ -30,24 +49,26 @@ func NewFileStorageService(
 	storage Saver,
 	filePath string,
 	storeInterval time.Duration,
+	opts ...FileStorageOption,
 ) *FileStorageService {
 	ctx, cancel := context.WithCancel(context.Background())
-	return &FileStorageService{
-		storage:       storage,
-		filePath:      filePath,
-		storeInterval: storeInterval,
-		ctx:           ctx,
-		cancel:        cancel,
-		errCh:         make(chan error, 1),
+	fss := &FileStorageService{
+		storage:    storage,
+		filePath:   filePath,
+		intervalCh: make(chan struct{}, 1),
+		ctx:        ctx,
+		cancel:     cancel,
+		errCh:      make(chan error, 1),
+	}
+	fss.storeInterval.Store(int64(storeInterval))
+	for _, opt := range opts {
+		opt(fss)
 	}
+	return fss
 }
 
-// запускает периодическое сохранение
+// запускает периодическое сохранение (при нулевом интервале — только при остановке)
 func (fss *FileStorageService) Start() {
-	if fss.storeInterval <= 0 {
-		return
-	}
-
 	fss.wg.Add(1)
 	go func() {
 		defer fss.wg.Done()
@@ -56,31 +77,71 @@ func (fss *FileStorageService) Start() {
 }
 
 func (fss *FileStorageService) startPeriodicSave() {
-	ticker := time.NewTicker(fss.storeInterval)
-	defer ticker.Stop()
+	var ticker *time.Ticker
+	var tick <-chan time.Time
+
+	resetTicker := func() {
+		if ticker != nil {
+			ticker.Stop()
+			ticker, tick = nil, nil
+		}
+		if interval := fss.StoreInterval(); interval > 0 {
+			ticker = time.NewTicker(interval)
+			tick = ticker.C
+		}
+	}
+	resetTicker()
+	defer func() {
+		if ticker != nil {
+			ticker.Stop()
+		}
+	}()
 
 	for {
 		select {
-		case <-ticker.C:
-			if err := fss.storage.SaveToFile(fss.filePath); err != nil {
-				select {
-				case fss.errCh <- fmt.Errorf("periodic save failed: %w", err):
-				default:
-				}
+		case <-tick:
+			if err := fss.save(); err != nil {
+				fss.reportErr(fmt.Errorf("periodic save failed: %w", err))
 			}
 
+		case <-fss.intervalCh:
+			resetTicker()
+
 		case <-fss.ctx.Done():
-			if err := fss.storage.SaveToFile(fss.filePath); err != nil {
-				select {
-				case fss.errCh <- fmt.Errorf("shutdown save failed: %w", err):
-				default:
-				}
+			if err := fss.save(); err != nil {
+				fss.reportErr(fmt.Errorf("shutdown save failed: %w", err))
 			}
 			return
 		}
 	}
 }
 
+func (fss *FileStorageService) reportErr(err error) {
+	select {
+	case fss.errCh <- err:
+	default:
+	}
+}
+
+// текущий интервал сохранения
+func (fss *FileStorageService) StoreInterval() time.Duration {
+	return time.Duration(fss.storeInterval.Load())
+}
+
+// меняет интервал сохранения на лету; 0 включает синхронное сохранение
+func (fss *FileStorageService) SetStoreInterval(interval time.Duration) {
+	fss.storeInterval.Store(int64(interval))
+	select {
+	case fss.intervalCh <- struct{}{}:
+	default:
+	}
+}
+
+// нужно ли сохранять метрики при каждом обновлении
+func (fss *FileStorageService) Synchronous() bool {
+	return fss.StoreInterval() == 0
+}
+
 // завершает работу сервиса
 func (fss *FileStorageService) Stop() {
 	fss.cancel()
@@ -95,5 +156,20 @@ func (fss *FileStorageService) Err() <-chan error {
 
 // выполняет синхронное сохранение
 func (fss *FileStorageService) SaveSync() error {
-	return fss.storage.SaveToFile(fss.filePath)
+	return fss.save()
+}
+
+// сохраняет снимок и учитывает его в метриках
+func (fss *FileStorageService) save() error {
+	start := time.Now()
+	err := fss.storage.SaveToFile(fss.filePath)
+	fss.metrics.Observe("snapshot_save_duration_seconds", nil, time.Since(start))
+	if err != nil {
+		fss.metrics.Add("snapshot_save_errors_total", nil, 1)
+		return err
+	}
+	if fss.metrics != nil {
+		fss.metrics.Set("snapshot_size_bytes", nil, float64(storage.SnapshotSize(fss.filePath)))
+	}
+	return nil
 }
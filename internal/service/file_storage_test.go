@@ -0,0 +1,59 @@
+package service
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// считает сохранения
+type countingSaver struct {
+	saves atomic.Int64
+}
+
+func (s *countingSaver) SaveToFile(string) error {
+	s.saves.Add(1)
+	return nil
+}
+
+func TestFileStorageServiceSetStoreInterval(t *testing.T) {
+	saver := &countingSaver{}
+	fss := NewFileStorageService(saver, "metrics.json", 0)
+	if !fss.Synchronous() {
+		t.Fatal("zero interval must enable synchronous saving")
+	}
+	fss.Start()
+
+	// без интервала периодических сохранений нет
+	time.Sleep(30 * time.Millisecond)
+	if n := saver.saves.Load(); n != 0 {
+		t.Fatalf("saves with zero interval = %d, want 0", n)
+	}
+
+	fss.SetStoreInterval(5 * time.Millisecond)
+	if fss.Synchronous() || fss.StoreInterval() != 5*time.Millisecond {
+		t.Fatalf("StoreInterval() = %v, Synchronous() = %v after change", fss.StoreInterval(), fss.Synchronous())
+	}
+	deadline := time.Now().Add(time.Second)
+	for saver.saves.Load() < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := saver.saves.Load(); n < 2 {
+		t.Fatalf("periodic saves after SetStoreInterval = %d, want at least 2", n)
+	}
+
+	// обратно в синхронный режим: периодические сохранения прекращаются
+	fss.SetStoreInterval(0)
+	time.Sleep(20 * time.Millisecond)
+	before := saver.saves.Load()
+	time.Sleep(30 * time.Millisecond)
+	if n := saver.saves.Load(); n != before {
+		t.Errorf("periodic saves continued after interval reset to 0: %d -> %d", before, n)
+	}
+
+	// при остановке снимок сохраняется
+	fss.Stop()
+	if n := saver.saves.Load(); n != before+1 {
+		t.Errorf("saves after Stop() = %d, want %d", n, before+1)
+	}
+}
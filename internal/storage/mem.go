@@ -6,18 +6,23 @@ import (
 	"maps"
 	"os"
 	"sync"
+	"time"
 
 	"github.com/shatrunoff/yap_metrics/internal/model"
 )
 
 type MemStorage struct {
 	metrics map[string]model.Metrics
+	rates   map[string]*rateState
+	now     func() time.Time
 	mu      sync.RWMutex
 }
 
 func NewMemStorage() *MemStorage {
 	return &MemStorage{
 		metrics: make(map[string]model.Metrics),
+		rates:   make(map[string]*rateState),
+		now:     time.Now,
 	}
 }
 
@@ -81,11 +86,7 @@ func (m *MemStorage) UpdateGauge(name string, value float64) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	m.metrics[name] = model.Metrics{
-		ID:    name,
-		MType: model.Gauge,
-		Value: &value,
-	}
+	m.updateGauge(name, value)
 }
 
 // обновление счетчика
@@ -93,6 +94,47 @@ func (m *MemStorage) UpdateCounter(name string, delta int64) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	m.updateCounter(name, delta)
+}
+
+// применяет пачку обновлений под одной блокировкой; если с новыми метриками
+// их станет больше limit (0 — без ограничения), не меняет ничего
+func (m *MemStorage) Update(metrics []model.Metrics, limit int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if limit > 0 {
+		added := make(map[string]struct{})
+		for _, metric := range metrics {
+			if _, ok := m.metrics[metric.ID]; !ok {
+				added[metric.ID] = struct{}{}
+			}
+		}
+		if len(added) > 0 && len(m.metrics)+len(added) > limit {
+			return ErrTooManyMetrics
+		}
+	}
+
+	for _, metric := range metrics {
+		switch metric.MType {
+		case model.Gauge:
+			m.updateGauge(metric.ID, *metric.Value)
+		case model.Counter:
+			m.updateCounter(metric.ID, *metric.Delta)
+		}
+	}
+	return nil
+}
+
+func (m *MemStorage) updateGauge(name string, value float64) {
+	m.metrics[name] = model.Metrics{
+		ID:    name,
+		MType: model.Gauge,
+		Value: &value,
+	}
+}
+
+func (m *MemStorage) updateCounter(name string, delta int64) {
 	exist, ok := m.metrics[name]
 	if ok && exist.Delta != nil {
 		*exist.Delta += delta
@@ -104,6 +146,28 @@ func (m *MemStorage) UpdateCounter(name string, delta int64) {
 			Delta: &delta,
 		}
 	}
+
+	// скорость изменения счётчика
+	state, ok := m.rates[name]
+	if !ok {
+		state = &rateState{}
+		m.rates[name] = state
+	}
+	state.observe(delta, m.now())
+}
+
+// удаление метрики
+func (m *MemStorage) Delete(metricType, name string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	metric, ok := m.metrics[name]
+	if !ok || metric.MType != metricType {
+		return false
+	}
+	delete(m.metrics, name)
+	delete(m.rates, name)
+	return true
 }
 
 // получение 1й метрики
@@ -127,3 +191,44 @@ func (m *MemStorage) GetAll() map[string]model.Metrics {
 	maps.Copy(res, m.metrics)
 	return res
 }
+
+// количество хранимых метрик
+func (m *MemStorage) Len() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.metrics)
+}
+
+// получение скорости изменения счётчика
+func (m *MemStorage) GetRate(name string) (model.Rate, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	metric, ok := m.metrics[name]
+	if !ok || metric.MType != model.Counter {
+		return model.Rate{}, false
+	}
+
+	state, ok := m.rates[name]
+	if !ok {
+		// счётчик восстановлен из файла и ещё не обновлялся
+		return model.Rate{ID: name}, true
+	}
+	return state.snapshot(name, m.now()), true
+}
+
+// получение скоростей всех счётчиков
+func (m *MemStorage) GetRates() map[string]model.Rate {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	now := m.now()
+	res := make(map[string]model.Rate, len(m.rates))
+	for name, state := range m.rates {
+		if metric, ok := m.metrics[name]; !ok || metric.MType != model.Counter {
+			continue
+		}
+		res[name] = state.snapshot(name, now)
+	}
+	return res
+}
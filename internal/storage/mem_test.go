@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/shatrunoff/yap_metrics/internal/model"
+)
+
+func gaugeMetric(id string, value float64) model.Metrics {
+	return model.Metrics{ID: id, MType: model.Gauge, Value: &value}
+}
+
+func counterMetric(id string, delta int64) model.Metrics {
+	return model.Metrics{ID: id, MType: model.Counter, Delta: &delta}
+}
+
+func TestMemStorageUpdate(t *testing.T) {
+	t.Run("Batch is applied", func(t *testing.T) {
+		st := NewMemStorage()
+		metrics := []model.Metrics{gaugeMetric("Alloc", 1.5), counterMetric("PollCount", 2), counterMetric("PollCount", 3)}
+		if err := st.Update(metrics, 0); err != nil {
+			t.Fatalf("Update() error = %v", err)
+		}
+		if m, ok := st.GetMetric(model.Gauge, "Alloc"); !ok || *m.Value != 1.5 {
+			t.Errorf("Alloc = %+v, want 1.5", m)
+		}
+		if m, ok := st.GetMetric(model.Counter, "PollCount"); !ok || *m.Delta != 5 {
+			t.Errorf("PollCount = %+v, want 5", m)
+		}
+	})
+
+	t.Run("Batch over limit is rejected whole", func(t *testing.T) {
+		st := NewMemStorage()
+		st.UpdateGauge("a", 1)
+		err := st.Update([]model.Metrics{gaugeMetric("a", 2), gaugeMetric("b", 1), gaugeMetric("c", 1)}, 2)
+		if !errors.Is(err, ErrTooManyMetrics) {
+			t.Fatalf("Update() error = %v, want %v", err, ErrTooManyMetrics)
+		}
+		if m, _ := st.GetMetric(model.Gauge, "a"); *m.Value != 1 {
+			t.Errorf("a = %v after rejected batch, want 1", *m.Value)
+		}
+		if _, ok := st.GetMetric(model.Gauge, "b"); ok {
+			t.Error("b stored from rejected batch")
+		}
+	})
+
+	t.Run("Limit holds under concurrent writers", func(t *testing.T) {
+		const limit = 10
+		st := NewMemStorage()
+
+		var wg sync.WaitGroup
+		for i := 0; i < 100; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_ = st.Update([]model.Metrics{gaugeMetric(fmt.Sprintf("m%d", i), 1)}, limit)
+			}()
+		}
+		wg.Wait()
+
+		if got := len(st.GetAll()); got != limit {
+			t.Errorf("stored %d metrics, want %d", got, limit)
+		}
+	})
+}
@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"math"
+	"time"
+
+	"github.com/shatrunoff/yap_metrics/internal/model"
+)
+
+// окна усреднения, по аналогии с load average в Unix
+var rateWindows = [3]time.Duration{time.Minute, 5 * time.Minute, 15 * time.Minute}
+
+// экспоненциально сглаженная скорость одного счётчика
+type rateState struct {
+	last    time.Time
+	pending int64
+	rates   [3]float64
+}
+
+// учитывает приращение счётчика в момент now
+func (s *rateState) observe(delta int64, now time.Time) {
+	if s.last.IsZero() {
+		s.last = now
+		return
+	}
+
+	dt := now.Sub(s.last)
+	if dt <= 0 {
+		// несколько обновлений в один момент времени копим до следующего
+		s.pending += delta
+		return
+	}
+
+	instant := float64(s.pending+delta) / dt.Seconds()
+	for i, window := range rateWindows {
+		alpha := math.Exp(-dt.Seconds() / window.Seconds())
+		s.rates[i] = s.rates[i]*alpha + instant*(1-alpha)
+	}
+	s.pending = 0
+	s.last = now
+}
+
+// текущее значение с учётом затухания за время простоя
+func (s *rateState) snapshot(name string, now time.Time) model.Rate {
+	idle := now.Sub(s.last).Seconds()
+	if idle < 0 {
+		idle = 0
+	}
+
+	var decayed [3]float64
+	for i, window := range rateWindows {
+		decayed[i] = s.rates[i] * math.Exp(-idle/window.Seconds())
+	}
+
+	return model.Rate{
+		ID:      name,
+		Rate1m:  decayed[0],
+		Rate5m:  decayed[1],
+		Rate15m: decayed[2],
+	}
+}
@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestMemStorageGetRate(t *testing.T) {
+	tests := []struct {
+		name    string
+		delta   int64
+		step    time.Duration
+		updates int
+		idle    time.Duration
+		want1m  float64
+	}{
+		{
+			name:    "Steady rate converges",
+			delta:   2,
+			step:    time.Second,
+			updates: 3600,
+			want1m:  2,
+		},
+		{
+			name:    "Rate decays while idle",
+			delta:   2,
+			step:    time.Second,
+			updates: 3600,
+			idle:    time.Minute,
+			want1m:  2 * math.Exp(-1),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Unix(0, 0)
+			m := NewMemStorage()
+			m.now = func() time.Time { return now }
+
+			for i := 0; i < tt.updates; i++ {
+				m.UpdateCounter("PollCount", tt.delta)
+				now = now.Add(tt.step)
+			}
+			now = now.Add(tt.idle - tt.step)
+
+			got, ok := m.GetRate("PollCount")
+			if !ok {
+				t.Fatalf("GetRate() returned no rate")
+			}
+			if math.Abs(got.Rate1m-tt.want1m) > 1e-6 {
+				t.Errorf("GetRate().Rate1m = %v, want %v", got.Rate1m, tt.want1m)
+			}
+			if got.Rate15m > float64(tt.delta) {
+				t.Errorf("GetRate().Rate15m = %v, must not exceed %v", got.Rate15m, tt.delta)
+			}
+		})
+	}
+
+	t.Run("Unknown counter", func(t *testing.T) {
+		m := NewMemStorage()
+		m.UpdateGauge("Alloc", 1)
+		if _, ok := m.GetRate("Alloc"); ok {
+			t.Errorf("GetRate() for gauge must return false")
+		}
+	})
+}
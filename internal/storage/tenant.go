@@ -0,0 +1,171 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/shatrunoff/yap_metrics/internal/ratelimit"
+	"github.com/shatrunoff/yap_metrics/internal/tenant"
+)
+
+var (
+	ErrTooManyMetrics = errors.New("tenant metric count quota exceeded")
+	ErrTooManyUpdates = errors.New("tenant update rate quota exceeded")
+)
+
+// хранилище, изолирующее метрики арендаторов друг от друга
+type TenantStorage struct {
+	tenants map[string]*MemStorage
+	limits  map[string]*ratelimit.Bucket
+	quota   func(id string) tenant.Quota
+	mu      sync.RWMutex
+}
+
+// defaultStorage становится хранилищем арендатора по умолчанию
+func NewTenantStorage(defaultStorage *MemStorage, quota func(id string) tenant.Quota) *TenantStorage {
+	return &TenantStorage{
+		tenants: map[string]*MemStorage{tenant.Default: defaultStorage},
+		limits:  make(map[string]*ratelimit.Bucket),
+		quota:   quota,
+	}
+}
+
+// хранилище арендатора, создаётся при первом обращении
+func (ts *TenantStorage) Tenant(id string) *MemStorage {
+	ts.mu.RLock()
+	st, ok := ts.tenants[id]
+	ts.mu.RUnlock()
+	if ok {
+		return st
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if st, ok := ts.tenants[id]; ok {
+		return st
+	}
+	st = NewMemStorage()
+	ts.tenants[id] = st
+	return st
+}
+
+// список арендаторов, у которых есть хранилище
+func (ts *TenantStorage) Tenants() []string {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+
+	ids := make([]string, 0, len(ts.tenants))
+	for id := range ts.tenants {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// проверяет квоту арендатора на частоту обновлений для пачки из n метрик:
+// пачка списывается целиком или не списывается вовсе
+func (ts *TenantStorage) CheckUpdate(id string, n int) error {
+	quota := ts.quota(id)
+
+	if quota.MaxUpdatesPerSec > 0 && !ts.limiter(id, quota).AllowN(n) {
+		return ErrTooManyUpdates
+	}
+	return nil
+}
+
+// квота арендатора на число метрик, 0 — без ограничения; соблюдается
+// атомарно при обновлении через MemStorage.Update
+func (ts *TenantStorage) MaxMetrics(id string) int {
+	return ts.quota(id).MaxMetrics
+}
+
+func (ts *TenantStorage) limiter(id string, quota tenant.Quota) *ratelimit.Bucket {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	bucket, ok := ts.limits[id]
+	if !ok {
+		bucket = ratelimit.NewBucket(quota.MaxUpdatesPerSec, int(math.Ceil(quota.MaxUpdatesPerSec)))
+		ts.limits[id] = bucket
+	}
+	return bucket
+}
+
+// путь к снимку арендатора: для арендатора по умолчанию — исходный путь,
+// для остальных — metrics.json -> metrics.<id>.json
+func TenantFilePath(path, id string) string {
+	if id == tenant.Default {
+		return path
+	}
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "." + id + ext
+}
+
+// сохраняет снимки всех арендаторов
+func (ts *TenantStorage) SaveToFile(path string) error {
+	var errs []error
+	for _, id := range ts.Tenants() {
+		if err := ts.Tenant(id).SaveToFile(TenantFilePath(path, id)); err != nil {
+			errs = append(errs, fmt.Errorf("tenant %s: %w", id, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// снимки арендаторов, кроме арендатора по умолчанию, найденные рядом с path: id -> файл
+func tenantFiles(path string) (map[string]string, error) {
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	files, err := filepath.Glob(base + ".*" + ext)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make(map[string]string, len(files))
+	for _, file := range files {
+		id := strings.TrimSuffix(strings.TrimPrefix(file, base+"."), ext)
+		if tenant.ValidID(id) {
+			res[id] = file
+		}
+	}
+	return res, nil
+}
+
+// загружает снимки всех арендаторов, найденные рядом с path
+func (ts *TenantStorage) LoadFromFile(path string) error {
+	files, err := tenantFiles(path)
+	if err != nil {
+		return err
+	}
+
+	errs := []error{ts.Tenant(tenant.Default).LoadFromFile(path)}
+	for id, file := range files {
+		if err := ts.Tenant(id).LoadFromFile(file); err != nil {
+			errs = append(errs, fmt.Errorf("tenant %s: %w", id, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// суммарный размер снимков всех арендаторов в байтах
+func SnapshotSize(path string) int64 {
+	files, err := tenantFiles(path)
+	if err != nil {
+		files = make(map[string]string)
+	}
+	files[tenant.Default] = path
+
+	var size int64
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil {
+			size += info.Size()
+		}
+	}
+	return size
+}
@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/shatrunoff/yap_metrics/internal/model"
+	"github.com/shatrunoff/yap_metrics/internal/tenant"
+)
+
+func TestTenantStorageIsolation(t *testing.T) {
+	ts := NewTenantStorage(NewMemStorage(), nil)
+	ts.Tenant("team-a").UpdateGauge("Alloc", 1)
+	ts.Tenant(tenant.Default).UpdateGauge("Alloc", 2)
+
+	if _, ok := ts.Tenant("team-b").GetMetric(model.Gauge, "Alloc"); ok {
+		t.Error("team-b sees metric of team-a")
+	}
+	if m, _ := ts.Tenant("team-a").GetMetric(model.Gauge, "Alloc"); *m.Value != 1 {
+		t.Errorf("team-a Alloc = %v, want 1", *m.Value)
+	}
+}
+
+func TestTenantStorageCheckUpdate(t *testing.T) {
+	quotas := map[string]tenant.Quota{
+		"small": {MaxMetrics: 2},
+		"slow":  {MaxUpdatesPerSec: 1},
+	}
+	ts := NewTenantStorage(NewMemStorage(), func(id string) tenant.Quota { return quotas[id] })
+
+	t.Run("Metric count", func(t *testing.T) {
+		st := ts.Tenant("small")
+		limit := ts.MaxMetrics("small")
+		if err := st.Update([]model.Metrics{gaugeMetric("a", 1), gaugeMetric("b", 1)}, limit); err != nil {
+			t.Fatalf("Update() error = %v", err)
+		}
+		if err := st.Update([]model.Metrics{gaugeMetric("c", 1)}, limit); !errors.Is(err, ErrTooManyMetrics) {
+			t.Errorf("Update(new metric) error = %v, want %v", err, ErrTooManyMetrics)
+		}
+		if err := st.Update([]model.Metrics{gaugeMetric("a", 2)}, limit); err != nil {
+			t.Errorf("Update(existing metric) error = %v", err)
+		}
+	})
+
+	t.Run("Update rate", func(t *testing.T) {
+		if err := ts.CheckUpdate("slow", 1); err != nil {
+			t.Fatalf("first update error = %v", err)
+		}
+		if err := ts.CheckUpdate("slow", 1); !errors.Is(err, ErrTooManyUpdates) {
+			t.Errorf("second update error = %v, want %v", err, ErrTooManyUpdates)
+		}
+	})
+
+	t.Run("No quota", func(t *testing.T) {
+		for i := 0; i < 10; i++ {
+			if err := ts.CheckUpdate("free", 1); err != nil {
+				t.Fatalf("update %d error = %v", i, err)
+			}
+		}
+	})
+}
+
+func TestTenantStorageSnapshots(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+
+	ts := NewTenantStorage(NewMemStorage(), nil)
+	ts.Tenant(tenant.Default).UpdateGauge("Alloc", 1)
+	ts.Tenant("team-a").UpdateCounter("PollCount", 5)
+	if err := ts.SaveToFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, file := range []string{path, filepath.Join(filepath.Dir(path), "metrics.team-a.json")} {
+		if _, err := os.Stat(file); err != nil {
+			t.Errorf("snapshot %s: %v", file, err)
+		}
+	}
+	if size := SnapshotSize(path); size == 0 {
+		t.Error("SnapshotSize() = 0")
+	}
+
+	restored := NewTenantStorage(NewMemStorage(), nil)
+	if err := restored.LoadFromFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if m, ok := restored.Tenant("team-a").GetMetric(model.Counter, "PollCount"); !ok || *m.Delta != 5 {
+		t.Errorf("team-a PollCount = %v (found %v), want 5", m.Delta, ok)
+	}
+	if _, ok := restored.Tenant(tenant.Default).GetMetric(model.Counter, "PollCount"); ok {
+		t.Error("team-a metric restored into default tenant")
+	}
+	if m, ok := restored.Tenant(tenant.Default).GetMetric(model.Gauge, "Alloc"); !ok || *m.Value != 1 {
+		t.Errorf("default Alloc = %v (found %v), want 1", m.Value, ok)
+	}
+}
@@ -0,0 +1,153 @@
+package tenant
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/shatrunoff/yap_metrics/internal/auth"
+)
+
+// арендатор, к которому относятся запросы без токена и заголовка
+const Default = "default"
+
+// заголовок с явным указанием арендатора
+const Header = "X-Tenant"
+
+// идентификатор попадает в имя файла снимка, поэтому ограничиваем алфавит
+var validID = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+type ctxKey struct{}
+
+// кладёт арендатора в контекст
+func WithTenant(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, id)
+}
+
+// достаёт арендатора из контекста
+func FromContext(ctx context.Context) string {
+	if id, ok := ctx.Value(ctxKey{}).(string); ok && id != "" {
+		return id
+	}
+	return Default
+}
+
+// ограничения арендатора, нулевые значения — без ограничений
+type Quota struct {
+	MaxMetrics       int     `json:"max_metrics"`
+	MaxUpdatesPerSec float64 `json:"max_updates_per_sec"`
+}
+
+type Tenant struct {
+	ID string `json:"id"`
+	// sha256 токенов в hex, сами токены на сервере не хранятся
+	TokensSHA256 []string `json:"tokens_sha256"`
+	Quota        *Quota   `json:"quota,omitempty"`
+}
+
+// содержимое файла арендаторов
+type Config struct {
+	DefaultQuota Quota    `json:"default_quota"`
+	Tenants      []Tenant `json:"tenants"`
+}
+
+// проверяет идентификатор арендатора
+func ValidID(id string) bool {
+	return validID.MatchString(id)
+}
+
+// читает и проверяет файл арендаторов
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("invalid tenants file %s: %w", path, err)
+	}
+
+	seen := make(map[string]bool, len(cfg.Tenants))
+	for _, t := range cfg.Tenants {
+		if !ValidID(t.ID) {
+			return nil, fmt.Errorf("invalid tenant id %q", t.ID)
+		}
+		if seen[t.ID] {
+			return nil, fmt.Errorf("duplicate tenant id %q", t.ID)
+		}
+		seen[t.ID] = true
+	}
+
+	return &cfg, nil
+}
+
+// квота арендатора с учётом квоты по умолчанию
+func (c *Config) Quota(id string) Quota {
+	for _, t := range c.Tenants {
+		if t.ID == id && t.Quota != nil {
+			return *t.Quota
+		}
+	}
+	return c.DefaultQuota
+}
+
+// определяет арендатора по токену или заголовку X-Tenant
+type Resolver struct {
+	byToken map[string]string
+	known   map[string]bool
+}
+
+func NewResolver(cfg *Config) *Resolver {
+	res := &Resolver{
+		byToken: make(map[string]string),
+		known:   map[string]bool{Default: true},
+	}
+	for _, t := range cfg.Tenants {
+		res.known[t.ID] = true
+		for _, hash := range t.TokensSHA256 {
+			res.byToken[strings.ToLower(hash)] = t.ID
+		}
+	}
+	return res
+}
+
+// middleware определения арендатора запроса: арендатор берётся из токена,
+// заголовок X-Tenant принимается только от администратора (isAdmin) или
+// должен совпадать с арендатором токена; без того и другого — арендатор по умолчанию
+func Middleware(res *Resolver, isAdmin func(r *http.Request) bool) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header := r.Header.Get(Header)
+			id, bound := "", false
+			if token := auth.BearerToken(r); token != "" {
+				id, bound = res.byToken[auth.HashToken(token)]
+			}
+
+			switch {
+			case bound && header != "" && header != id:
+				http.Error(w, "ERROR: tenant header does not match token", http.StatusForbidden)
+				return
+			case !bound && header != "":
+				if isAdmin == nil || !isAdmin(r) {
+					http.Error(w, "ERROR: tenant header requires an admin token", http.StatusForbidden)
+					return
+				}
+				id = header
+			case !bound:
+				id = Default
+			}
+
+			if !res.known[id] {
+				http.Error(w, "ERROR: unknown tenant", http.StatusForbidden)
+				return
+			}
+
+			h.ServeHTTP(w, r.WithContext(WithTenant(r.Context(), id)))
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package tenant
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/shatrunoff/yap_metrics/internal/auth"
+)
+
+func TestLoadConfig(t *testing.T) {
+	hash := auth.HashToken("team-a")
+	tests := []struct {
+		name    string
+		data    string
+		wantErr bool
+	}{
+		{name: "Valid", data: `{"default_quota":{"max_metrics":10},"tenants":[{"id":"team-a","tokens_sha256":["` + hash + `"]}]}`},
+		{name: "Invalid id", data: `{"tenants":[{"id":"../etc"}]}`, wantErr: true},
+		{name: "Duplicate id", data: `{"tenants":[{"id":"a"},{"id":"a"}]}`, wantErr: true},
+		{name: "Invalid JSON", data: `{"tenants":`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "tenants.json")
+			if err := os.WriteFile(path, []byte(tt.data), 0600); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := LoadConfig(path); (err != nil) != tt.wantErr {
+				t.Errorf("LoadConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestConfigQuota(t *testing.T) {
+	cfg := &Config{
+		DefaultQuota: Quota{MaxMetrics: 100},
+		Tenants: []Tenant{
+			{ID: "small", Quota: &Quota{MaxMetrics: 5, MaxUpdatesPerSec: 1}},
+			{ID: "plain"},
+		},
+	}
+	if q := cfg.Quota("small"); q.MaxMetrics != 5 || q.MaxUpdatesPerSec != 1 {
+		t.Errorf("Quota(small) = %+v, want own quota", q)
+	}
+	if q := cfg.Quota("plain"); q.MaxMetrics != 100 {
+		t.Errorf("Quota(plain) = %+v, want default quota", q)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	res := NewResolver(&Config{Tenants: []Tenant{
+		{ID: "team-a", TokensSHA256: []string{auth.HashToken("token-a")}},
+		{ID: "team-b", TokensSHA256: []string{auth.HashToken("token-b")}},
+	}})
+	isAdmin := func(r *http.Request) bool {
+		return auth.BearerToken(r) == "admin-token"
+	}
+
+	tests := []struct {
+		name   string
+		token  string
+		header string
+		status int
+		tenant string
+	}{
+		{name: "Anonymous request", status: http.StatusOK, tenant: Default},
+		{name: "Tenant token", token: "token-a", status: http.StatusOK, tenant: "team-a"},
+		{name: "Tenant token with matching header", token: "token-b", header: "team-b", status: http.StatusOK, tenant: "team-b"},
+		{name: "Tenant token with other tenant header", token: "token-a", header: "team-b", status: http.StatusForbidden},
+		{name: "Anonymous header", header: "team-a", status: http.StatusForbidden},
+		{name: "Generic token header", token: "writer-token", header: "team-a", status: http.StatusForbidden},
+		{name: "Admin header", token: "admin-token", header: "team-b", status: http.StatusOK, tenant: "team-b"},
+		{name: "Admin unknown tenant", token: "admin-token", header: "team-z", status: http.StatusForbidden},
+		{name: "Generic token without header", token: "writer-token", status: http.StatusOK, tenant: Default},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			h := Middleware(res, isAdmin)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = FromContext(r.Context())
+			}))
+
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.token != "" {
+				request.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			if tt.header != "" {
+				request.Header.Set(Header, tt.header)
+			}
+			recorder := httptest.NewRecorder()
+			h.ServeHTTP(recorder, request)
+
+			if recorder.Code != tt.status {
+				t.Fatalf("status = %d, want %d", recorder.Code, tt.status)
+			}
+			if tt.status == http.StatusOK && got != tt.tenant {
+				t.Errorf("tenant = %q, want %q", got, tt.tenant)
+			}
+		})
+	}
+
+	t.Run("Header ignored without admin check", func(t *testing.T) {
+		h := Middleware(res, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.Header.Set("Authorization", "Bearer admin-token")
+		request.Header.Set(Header, "team-a")
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, request)
+		if recorder.Code != http.StatusForbidden {
+			t.Errorf("status = %d, want %d", recorder.Code, http.StatusForbidden)
+		}
+	})
+}
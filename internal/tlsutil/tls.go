@@ -0,0 +1,155 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// пара сертификат/ключ, перечитываемая с диска при изменении файлов
+type CertReloader struct {
+	certFile string
+	keyFile  string
+
+	cert      *tls.Certificate
+	certMtime time.Time
+	keyMtime  time.Time
+	mu        sync.Mutex
+}
+
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	cr := &CertReloader{certFile: certFile, keyFile: keyFile}
+	if err := cr.reload(); err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+func modTime(path string) (time.Time, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
+
+// загружает пару заново, вызывается под mu или до начала использования
+func (cr *CertReloader) reload() error {
+	certMtime, err := modTime(cr.certFile)
+	if err != nil {
+		return err
+	}
+	keyMtime, err := modTime(cr.keyFile)
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load key pair %s/%s: %w", cr.certFile, cr.keyFile, err)
+	}
+
+	cr.cert = &cert
+	cr.certMtime = certMtime
+	cr.keyMtime = keyMtime
+	return nil
+}
+
+// текущий сертификат; при изменении файлов перечитывает их,
+// а при ошибке чтения продолжает отдавать прежний
+func (cr *CertReloader) current() *tls.Certificate {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+
+	certMtime, certErr := modTime(cr.certFile)
+	keyMtime, keyErr := modTime(cr.keyFile)
+	if certErr == nil && keyErr == nil &&
+		(!certMtime.Equal(cr.certMtime) || !keyMtime.Equal(cr.keyMtime)) {
+		if err := cr.reload(); err != nil {
+			log.Printf("WARNING: keeping previous certificate: %v", err)
+		} else {
+			log.Printf("Certificate reloaded from %s", cr.certFile)
+		}
+	}
+	return cr.cert
+}
+
+// для tls.Config.GetCertificate
+func (cr *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return cr.current(), nil
+}
+
+// для tls.Config.GetClientCertificate
+func (cr *CertReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return cr.current(), nil
+}
+
+// читает PEM-бандл сертификатов удостоверяющих центров
+func LoadCertPool(caFile string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %s", caFile)
+	}
+	return pool, nil
+}
+
+// TLS сервера; при заданном clientCAFile клиент обязан предъявить
+// сертификат, подписанный одним из этих центров (mTLS)
+func ServerConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	reloader, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}
+
+	if clientCAFile != "" {
+		pool, err := LoadCertPool(clientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return cfg, nil
+}
+
+// TLS клиента: caFile закрепляет доверенные центры вместо системных,
+// certFile/keyFile задают клиентский сертификат для mTLS
+func ClientConfig(caFile, certFile, keyFile string) (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if caFile != "" {
+		pool, err := LoadCertPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = pool
+	}
+
+	if certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			return nil, fmt.Errorf("both client certificate and key are required")
+		}
+		reloader, err := NewCertReloader(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.GetClientCertificate = reloader.GetClientCertificate
+	}
+
+	return cfg, nil
+}
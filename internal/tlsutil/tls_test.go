@@ -0,0 +1,194 @@
+package tlsutil
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+// выпускает сертификат; parent == nil — самоподписанный CA
+func issue(t *testing.T, cn string, serial int64, parent *testCert, usage x509.ExtKeyUsage) *testCert {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	signerCert, signerKey := tmpl, key
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+	} else {
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{usage}
+		signerCert, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signerCert, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+// записывает сертификат и ключ в PEM-файлы
+func (c *testCert) write(t *testing.T, certFile, keyFile string) {
+	t.Helper()
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der})
+	if err := os.WriteFile(certFile, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if keyFile == "" {
+		return
+	}
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err := os.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	path := func(name string) string { return filepath.Join(dir, name) }
+
+	ca := issue(t, "test-ca", 1, nil, 0)
+	ca.write(t, path("ca.pem"), "")
+	issue(t, "server", 2, ca, x509.ExtKeyUsageServerAuth).write(t, path("server.pem"), path("server.key"))
+	issue(t, "agent", 3, ca, x509.ExtKeyUsageClientAuth).write(t, path("agent.pem"), path("agent.key"))
+
+	otherCA := issue(t, "other-ca", 4, nil, 0)
+	otherCA.write(t, path("other-ca.pem"), "")
+	issue(t, "intruder", 5, otherCA, x509.ExtKeyUsageClientAuth).write(t, path("intruder.pem"), path("intruder.key"))
+
+	serverTLS, err := ServerConfig(path("server.pem"), path("server.key"), path("ca.pem"))
+	if err != nil {
+		t.Fatalf("ServerConfig() error = %v", err)
+	}
+
+	// httptest.StartTLS подменяет сертификат своим, поэтому слушаем сами
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", serverTLS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{
+		Handler:  http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+		ErrorLog: log.New(io.Discard, "", 0),
+	}
+	go srv.Serve(listener)
+	defer srv.Close()
+	url := "https://" + listener.Addr().String()
+
+	tests := []struct {
+		name    string
+		caFile  string
+		cert    string
+		key     string
+		wantErr bool
+	}{
+		{name: "Trusted client certificate", caFile: "ca.pem", cert: "agent.pem", key: "agent.key"},
+		{name: "No client certificate", caFile: "ca.pem", wantErr: true},
+		{name: "Client certificate from other CA", caFile: "ca.pem", cert: "intruder.pem", key: "intruder.key", wantErr: true},
+		{name: "Server not signed by pinned CA", caFile: "other-ca.pem", cert: "agent.pem", key: "agent.key", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var certFile, keyFile string
+			if tt.cert != "" {
+				certFile, keyFile = path(tt.cert), path(tt.key)
+			}
+			clientTLS, err := ClientConfig(path(tt.caFile), certFile, keyFile)
+			if err != nil {
+				t.Fatalf("ClientConfig() error = %v", err)
+			}
+
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientTLS}}
+			response, err := client.Get(url)
+			if err == nil {
+				response.Body.Close()
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GET error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "server.pem"), filepath.Join(dir, "server.key")
+
+	ca := issue(t, "test-ca", 1, nil, 0)
+	issue(t, "server", 10, ca, x509.ExtKeyUsageServerAuth).write(t, certFile, keyFile)
+
+	reloader, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("NewCertReloader() error = %v", err)
+	}
+
+	serial := func() int64 {
+		cert, _ := reloader.GetCertificate(&tls.ClientHelloInfo{})
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return leaf.SerialNumber.Int64()
+	}
+
+	if got := serial(); got != 10 {
+		t.Fatalf("initial serial = %d, want 10", got)
+	}
+
+	// новый сертификат на диске подхватывается без перезапуска
+	issue(t, "server", 11, ca, x509.ExtKeyUsageServerAuth).write(t, certFile, keyFile)
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, future, future)
+	os.Chtimes(keyFile, future, future)
+	if got := serial(); got != 11 {
+		t.Errorf("serial after reload = %d, want 11", got)
+	}
+
+	// битый файл не ломает уже загруженный сертификат
+	os.WriteFile(certFile, []byte("garbage"), 0600)
+	later := future.Add(time.Minute)
+	os.Chtimes(certFile, later, later)
+	if got := serial(); got != 11 {
+		t.Errorf("serial after broken reload = %d, want 11", got)
+	}
+}